@@ -0,0 +1,295 @@
+// Package abac converts Kubernetes ABAC policy files into RBAC policy.
+//
+// An ABAC policy file holds one policy object per line, either in the
+// abac.authorization.kubernetes.io/v1beta1 format or in the unversioned format. Each grant is
+// turned into a rule of a Role, for a single namespace, or of a ClusterRole, for all namespaces
+// and non-resource paths. Subjects that are granted the same set of rules in the same scope
+// share a role and its binding. Grants that RBAC cannot express are returned as Issues.
+package abac
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// readOnlyVerbs are the verbs allowed by ABAC policies with readonly set.
+var readOnlyVerbs = []string{"get", "list", "watch"}
+
+// Spec is the ABAC policy of a line.
+type Spec struct {
+	User            string `json:"user,omitempty"`
+	Group           string `json:"group,omitempty"`
+	Readonly        bool   `json:"readonly,omitempty"`
+	APIGroup        string `json:"apiGroup,omitempty"`
+	Resource        string `json:"resource,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	NonResourcePath string `json:"nonResourcePath,omitempty"`
+}
+
+// policy is a line of an ABAC policy file in the versioned format.
+type policy struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Spec       *Spec  `json:"spec,omitempty"`
+}
+
+// allAuthenticated is the group of all authenticated users.
+const allAuthenticated = "system:authenticated"
+
+// Issue is ABAC semantics that could not be expressed exactly.
+type Issue struct {
+	// Line of the ABAC policy file.
+	Line int `json:"line"`
+	// Message describes the issue.
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// Result of a conversion.
+type Result struct {
+	// Policy holds the converted roles, cluster roles and bindings.
+	Policy api.Policy
+	// Issues holds the grants that were not converted.
+	Issues []Issue
+}
+
+// grant is a rule given to a subject in a scope. The scope is a namespace, or the
+// empty string for cluster roles.
+type grant struct {
+	subject api.Subject
+	scope   string
+	rule    api.PolicyRule
+}
+
+// Convert reads an ABAC policy file and converts it. An error is returned if the file
+// cannot be parsed. Blank lines and lines starting with '#' are ignored.
+func Convert(r io.Reader) (*Result, error) {
+	res := &Result{}
+	grants := []grant{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		p := policy{}
+		if err := json.Unmarshal([]byte(text), &p); err != nil {
+			return nil, fmt.Errorf("Error parsing ABAC policy on line %d: %v", n, err)
+		}
+		spec := Spec{}
+		switch {
+		case p.APIVersion != "" || p.Kind != "":
+			if p.Kind != "Policy" || p.Spec == nil {
+				return nil, fmt.Errorf("Error parsing ABAC policy on line %d: expected a Policy with a spec", n)
+			}
+			spec = *p.Spec
+		default:
+			if err := json.Unmarshal([]byte(text), &spec); err != nil {
+				return nil, fmt.Errorf("Error parsing ABAC policy on line %d: %v", n, err)
+			}
+			spec = fromUnversioned(spec)
+		}
+		grants = append(grants, convertSpec(n, spec, res)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	res.Policy = buildPolicy(grants)
+	return res, nil
+}
+
+// fromUnversioned returns the versioned equivalent of an unversioned policy, in which empty
+// fields are unrestricted and policies only apply to authenticated users.
+func fromUnversioned(in Spec) Spec {
+	out := in
+	if in.User == "" && in.Group == "" || in.User == "*" || in.Group == "*" {
+		out.User, out.Group = "", allAuthenticated
+	}
+	if in.Namespace == "" {
+		out.Namespace = "*"
+	}
+	if in.Resource == "" {
+		out.Resource = "*"
+	}
+	out.APIGroup = "*"
+	if in.Namespace == "" && in.Resource == "" {
+		out.NonResourcePath = "*"
+	}
+	return out
+}
+
+// convertSpec returns the grants of an ABAC policy, and records the issues that prevent
+// converting it exactly.
+func convertSpec(n int, spec Spec, res *Result) []grant {
+	report := func(format string, args ...interface{}) {
+		res.Issues = append(res.Issues, Issue{Line: n, Message: fmt.Sprintf(format, args...)})
+	}
+
+	subject, ok := convertSubject(spec)
+	if !ok {
+		if spec.User == "" && spec.Group == "" {
+			report("the policy has no user or group, so it matches no request; not converted")
+		} else {
+			report("the policy requires both user '%s' and group '%s', which RBAC subjects cannot express; not converted", spec.User, spec.Group)
+		}
+		return nil
+	}
+
+	verbs := []string{api.VerbAll}
+	if spec.Readonly {
+		verbs = readOnlyVerbs
+	}
+
+	grants := []grant{}
+	switch {
+	case spec.Resource == "":
+		if spec.Namespace != "" || spec.APIGroup != "" {
+			report("the policy sets a namespace or API group but no resource, so it matches no resource request; resource access not converted")
+		}
+	case spec.Namespace == "":
+		report("the policy has no namespace, which in ABAC only matches cluster-scoped resources and cannot be expressed in RBAC; resource access not converted")
+	default:
+		scope := spec.Namespace
+		if scope == "*" {
+			scope = ""
+		}
+		grants = append(grants, grant{
+			subject: subject,
+			scope:   scope,
+			rule: api.PolicyRule{
+				Verbs:     verbs,
+				APIGroups: []string{spec.APIGroup},
+				Resources: []string{spec.Resource},
+			},
+		})
+	}
+
+	if spec.NonResourcePath != "" {
+		if spec.NonResourcePath != api.NonResourceAll && strings.HasSuffix(spec.NonResourcePath, "*") {
+			report("the non-resource path prefix '%s' cannot be expressed in RBAC, which only matches exact paths or '*'; non-resource access not converted", spec.NonResourcePath)
+		} else {
+			grants = append(grants, grant{
+				subject: subject,
+				rule: api.PolicyRule{
+					Verbs:           verbs,
+					NonResourceURLs: []string{spec.NonResourcePath},
+				},
+			})
+		}
+	}
+	return grants
+}
+
+// convertSubject returns the RBAC subject that matches the same users as the ABAC policy.
+func convertSubject(spec Spec) (api.Subject, bool) {
+	user, group := spec.User, spec.Group
+	switch {
+	case user == "*" || group == "*":
+		// A wildcard matches every user, so the other field alone decides the match
+		if user != "" && user != "*" {
+			return api.Subject{Kind: api.UserKind, Name: user}, true
+		}
+		if group != "" && group != "*" {
+			return api.Subject{Kind: api.GroupKind, Name: group}, true
+		}
+		return api.Subject{Kind: api.UserKind, Name: api.UserAll}, true
+	case user != "" && group != "":
+		return api.Subject{}, false
+	case user != "":
+		return api.Subject{Kind: api.UserKind, Name: user}, true
+	case group != "":
+		return api.Subject{Kind: api.GroupKind, Name: group}, true
+	}
+	return api.Subject{}, false
+}
+
+// buildPolicy groups the rules of every subject by scope, and creates a role and a binding
+// for every distinct set of rules in a scope, shared by all the subjects that have it.
+func buildPolicy(grants []grant) api.Policy {
+	type subjectScope struct {
+		subject api.Subject
+		scope   string
+	}
+	order := []subjectScope{}
+	rules := map[subjectScope][]api.PolicyRule{}
+	for _, g := range grants {
+		key := subjectScope{g.subject, g.scope}
+		if _, ok := rules[key]; !ok {
+			order = append(order, key)
+		}
+		if !containsRule(rules[key], g.rule) {
+			rules[key] = append(rules[key], g.rule)
+		}
+	}
+
+	type roleKey struct {
+		scope string
+		rules string
+	}
+	roleOrder := []roleKey{}
+	roleRules := map[roleKey][]api.PolicyRule{}
+	roleSubjects := map[roleKey][]api.Subject{}
+	for _, key := range order {
+		rs := rules[key]
+		sortRules(rs)
+		encoded, _ := json.Marshal(rs)
+		rk := roleKey{key.scope, string(encoded)}
+		if _, ok := roleRules[rk]; !ok {
+			roleOrder = append(roleOrder, rk)
+			roleRules[rk] = rs
+		}
+		roleSubjects[rk] = append(roleSubjects[rk], key.subject)
+	}
+
+	p := api.Policy{}
+	for i, rk := range roleOrder {
+		name := fmt.Sprintf("abac-%d", i+1)
+		if rk.scope == "" {
+			p.ClusterRoles = append(p.ClusterRoles, api.ClusterRole{Name: name, Rules: roleRules[rk]})
+			p.ClusterRoleBindings = append(p.ClusterRoleBindings, api.ClusterRoleBinding{
+				Name:     name,
+				Subjects: roleSubjects[rk],
+				RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: name},
+			})
+			continue
+		}
+		p.Roles = append(p.Roles, api.Role{Name: name, Namespace: rk.scope, Rules: roleRules[rk]})
+		p.RoleBindings = append(p.RoleBindings, api.RoleBinding{
+			Name:      name,
+			Namespace: rk.scope,
+			Subjects:  roleSubjects[rk],
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: name, Namespace: rk.scope},
+		})
+	}
+	return p
+}
+
+func containsRule(rules []api.PolicyRule, rule api.PolicyRule) bool {
+	encoded, _ := json.Marshal(rule)
+	for _, r := range rules {
+		if e, _ := json.Marshal(r); string(e) == string(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortRules orders rules so that the same set of rules always has the same encoding.
+func sortRules(rules []api.PolicyRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		a, _ := json.Marshal(rules[i])
+		b, _ := json.Marshal(rules[j])
+		return string(a) < string(b)
+	})
+}
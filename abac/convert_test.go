@@ -0,0 +1,117 @@
+package abac
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+const testPolicy = `
+# Admins can do everything
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "admin", "namespace": "*", "resource": "*", "apiGroup": "*", "nonResourcePath": "*"}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"group": "ops", "namespace": "*", "resource": "*", "apiGroup": "*", "nonResourcePath": "*"}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "alice", "namespace": "projectCaribou", "resource": "pods", "readonly": true}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "bob", "namespace": "projectCaribou", "resource": "pods", "readonly": true}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "bob", "namespace": "projectCaribou", "resource": "deployments", "apiGroup": "extensions"}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "carol", "group": "devs", "namespace": "*", "resource": "*", "apiGroup": "*"}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "*", "nonResourcePath": "/apis/*", "readonly": true}}
+{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Policy", "spec": {"user": "dave", "resource": "nodes", "readonly": true}}
+{"user": "legacy", "readonly": true}
+`
+
+func TestConvert(t *testing.T) {
+	res, err := Convert(strings.NewReader(testPolicy))
+	if err != nil {
+		t.Fatalf("Error converting: %v", err)
+	}
+
+	all := api.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}
+	allNonResource := api.PolicyRule{Verbs: []string{"*"}, NonResourceURLs: []string{"*"}}
+	readPods := api.PolicyRule{Verbs: readOnlyVerbs, APIGroups: []string{""}, Resources: []string{"pods"}}
+	editDeployments := api.PolicyRule{Verbs: []string{"*"}, APIGroups: []string{"extensions"}, Resources: []string{"deployments"}}
+	readAll := api.PolicyRule{Verbs: readOnlyVerbs, APIGroups: []string{"*"}, Resources: []string{"*"}}
+	readAllNonResource := api.PolicyRule{Verbs: readOnlyVerbs, NonResourceURLs: []string{"*"}}
+
+	expected := api.Policy{
+		Roles: []api.Role{
+			{Name: "abac-2", Namespace: "projectCaribou", Rules: []api.PolicyRule{readPods}},
+			{Name: "abac-3", Namespace: "projectCaribou", Rules: []api.PolicyRule{editDeployments, readPods}},
+		},
+		RoleBindings: []api.RoleBinding{
+			{
+				Name:      "abac-2",
+				Namespace: "projectCaribou",
+				Subjects:  []api.Subject{{Kind: api.UserKind, Name: "alice"}},
+				RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "abac-2", Namespace: "projectCaribou"},
+			},
+			{
+				Name:      "abac-3",
+				Namespace: "projectCaribou",
+				Subjects:  []api.Subject{{Kind: api.UserKind, Name: "bob"}},
+				RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "abac-3", Namespace: "projectCaribou"},
+			},
+		},
+		ClusterRoles: []api.ClusterRole{
+			{Name: "abac-1", Rules: []api.PolicyRule{all, allNonResource}},
+			{Name: "abac-4", Rules: []api.PolicyRule{readAll, readAllNonResource}},
+		},
+		ClusterRoleBindings: []api.ClusterRoleBinding{
+			{
+				Name:     "abac-1",
+				Subjects: []api.Subject{{Kind: api.UserKind, Name: "admin"}, {Kind: api.GroupKind, Name: "ops"}},
+				RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "abac-1"},
+			},
+			{
+				Name:     "abac-4",
+				Subjects: []api.Subject{{Kind: api.UserKind, Name: "legacy"}},
+				RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "abac-4"},
+			},
+		},
+	}
+	sortRulesOf(expected)
+	if !reflect.DeepEqual(res.Policy, expected) {
+		t.Errorf("Expected policy:\n%+v\ngot:\n%+v", expected, res.Policy)
+	}
+	if err = repository.ValidatePolicy(res.Policy); err != nil {
+		t.Errorf("Expected converted policy to be valid: %v", err)
+	}
+
+	lines := []int{}
+	for _, i := range res.Issues {
+		lines = append(lines, i.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{8, 9, 10}) {
+		t.Errorf("Expected issues on lines 8, 9 and 10, got %v", res.Issues)
+	}
+}
+
+func sortRulesOf(p api.Policy) {
+	for _, r := range p.Roles {
+		sortRules(r.Rules)
+	}
+	for _, cr := range p.ClusterRoles {
+		sortRules(cr.Rules)
+	}
+}
+
+func TestConvertMalformed(t *testing.T) {
+	for _, policy := range []string{
+		`{"user": "alice"`,
+		`{"apiVersion": "abac.authorization.kubernetes.io/v1beta1", "kind": "Other", "spec": {}}`,
+	} {
+		if _, err := Convert(strings.NewReader(policy)); err == nil {
+			t.Errorf("Expected error converting %s", policy)
+		}
+	}
+}
+
+func TestFromUnversioned(t *testing.T) {
+	got := fromUnversioned(Spec{User: "*", Resource: "pods"})
+	expected := Spec{Group: allAuthenticated, Namespace: "*", Resource: "pods", APIGroup: "*"}
+	if got != expected {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+}
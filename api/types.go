@@ -23,6 +23,10 @@ const (
 	RoleKind = "Role"
 	// ClusterRoleKind is the cluster role object reference Kind
 	ClusterRoleKind = "ClusterRole"
+	// RoleBindingKind is the role binding Kind
+	RoleBindingKind = "RoleBinding"
+	// ClusterRoleBindingKind is the cluster role binding Kind
+	ClusterRoleBindingKind = "ClusterRoleBinding"
 )
 
 // ObjectReference used to reference another object in the API
@@ -69,6 +73,17 @@ type Role struct {
 	Name string `json:"name,omitempty"`
 	// Namespace where this role exists.
 	Namespace string `json:"namespace,omitempty"`
+	// UID uniquely identifies this role across its lifetime. It is assigned by the repository on creation.
+	UID string `json:"uid,omitempty"`
+	// ResourceVersion is an opaque value that changes every time this role is written. Updates and deletes that
+	// specify a ResourceVersion fail with a conflict if it no longer matches the stored one.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// CreationTimestamp is the RFC 3339 time at which this role was created. It is assigned by the repository.
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+	// Labels are key/value pairs that can be used to select this role in list calls.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are arbitrary key/value pairs that hold non-identifying metadata about this role.
+	Annotations map[string]string `json:"annotations,omitempty"`
 	// Rules holds all the PolicyRules for this Role
 	Rules []PolicyRule `json:"rules"`
 }
@@ -81,6 +96,17 @@ type RoleBinding struct {
 	Name string `json:"name,omitempty"`
 	// Namespace where this rolebinding exists.
 	Namespace string `json:"namespace,omitempty"`
+	// UID uniquely identifies this role binding across its lifetime. It is assigned by the repository on creation.
+	UID string `json:"uid,omitempty"`
+	// ResourceVersion is an opaque value that changes every time this role binding is written. Updates and deletes that
+	// specify a ResourceVersion fail with a conflict if it no longer matches the stored one.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// CreationTimestamp is the RFC 3339 time at which this role binding was created. It is assigned by the repository.
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+	// Labels are key/value pairs that can be used to select this role binding in list calls.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are arbitrary key/value pairs that hold non-identifying metadata about this role binding.
+	Annotations map[string]string `json:"annotations,omitempty"`
 	// Subjects holds references to the objects the role applies to.
 	Subjects []Subject `json:"subjects"`
 	// Role in the current namespace or a ClusterRole in the global namespace.
@@ -91,6 +117,17 @@ type RoleBinding struct {
 type ClusterRole struct {
 	// Name of the cluster role
 	Name string `json:"name,omitempty"`
+	// UID uniquely identifies this cluster role across its lifetime. It is assigned by the repository on creation.
+	UID string `json:"uid,omitempty"`
+	// ResourceVersion is an opaque value that changes every time this cluster role is written. Updates and deletes that
+	// specify a ResourceVersion fail with a conflict if it no longer matches the stored one.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// CreationTimestamp is the RFC 3339 time at which this cluster role was created. It is assigned by the repository.
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+	// Labels are key/value pairs that can be used to select this cluster role in list calls.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are arbitrary key/value pairs that hold non-identifying metadata about this cluster role.
+	Annotations map[string]string `json:"annotations,omitempty"`
 	// Rules of this ClusterRole
 	Rules []PolicyRule `json:"rules"`
 }
@@ -99,8 +136,63 @@ type ClusterRole struct {
 type ClusterRoleBinding struct {
 	// Name of the cluster role binding
 	Name string `json:"name,omitempty"`
+	// UID uniquely identifies this cluster role binding across its lifetime. It is assigned by the repository on creation.
+	UID string `json:"uid,omitempty"`
+	// ResourceVersion is an opaque value that changes every time this cluster role binding is written. Updates and deletes that
+	// specify a ResourceVersion fail with a conflict if it no longer matches the stored one.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// CreationTimestamp is the RFC 3339 time at which this cluster role binding was created. It is assigned by the repository.
+	CreationTimestamp string `json:"creationTimestamp,omitempty"`
+	// Labels are key/value pairs that can be used to select this cluster role binding in list calls.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are arbitrary key/value pairs that hold non-identifying metadata about this cluster role binding.
+	Annotations map[string]string `json:"annotations,omitempty"`
 	// Subjects is the set of subjects that this role applies to
 	Subjects []Subject `json:"subjects"`
 	// RoleRef references the ClusterRole
 	RoleRef ObjectReference `json:"roleRef"`
 }
+
+// RoleList is a page of roles returned by a list call.
+type RoleList struct {
+	// Items holds the roles in this page.
+	Items []Role `json:"items"`
+	// Continue is set when more roles are available. It can be used to request the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// RoleBindingList is a page of role bindings returned by a list call.
+type RoleBindingList struct {
+	// Items holds the role bindings in this page.
+	Items []RoleBinding `json:"items"`
+	// Continue is set when more role bindings are available. It can be used to request the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ClusterRoleList is a page of cluster roles returned by a list call.
+type ClusterRoleList struct {
+	// Items holds the cluster roles in this page.
+	Items []ClusterRole `json:"items"`
+	// Continue is set when more cluster roles are available. It can be used to request the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ClusterRoleBindingList is a page of cluster role bindings returned by a list call.
+type ClusterRoleBindingList struct {
+	// Items holds the cluster role bindings in this page.
+	Items []ClusterRoleBinding `json:"items"`
+	// Continue is set when more cluster role bindings are available. It can be used to request the next page.
+	Continue string `json:"continue,omitempty"`
+}
+
+// Policy is a complete set of policy objects, as defined in a policy file.
+type Policy struct {
+	// Roles defined in the policy.
+	Roles []Role `json:"Roles"`
+	// RoleBindings defined in the policy.
+	RoleBindings []RoleBinding `json:"RoleBindings"`
+	// ClusterRoles defined in the policy.
+	ClusterRoles []ClusterRole `json:"ClusterRoles"`
+	// ClusterRoleBindings defined in the policy.
+	ClusterRoleBindings []ClusterRoleBinding `json:"ClusterRoleBindings"`
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// runApply replaces the policy in the RBAC policy file with the given policy document,
+// and prints the objects that are created, updated and deleted.
+func runApply(args []string) error {
+	fs, repoFlags := newCommandFlagSet("apply")
+	filename := fs.StringP("filename", "f", "", "Policy document to apply")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without applying them")
+	author := fs.String("author", "", "Author of the change, in the \"Name <email>\" format, for repositories that keep history")
+	message := fs.StringP("message", "m", "", "Message describing the change, for repositories that keep history")
+	fs.Parse(args)
+
+	if *filename == "" {
+		return errors.New("--filename is required")
+	}
+
+	desired, err := readPolicyDocument(*filename)
+	if err != nil {
+		return err
+	}
+
+	repo, err := repoFlags.open()
+	if err != nil {
+		return err
+	}
+
+	changes, err := repo.Apply(desired, repository.ApplyOptions{DryRun: *dryRun, Author: *author, Message: *message})
+	if err != nil {
+		return err
+	}
+
+	printChanges(changes, *dryRun)
+	return nil
+}
+
+// printChanges prints the changes made by applying a policy, followed by a summary.
+func printChanges(changes []repository.Change, dryRun bool) {
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	switch {
+	case len(changes) == 0:
+		fmt.Println("No changes")
+	case dryRun:
+		fmt.Printf("%d changes (dry run)\n", len(changes))
+	default:
+		fmt.Printf("%d changes applied\n", len(changes))
+	}
+}
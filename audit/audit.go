@@ -0,0 +1,366 @@
+// Package audit records every authorization decision as a line of JSON, and writes it to
+// one or more sinks such as a rotating file, stdout or syslog.
+//
+// Records are written asynchronously, so that slow sinks do not delay the responses of the
+// webhook. When the sinks cannot keep up, sampled records are dropped and counted rather than
+// queued without bound, while the records that are always recorded wait until they are written.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/authorization"
+)
+
+// Decisions of the records.
+const (
+	Allowed = "allowed"
+	Denied  = "denied"
+	Error   = "error"
+)
+
+// QueueSize is the number of records that can wait to be written before sampled records are
+// dropped.
+const QueueSize = 4096
+
+// Record is the audit record of a decision.
+type Record struct {
+	// Time at which the decision was made.
+	Time time.Time `json:"time"`
+	// Endpoint that made the decision, "review" or "batch".
+	Endpoint string `json:"endpoint"`
+	// Request that was authorized.
+	Request Request `json:"request"`
+	// Decision is "allowed", "denied" or "error".
+	Decision string `json:"decision"`
+	// Reason of the decision.
+	Reason string `json:"reason,omitempty"`
+	// EvaluationError is the error that prevented the policy from being evaluated.
+	EvaluationError string `json:"evaluationError,omitempty"`
+	// Binding that allowed the request, if any.
+	Binding *authorization.BindingRef `json:"binding,omitempty"`
+	// Enforcement is the enforcement mode of the request when the decision was not enforced,
+	// e.g. "audit-only", in which case the request was not denied even if the decision is.
+	Enforcement string `json:"enforcement,omitempty"`
+	// Revision of the policy that the request was evaluated against.
+	Revision string `json:"revision,omitempty"`
+	// LatencySeconds is the time taken to make the decision. The reviews of a batch have the
+	// time taken to evaluate the whole batch.
+	LatencySeconds float64 `json:"latencySeconds"`
+}
+
+// Request is the request of a Record.
+type Request struct {
+	User        string   `json:"user,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	UID         string   `json:"uid,omitempty"`
+	Verb        string   `json:"verb,omitempty"`
+	APIGroup    string   `json:"apiGroup,omitempty"`
+	Resource    string   `json:"resource,omitempty"`
+	Subresource string   `json:"subresource,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Namespace   string   `json:"namespace,omitempty"`
+	Path        string   `json:"path,omitempty"`
+}
+
+// redactors redact a field of the request of a record, along with the other fields of the
+// record that carry its value.
+var redactors = map[string]func(r *Record, rd redactor){
+	"user": func(r *Record, rd redactor) { r.Request.User = rd.redact(r, r.Request.User) },
+	"groups": func(r *Record, rd redactor) {
+		var groups []string
+		for _, g := range r.Request.Groups {
+			if v := rd.redact(r, g); v != "" {
+				groups = append(groups, v)
+			}
+		}
+		r.Request.Groups = groups
+	},
+	"uid":  func(r *Record, rd redactor) { r.Request.UID = rd.redact(r, r.Request.UID) },
+	"name": func(r *Record, rd redactor) { r.Request.Name = rd.redact(r, r.Request.Name) },
+	"namespace": func(r *Record, rd redactor) {
+		r.Request.Namespace = rd.redact(r, r.Request.Namespace)
+		if r.Binding != nil {
+			// The binding is shared with the decision, which may be cached
+			b := *r.Binding
+			b.Namespace, b.RoleRef.Namespace = rd.redact(r, b.Namespace), rd.redact(r, b.RoleRef.Namespace)
+			r.Binding = &b
+		}
+	},
+	"path": func(r *Record, rd redactor) { r.Request.Path = rd.redact(r, r.Request.Path) },
+}
+
+// MinRedactionKeySize is the minimum size of the key of the hashes of redacted values.
+const MinRedactionKeySize = 16
+
+// redactedText replaces redacted values in the text of records when they are removed.
+const redactedText = "redacted"
+
+// redactor replaces values with a keyed hash of them, so that the records of the same value can
+// still be correlated, or removes them when it has no key.
+type redactor struct {
+	key []byte
+}
+
+func (rd redactor) value(v string) string {
+	if v == "" || rd.key == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, rd.key)
+	mac.Write([]byte(v))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// redact returns the redacted value, after redacting it in the reason and the evaluation error
+// of the record.
+func (rd redactor) redact(r *Record, v string) string {
+	redacted := rd.value(v)
+	if v == "" {
+		return redacted
+	}
+	text := redacted
+	if text == "" {
+		text = redactedText
+	}
+	r.Reason = replaceName(r.Reason, v, text)
+	r.EvaluationError = replaceName(r.EvaluationError, v, text)
+	return redacted
+}
+
+// replaceName replaces the occurrences of name in s that are not part of a longer name.
+func replaceName(s, name, replacement string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, name)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(name)
+		if (i == 0 || !isNameByte(s[i-1])) && (end == len(s) || !isNameByte(s[end])) {
+			b.WriteString(s[:i])
+			b.WriteString(replacement)
+		} else {
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+}
+
+func isNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+// ValidateRedactedFields returns an error if a field cannot be redacted.
+func ValidateRedactedFields(fields []string) error {
+	for _, f := range fields {
+		if _, ok := redactors[f]; !ok {
+			return fmt.Errorf("Invalid audit field '%s': expected one of user, groups, uid, name, namespace or path", f)
+		}
+	}
+	return nil
+}
+
+// Sink writes audit records.
+type Sink interface {
+	// Write the record, encoded as a line of JSON terminated by a newline.
+	Write(r *Record, line []byte) error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// WriterSink writes the records to a writer, such as os.Stdout. Closing it does not close the writer.
+type WriterSink struct {
+	W io.Writer
+}
+
+// Write the line to the writer.
+func (s *WriterSink) Write(r *Record, line []byte) error {
+	_, err := s.W.Write(line)
+	return err
+}
+
+// Close does nothing.
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// Options configure a Logger.
+type Options struct {
+	// AllowedSampleRate is the fraction of the allowed decisions that are recorded, from 0 to 1.
+	AllowedSampleRate float64
+	// DeniedSampleRate is the fraction of the denied decisions that are recorded, from 0 to 1.
+	// Decisions that could not be made because of an error, and the denials that were not
+	// enforced because of the audit-only mode, are always recorded.
+	DeniedSampleRate float64
+	// RedactedFields are the fields of the request that are redacted, e.g. "user" or
+	// "namespace". Their values are also redacted wherever else the record carries them, such as
+	// the binding and the reason of the decision.
+	RedactedFields []string
+	// RedactionKey is the key of the HMAC-SHA256 that replaces the redacted values. Without a
+	// key, the redacted values are removed.
+	RedactionKey []byte
+}
+
+// Logger writes the records of the decisions to its sinks. A nil Logger records nothing.
+type Logger struct {
+	sinks   []Sink
+	options Options
+	random  func() float64
+	queue   chan *Record
+	wake    chan struct{}
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	// kept are the records that are always recorded but did not fit in the queue.
+	kept    []*Record
+	dropped uint64
+}
+
+// NewLogger returns a Logger that writes to the sinks until it is closed.
+func NewLogger(options Options, sinks ...Sink) (*Logger, error) {
+	if err := ValidateRedactedFields(options.RedactedFields); err != nil {
+		return nil, err
+	}
+	if options.RedactionKey != nil && len(options.RedactionKey) < MinRedactionKeySize {
+		return nil, fmt.Errorf("Invalid audit redaction key: expected at least %d bytes, got %d", MinRedactionKeySize, len(options.RedactionKey))
+	}
+	for _, rate := range []float64{options.AllowedSampleRate, options.DeniedSampleRate} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("Invalid audit sample rate %v: expected a value from 0 to 1", rate)
+		}
+	}
+	l := &Logger{
+		sinks:   sinks,
+		options: options,
+		random:  rand.Float64,
+		queue:   make(chan *Record, QueueSize),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Log records the decision, subject to sampling. It does not wait for the record to be written.
+func (l *Logger) Log(r Record) {
+	if l == nil || !l.sampled(&r) {
+		return
+	}
+	for _, f := range l.options.RedactedFields {
+		redactors[f](&r, redactor{key: l.options.RedactionKey})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- &r:
+	default:
+		if !alwaysRecorded(&r) {
+			l.dropped++
+			return
+		}
+		l.kept = append(l.kept, &r)
+		select {
+		case l.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (l *Logger) sampled(r *Record) bool {
+	rate := 1.0
+	switch {
+	case alwaysRecorded(r):
+	case r.Decision == Allowed:
+		rate = l.options.AllowedSampleRate
+	case r.Decision == Denied:
+		rate = l.options.DeniedSampleRate
+	}
+	return rate >= 1 || (rate > 0 && l.random() < rate)
+}
+
+// alwaysRecorded returns whether the record is neither sampled nor dropped: errors, and the
+// denials of the audit-only mode, whose report counts every request that would have been denied.
+func alwaysRecorded(r *Record) bool {
+	return r.Decision == Error || (r.Decision == Denied && r.Enforcement == AuditOnly)
+}
+
+// Dropped returns the number of sampled records dropped because the sinks could not keep up.
+func (l *Logger) Dropped() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Close writes the pending records, then closes the sinks. Records logged afterwards are discarded.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+	var first error
+	for _, s := range l.sinks {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for open := true; open; {
+		select {
+		case r, ok := <-l.queue:
+			if open = ok; ok {
+				l.write(r)
+			}
+		case <-l.wake:
+		}
+		l.mu.Lock()
+		kept := l.kept
+		l.kept = nil
+		l.mu.Unlock()
+		for _, r := range kept {
+			l.write(r)
+		}
+	}
+}
+
+func (l *Logger) write(r *Record) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Error encoding audit record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	for _, s := range l.sinks {
+		if err := s.Write(r, line); err != nil {
+			log.Printf("Error writing audit record: %v", err)
+		}
+	}
+}
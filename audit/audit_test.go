@@ -0,0 +1,337 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/authorization"
+)
+
+func record(decision string) Record {
+	return Record{
+		Time:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Endpoint: "review",
+		Request:  Request{User: "alice", Groups: []string{"developers"}, Verb: "get", Resource: "pods", Namespace: "payments"},
+		Decision: decision,
+		Revision: "7",
+	}
+}
+
+func TestLogger(t *testing.T) {
+	out := &bytes.Buffer{}
+	key := []byte("0123456789abcdef")
+	l, err := NewLogger(Options{AllowedSampleRate: 1, DeniedSampleRate: 1, RedactedFields: []string{"user", "groups", "namespace"}, RedactionKey: key}, &WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := record(Allowed)
+	allowed.Binding = &authorization.BindingRef{Kind: api.RoleBindingKind, Name: "payments-developers", Namespace: "payments", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "developer", Namespace: "payments"}}
+	allowed.Reason = "allowed by RoleBinding payments/payments-developers"
+	l.Log(allowed)
+	l.Log(record(Denied))
+	if err = l.Close(); err != nil {
+		t.Fatalf("Error closing the logger: %v", err)
+	}
+	// Records logged after closing are discarded
+	l.Log(record(Allowed))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %q", out.String())
+	}
+	expected := `{"time":"2026-01-02T03:04:05Z","endpoint":"review","request":{"user":"hmac-sha256:fdbf8b1cacd3d578e062d0e41104357d","groups":["hmac-sha256:58c43dae2893472fdc897be84e4502ad"],"verb":"get","resource":"pods","namespace":"hmac-sha256:e9676209ded76e8e6eac12fbacbb5e5f"},"decision":"allowed","reason":"allowed by RoleBinding hmac-sha256:e9676209ded76e8e6eac12fbacbb5e5f/payments-developers","binding":{"kind":"RoleBinding","name":"payments-developers","namespace":"hmac-sha256:e9676209ded76e8e6eac12fbacbb5e5f","roleRef":{"kind":"Role","namespace":"hmac-sha256:e9676209ded76e8e6eac12fbacbb5e5f","name":"developer"}},"revision":"7","latencySeconds":0}`
+	if lines[0] != expected {
+		t.Errorf("Expected record:\n%s\ngot:\n%s", expected, lines[0])
+	}
+	if allowed.Binding.Namespace != "payments" {
+		t.Errorf("Expected the binding of the decision to be left unchanged, got %+v", allowed.Binding)
+	}
+	r := Record{}
+	if err = json.Unmarshal([]byte(lines[1]), &r); err != nil || r.Decision != Denied || r.Request.User != (redactor{key: key}).value("alice") {
+		t.Errorf("Expected the denied record with a redacted user, got %s, %v", lines[1], err)
+	}
+
+	if _, err = NewLogger(Options{RedactedFields: []string{"password"}}); err == nil {
+		t.Errorf("Expected error redacting an unknown field")
+	}
+	if _, err = NewLogger(Options{RedactionKey: []byte("short")}); err == nil {
+		t.Errorf("Expected error for a short redaction key")
+	}
+	if _, err = NewLogger(Options{AllowedSampleRate: 2}); err == nil {
+		t.Errorf("Expected error for a sample rate above 1")
+	}
+}
+
+func TestRedactionWithoutKey(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewLogger(Options{AllowedSampleRate: 1, DeniedSampleRate: 1, RedactedFields: []string{"user", "groups", "namespace"}}, &WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := record(Error)
+	r.Reason = "the RBAC policy could not be evaluated; denied by the fail-closed policy"
+	r.EvaluationError = "Error reading the role bindings of alice in namespace 'payments'"
+	l.Log(r)
+	l.Close()
+
+	expected := `{"time":"2026-01-02T03:04:05Z","endpoint":"review","request":{"verb":"get","resource":"pods"},"decision":"error","reason":"the RBAC policy could not be evaluated; denied by the fail-closed policy","evaluationError":"Error reading the role bindings of redacted in namespace 'redacted'","revision":"7","latencySeconds":0}` + "\n"
+	if out.String() != expected {
+		t.Errorf("Expected the redacted values to be removed:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestSampling(t *testing.T) {
+	out := &bytes.Buffer{}
+	l, err := NewLogger(Options{AllowedSampleRate: 0.25, DeniedSampleRate: 0}, &WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []float64{0.1, 0.3, 0.5, 0.2}
+	l.random = func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	}
+	for i := 0; i < 4; i++ {
+		l.Log(record(Allowed))
+		l.Log(record(Denied))
+	}
+	l.Log(record(Error))
+	auditOnly := record(Denied)
+	auditOnly.Enforcement = AuditOnly
+	l.Log(auditOnly)
+	l.Close()
+
+	if got := strings.Count(out.String(), `"decision":"allowed"`); got != 2 {
+		t.Errorf("Expected 2 sampled allowed decisions, got %d", got)
+	}
+	if got := strings.Count(out.String(), `"decision":"denied"`); got != 1 || !strings.Contains(out.String(), `"enforcement":"audit-only"`) {
+		t.Errorf("Expected only the audit-only denial with a sample rate of 0, got %d denials", got)
+	}
+	if got := strings.Count(out.String(), `"decision":"error"`); got != 1 {
+		t.Errorf("Expected errors to always be recorded, got %d", got)
+	}
+}
+
+// blockingSink counts the records written to it, and blocks them until it is released.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+	written []*Record
+}
+
+func (s *blockingSink) Write(r *Record, line []byte) error {
+	if len(s.written) == 0 {
+		close(s.started)
+	}
+	<-s.release
+	s.written = append(s.written, r)
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func TestFullQueueKeepsAlwaysRecordedRecords(t *testing.T) {
+	s := &blockingSink{started: make(chan struct{}), release: make(chan struct{})}
+	l, err := NewLogger(Options{AllowedSampleRate: 1, DeniedSampleRate: 1}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log(record(Allowed))
+	<-s.started
+	for i := 0; i < QueueSize+10; i++ {
+		l.Log(record(Allowed))
+	}
+	l.Log(record(Error))
+	auditOnly := record(Denied)
+	auditOnly.Enforcement = AuditOnly
+	l.Log(auditOnly)
+	close(s.release)
+	l.Close()
+
+	if l.Dropped() != 10 {
+		t.Errorf("Expected 10 dropped records, got %d", l.Dropped())
+	}
+	if len(s.written) != QueueSize+3 {
+		t.Fatalf("Expected %d records, got %d", QueueSize+3, len(s.written))
+	}
+	kept := map[string]bool{}
+	for _, r := range s.written {
+		kept[r.Decision+r.Enforcement] = true
+	}
+	if !kept[Error] || !kept[Denied+AuditOnly] {
+		t.Errorf("Expected the error and the audit-only denial to be written, got %v", kept)
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	line := []byte(strings.Repeat("x", 9) + "\n")
+	s, err := NewFileSink(path, 25, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two lines fit in a file, so the fifth line is the only one of the current file
+	for i := 0; i < 5; i++ {
+		if err = s.Write(&Record{}, line); err != nil {
+			t.Fatalf("Error writing record: %v", err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, size := range map[string]int{"audit.log": 10, "audit.log.1": 20, "audit.log.2": 20} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || len(data) != size {
+			t.Errorf("Expected %s to hold %d bytes, got %d, %v", name, size, len(data), err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 rotated files to be kept")
+	}
+
+	// Records are appended to an existing file
+	if s, err = NewFileSink(path, 25, 2); err != nil {
+		t.Fatal(err)
+	}
+	s.Write(&Record{}, line)
+	s.Close()
+	if data, _ := ioutil.ReadFile(path); len(data) != 20 {
+		t.Errorf("Expected the record to be appended, got %q", data)
+	}
+}
+
+var syslogMessage = regexp.MustCompile(`^<(\d+)>1 2026-01-02T03:04:05\.000000Z \S+ kubernetes-rbac \d+ audit - (\{.*\})$`)
+
+func TestSyslogSink(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	s, err := NewSyslogSink("udp://"+udp.LocalAddr().String(), "local1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := record(Denied)
+	line, _ := json.Marshal(r)
+	if err = s.Write(&r, append(line, '\n')); err != nil {
+		t.Fatalf("Error sending record: %v", err)
+	}
+	s.Close()
+
+	buf := make([]byte, 4096)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := syslogMessage.FindStringSubmatch(string(buf[:n]))
+	// local1 (17) * 8 + notice (5)
+	if m == nil || m[1] != "141" || m[2] != string(line) {
+		t.Errorf("Expected an RFC 5424 message with priority 141 and the record, got %q", buf[:n])
+	}
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	if s, err = NewSyslogSink("tcp://"+tcp.Addr().String(), ""); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tcp.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r = record(Allowed)
+	line, _ = json.Marshal(r)
+	s.Write(&r, append(line, '\n'))
+	s.Close()
+
+	framed, err := ioutil.ReadAll(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(string(framed), " ", 2)
+	if len(parts) != 2 || parts[0] != strconv.Itoa(len(parts[1])) {
+		t.Fatalf("Expected an octet counted message, got %q", framed)
+	}
+	m = syslogMessage.FindStringSubmatch(parts[1])
+	// local0 (16) * 8 + informational (6)
+	if m == nil || m[1] != "134" || m[2] != string(line) {
+		t.Errorf("Expected an RFC 5424 message with priority 134 and the record, got %q", parts[1])
+	}
+
+	for _, u := range []string{"http://localhost:514", "udp://", "udp://localhost:514?"} {
+		if _, err = NewSyslogSink(u, "local9"); err == nil {
+			t.Errorf("Expected error for syslog %s with an unknown facility", u)
+		}
+	}
+}
+
+func TestDenialReport(t *testing.T) {
+	records := []Record{record(Denied), record(Denied), record(Denied), record(Allowed), record(Denied)}
+	for i := range records {
+		records[i].Time = records[i].Time.Add(time.Duration(i) * time.Hour)
+		records[i].Enforcement = AuditOnly
+	}
+	// A denial of another action
+	records[1].Request.Verb, records[1].Request.APIGroup, records[1].Request.Resource, records[1].Request.Subresource = "update", "apps", "deployments", "scale"
+	// An enforced denial
+	records[2].Enforcement = ""
+	records[4].Request.Groups = []string{"developers", "oncall"}
+	logs := &bytes.Buffer{}
+	for _, r := range records {
+		line, _ := json.Marshal(r)
+		logs.Write(append(line, '\n'))
+	}
+	logs.WriteString("\n")
+
+	rep := &DenialReport{}
+	if err := rep.Read(bytes.NewReader(logs.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	denials := rep.Denials()
+	if len(denials) != 2 {
+		t.Fatalf("Expected 2 denials, got %+v", denials)
+	}
+	if d := denials[0]; d.Count != 2 || d.Action() != "pods" || d.Verb != "get" || !d.First.Equal(records[0].Time) || !d.Last.Equal(records[4].Time) || strings.Join(d.Groups, ",") != "developers,oncall" {
+		t.Errorf("Expected 2 denials of getting pods, got %+v", d)
+	}
+	if d := denials[1]; d.Count != 1 || d.Action() != "apps/deployments/scale" || d.User != "alice" || d.Namespace != "payments" {
+		t.Errorf("Expected 1 denial of scaling deployments, got %+v", d)
+	}
+
+	rep = &DenialReport{Since: records[3].Time}
+	if err := rep.Read(bytes.NewReader(logs.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if denials = rep.Denials(); len(denials) != 1 || denials[0].Count != 1 {
+		t.Errorf("Expected only the last denial, got %+v", denials)
+	}
+	if err := rep.Read(strings.NewReader("{\"time\":\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected error for an invalid record, got %v", err)
+	}
+}
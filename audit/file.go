@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// DefaultMaxFileSize is the default size, in bytes, above which a FileSink is rotated.
+	DefaultMaxFileSize = 100 << 20
+	// DefaultMaxBackups is the default number of rotated files kept by a FileSink.
+	DefaultMaxBackups = 5
+)
+
+// FileSink writes the records to a file. When a record would make the file larger than
+// MaxSize, the file is renamed with the suffix ".1", previously rotated files are shifted
+// to the next suffix, and files beyond MaxBackups are deleted.
+type FileSink struct {
+	Path string
+	// MaxSize is the size in bytes above which the file is rotated. Defaults to DefaultMaxFileSize.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept. Defaults to DefaultMaxBackups.
+	MaxBackups int
+
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens the file, creating it if needed. Records are appended to an existing file.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+	s := &FileSink{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Error opening audit log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Error opening audit log: %v", err)
+	}
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+// Write appends the line to the file, rotating it first if needed.
+func (s *FileSink) Write(r *Record, line []byte) error {
+	var rotateErr error
+	if s.size > 0 && s.size+int64(len(line)) > s.MaxSize {
+		rotateErr = s.rotate()
+	}
+	if s.file == nil {
+		return rotateErr
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// rotate shifts the rotated files, renames the file and opens a new one. If the files cannot
+// be renamed, records keep being appended to the file.
+func (s *FileSink) rotate() error {
+	err := s.file.Close()
+	s.file = nil
+	if err == nil {
+		err = s.shift()
+	}
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error rotating audit log: %v", err)
+	}
+	return nil
+}
+
+func (s *FileSink) shift() error {
+	os.Remove(s.backup(s.MaxBackups))
+	for i := s.MaxBackups - 1; i > 0; i-- {
+		if err := os.Rename(s.backup(i), s.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.Path, s.backup(1))
+}
+
+func (s *FileSink) backup(i int) string {
+	return fmt.Sprintf("%s.%d", s.Path, i)
+}
+
+// Close flushes the file to disk and closes it.
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuditOnly is the Enforcement of the records of the requests that were not denied because of
+// the audit-only enforcement mode.
+const AuditOnly = "audit-only"
+
+// maxLineSize is the size of the longest record that can be read.
+const maxLineSize = 1 << 20
+
+// Denial summarizes the requests of a user for the same action that the policy would have
+// denied, but that were not denied because of the audit-only enforcement mode.
+type Denial struct {
+	User        string
+	Namespace   string
+	Verb        string
+	APIGroup    string
+	Resource    string
+	Subresource string
+	Path        string
+	// Groups of the last request.
+	Groups []string
+	// Count is the number of requests.
+	Count int
+	// First and Last are the times of the first and last requests.
+	First time.Time
+	Last  time.Time
+}
+
+// Action describes the action that was denied, e.g. "apps/deployments/scale", "pods" or the
+// non-resource URL "/metrics".
+func (d *Denial) Action() string {
+	if d.Resource == "" {
+		return d.Path
+	}
+	parts := []string{d.Resource}
+	if d.APIGroup != "" {
+		parts = append([]string{d.APIGroup}, parts...)
+	}
+	if d.Subresource != "" {
+		parts = append(parts, d.Subresource)
+	}
+	return strings.Join(parts, "/")
+}
+
+// denialKey identifies the requests summarized by a Denial.
+type denialKey struct {
+	user, namespace, verb, apiGroup, resource, subresource, path string
+}
+
+// DenialReport summarizes the requests that the policy would have denied, but that were not
+// denied because of the audit-only enforcement mode, by user and action.
+type DenialReport struct {
+	// Since, when set, excludes the earlier requests.
+	Since time.Time
+
+	denials map[denialKey]*Denial
+}
+
+// Read adds the audit records read from r, one JSON line at a time, to the report.
+func (rep *DenialReport) Read(r io.Reader) error {
+	if rep.denials == nil {
+		rep.denials = map[denialKey]*Denial{}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		rec := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("Invalid audit record on line %d: %v", line, err)
+		}
+		if rec.Enforcement != AuditOnly || rec.Decision != Denied || rec.Time.Before(rep.Since) {
+			continue
+		}
+		rep.add(&rec)
+	}
+	return scanner.Err()
+}
+
+func (rep *DenialReport) add(r *Record) {
+	req := r.Request
+	k := denialKey{req.User, req.Namespace, req.Verb, req.APIGroup, req.Resource, req.Subresource, req.Path}
+	d, ok := rep.denials[k]
+	if !ok {
+		d = &Denial{User: req.User, Namespace: req.Namespace, Verb: req.Verb, APIGroup: req.APIGroup, Resource: req.Resource, Subresource: req.Subresource, Path: req.Path, First: r.Time}
+		rep.denials[k] = d
+	}
+	d.Count++
+	if r.Time.Before(d.First) {
+		d.First = r.Time
+	}
+	if !r.Time.Before(d.Last) {
+		d.Last, d.Groups = r.Time, req.Groups
+	}
+}
+
+// Denials returns the summaries of the requests, from the most frequent.
+func (rep *DenialReport) Denials() []Denial {
+	summary := make([]Denial, 0, len(rep.denials))
+	for _, d := range rep.denials {
+		summary = append(summary, *d)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.Action() != b.Action() {
+			return a.Action() < b.Action()
+		}
+		return a.Verb < b.Verb
+	})
+	return summary
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultSyslogFacility is the default facility of the messages of a SyslogSink.
+const DefaultSyslogFacility = "local0"
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Severities of the syslog messages, by decision.
+var syslogSeverities = map[string]int{
+	Allowed: 6, // informational
+	Denied:  5, // notice
+	Error:   4, // warning
+}
+
+// syslogAppName is the APP-NAME of the syslog messages.
+const syslogAppName = "kubernetes-rbac"
+
+// SyslogSink sends the records to a syslog server, as RFC 5424 messages whose MSG is the
+// record. Messages are sent over UDP or a unix datagram socket one per datagram, and over
+// TCP with octet counting framing (RFC 6587).
+type SyslogSink struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslogSink returns a sink for the syslog server at the URL, e.g. udp://localhost:514,
+// tcp://syslog.example.com:601 or unix:///dev/log, with the given facility, e.g. "local0".
+func NewSyslogSink(serverURL, facility string) (*SyslogSink, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid syslog URL '%s': %v", serverURL, err)
+	}
+	s := &SyslogSink{}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network, s.address = u.Scheme, u.Host
+	case "unix":
+		s.network, s.address = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("Invalid syslog URL '%s': expected the udp, tcp or unix scheme", serverURL)
+	}
+	if s.address == "" {
+		return nil, fmt.Errorf("Invalid syslog URL '%s': the address is required", serverURL)
+	}
+	if facility == "" {
+		facility = DefaultSyslogFacility
+	}
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("Invalid syslog facility '%s'", facility)
+	}
+	s.facility = f
+	if s.hostname, err = os.Hostname(); err != nil || s.hostname == "" {
+		s.hostname = "-"
+	}
+	if err = s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) dial() error {
+	conn, err := net.DialTimeout(s.network, s.address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("Error connecting to syslog: %v", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write sends the record to the server. The connection is established again if sending fails.
+func (s *SyslogSink) Write(r *Record, line []byte) error {
+	msg := s.format(r, line)
+	if s.conn != nil {
+		if _, err := s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// format returns the RFC 5424 message of the record, framed for the network.
+func (s *SyslogSink) format(r *Record, line []byte) []byte {
+	severity, ok := syslogSeverities[r.Decision]
+	if !ok {
+		severity = syslogSeverities[Error]
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "<%d>1 %s %s %s %d audit - ", s.facility*8+severity, r.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, syslogAppName, os.Getpid())
+	msg.Write(bytes.TrimSuffix(line, []byte("\n")))
+	if s.network != "tcp" {
+		return msg.Bytes()
+	}
+	return append([]byte(strconv.Itoa(msg.Len())+" "), msg.Bytes()...)
+}
+
+// Close closes the connection to the server.
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
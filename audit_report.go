@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/audit"
+	flag "github.com/spf13/pflag"
+)
+
+// runAuditOnlyReport summarizes the requests that the policy would have denied in audit-only
+// mode, from audit log files, so that the policy can be fixed before it is enforced.
+func runAuditOnlyReport(args []string) error {
+	fs := flag.NewFlagSet("audit-only-report", flag.ExitOnError)
+	since := fs.Duration("since", 0, "Only report the requests made within this duration, e.g. 24h. Defaults to all the requests of the audit logs")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return errors.New("at least one audit log file is required")
+	}
+
+	report := &audit.DenialReport{}
+	if *since > 0 {
+		report.Since = time.Now().Add(-*since)
+	}
+	for _, name := range fs.Args() {
+		if err := readAuditLog(report, name); err != nil {
+			return err
+		}
+	}
+
+	denials := report.Denials()
+	if len(denials) == 0 {
+		fmt.Println("No requests would have been denied")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tNAMESPACE\tUSER\tGROUPS\tVERB\tRESOURCE\tLAST SEEN")
+	for _, d := range denials {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Count, d.Namespace, d.User, strings.Join(d.Groups, ","), d.Verb, d.Action(), d.Last.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// readAuditLog adds the records of the audit log file to the report.
+func readAuditLog(report *audit.DenialReport, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = report.Read(f); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
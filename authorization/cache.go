@@ -0,0 +1,196 @@
+package authorization
+
+import (
+	"container/list"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheSize is the default number of decisions kept by a DecisionCache.
+	DefaultCacheSize = 10000
+	// DefaultAllowTTL is the default for how long an allowed decision is cached.
+	DefaultAllowTTL = 5 * time.Minute
+	// DefaultDenyTTL is the default for how long a denied decision is cached.
+	DefaultDenyTTL = 30 * time.Second
+)
+
+// DecisionCache is a least recently used cache of authorization decisions, keyed on the
+// normalized request. Decisions that could not be made because of an error are not cached.
+// The cache must be invalidated whenever the policy changes, which it does itself when
+// Revision is set.
+type DecisionCache struct {
+	// AllowTTL is how long allowed decisions are cached.
+	AllowTTL time.Duration
+	// DenyTTL is how long denied decisions are cached.
+	DenyTTL time.Duration
+	// Revision, when set, returns the current revision of the policy. It is checked on every
+	// lookup, and the cache is invalidated once for every new revision. Decisions are
+	// evaluated without the cache when the revision cannot be read.
+	Revision func() (string, error)
+
+	mu         sync.Mutex
+	size       int
+	entries    map[string]*list.Element
+	lru        *list.List
+	generation uint64
+	revision   string
+	stats      CacheStats
+	now        func() time.Time
+}
+
+// CacheStats are the statistics of a DecisionCache.
+type CacheStats struct {
+	// Entries is the number of decisions in the cache.
+	Entries int `json:"entries"`
+	// Hits is the number of decisions served from the cache.
+	Hits uint64 `json:"hits"`
+	// Misses is the number of decisions that had to be evaluated.
+	Misses uint64 `json:"misses"`
+	// Evictions is the number of decisions removed to make room for new ones.
+	Evictions uint64 `json:"evictions"`
+	// Invalidations is the number of times the cache was emptied because the policy changed.
+	Invalidations uint64 `json:"invalidations"`
+}
+
+type cacheEntry struct {
+	key      string
+	decision Decision
+	expires  time.Time
+}
+
+// NewDecisionCache returns a DecisionCache that keeps up to size decisions.
+func NewDecisionCache(size int, allowTTL, denyTTL time.Duration) *DecisionCache {
+	return &DecisionCache{
+		AllowTTL: allowTTL,
+		DenyTTL:  denyTTL,
+		size:     size,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+// IsAuthorized returns whether the cached decision for the request allows it, or determines
+// it with Authorize and caches it.
+func (c *DecisionCache) IsAuthorized(ruleGetter PolicyRuleGetter, ar *Request) (bool, error) {
+	d, err := c.Authorize(ruleGetter, ar)
+	return d.Allowed, err
+}
+
+// Authorize returns the cached decision for the request, or determines it with Authorize
+// and caches it.
+func (c *DecisionCache) Authorize(ruleGetter PolicyRuleGetter, ar *Request) (Decision, error) {
+	revision := ""
+	if c.Revision != nil {
+		var err error
+		if revision, err = c.Revision(); err != nil {
+			return Authorize(ruleGetter, ar)
+		}
+	}
+	key := cacheKey(ar)
+	d, generation, ok := c.get(key, revision)
+	if ok {
+		return d, nil
+	}
+	d, err := Authorize(ruleGetter, ar)
+	if err != nil {
+		return Decision{}, err
+	}
+	c.add(key, d, generation)
+	return d, nil
+}
+
+// Invalidate empties the cache. Decisions that are being evaluated while the cache is
+// invalidated are not cached, as they may have been made with the previous policy.
+func (c *DecisionCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate()
+}
+
+func (c *DecisionCache) invalidate() {
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.generation++
+	c.stats.Invalidations++
+}
+
+// Stats returns the statistics of the cache.
+func (c *DecisionCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// get returns the decision cached for the key, and the generation of the cache. The cache is
+// invalidated first if the revision of the policy changed.
+func (c *DecisionCache) get(key, revision string) (Decision, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Revision != nil && revision != c.revision {
+		c.invalidate()
+		c.revision = revision
+	}
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(e)
+			c.stats.Hits++
+			return entry.decision, c.generation, true
+		}
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+	c.stats.Misses++
+	return Decision{}, c.generation, false
+}
+
+// add caches the decision, unless the cache was invalidated since the generation.
+func (c *DecisionCache) add(key string, d Decision, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || c.size <= 0 {
+		return
+	}
+	ttl := c.DenyTTL
+	if d.Allowed {
+		ttl = c.AllowTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	entry := &cacheEntry{key: key, decision: d, expires: c.now().Add(ttl)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// cacheKey returns the key of the request, which does not depend on the order of the groups
+// or on duplicate groups.
+func cacheKey(ar *Request) string {
+	groups := append([]string{}, ar.Groups...)
+	sort.Strings(groups)
+	unique := []string{}
+	for i, g := range groups {
+		if i == 0 || g != groups[i-1] {
+			unique = append(unique, g)
+		}
+	}
+	// Marshalling a struct of strings cannot fail
+	key, _ := json.Marshal(Request{User: ar.User, Groups: unique, Action: ar.Action})
+	return string(key)
+}
@@ -0,0 +1,147 @@
+package authorization
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// countingRuleGetter allows users to get pods, and counts the evaluations.
+type countingRuleGetter struct {
+	calls int
+	err   error
+}
+
+func (g *countingRuleGetter) GetApplicableRules(user string, groups []string, namespace string) ([]api.PolicyRule, error) {
+	g.calls++
+	if g.err != nil {
+		return nil, g.err
+	}
+	if user != "alice" {
+		return nil, nil
+	}
+	return []api.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}}, nil
+}
+
+func podRequest(user string, groups ...string) *Request {
+	return &Request{User: user, Groups: groups, Action: APIAction{Verb: "get", Resource: "pods", Namespace: "ns"}}
+}
+
+func TestDecisionCache(t *testing.T) {
+	g := &countingRuleGetter{}
+	c := NewDecisionCache(10, time.Minute, 10*time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if allowed, err := c.IsAuthorized(g, podRequest("alice", "b", "a", "a")); err != nil || !allowed {
+			t.Fatalf("Expected alice to be allowed, got %v, %v", allowed, err)
+		}
+		if allowed, err := c.IsAuthorized(g, podRequest("bob")); err != nil || allowed {
+			t.Fatalf("Expected bob to be denied, got %v, %v", allowed, err)
+		}
+	}
+	// Groups are normalized
+	if _, err := c.IsAuthorized(g, podRequest("alice", "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if g.calls != 2 {
+		t.Errorf("Expected 2 evaluations, got %d", g.calls)
+	}
+	if s := c.Stats(); s.Hits != 5 || s.Misses != 2 || s.Entries != 2 {
+		t.Errorf("Expected 5 hits, 2 misses and 2 entries, got %+v", s)
+	}
+
+	// Denied decisions expire first
+	now = now.Add(30 * time.Second)
+	c.IsAuthorized(g, podRequest("alice", "a", "b"))
+	c.IsAuthorized(g, podRequest("bob"))
+	if g.calls != 3 {
+		t.Errorf("Expected only the denied decision to be evaluated again, got %d evaluations", g.calls)
+	}
+
+	c.Invalidate()
+	c.IsAuthorized(g, podRequest("alice", "a", "b"))
+	if g.calls != 4 {
+		t.Errorf("Expected the decision to be evaluated again after invalidation, got %d evaluations", g.calls)
+	}
+	if s := c.Stats(); s.Invalidations != 1 || s.Entries != 1 {
+		t.Errorf("Expected 1 invalidation and 1 entry, got %+v", s)
+	}
+
+	// Errors are not cached
+	g.err = errors.New("unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := c.IsAuthorized(g, podRequest("carol")); err == nil {
+			t.Errorf("Expected error")
+		}
+	}
+	if g.calls != 6 {
+		t.Errorf("Expected errors not to be cached, got %d evaluations", g.calls)
+	}
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	g := &countingRuleGetter{}
+	c := NewDecisionCache(2, time.Minute, time.Minute)
+
+	c.IsAuthorized(g, podRequest("a"))
+	c.IsAuthorized(g, podRequest("b"))
+	c.IsAuthorized(g, podRequest("a"))
+	c.IsAuthorized(g, podRequest("c"))
+	// b is the least recently used
+	c.IsAuthorized(g, podRequest("a"))
+	c.IsAuthorized(g, podRequest("b"))
+	if g.calls != 4 {
+		t.Errorf("Expected 4 evaluations, got %d", g.calls)
+	}
+	if s := c.Stats(); s.Evictions != 2 || s.Entries != 2 {
+		t.Errorf("Expected 2 evictions and 2 entries, got %+v", s)
+	}
+}
+
+// invalidatingRuleGetter invalidates the cache while the decision is evaluated.
+type invalidatingRuleGetter struct {
+	cache *DecisionCache
+}
+
+func (g invalidatingRuleGetter) GetApplicableRules(user string, groups []string, namespace string) ([]api.PolicyRule, error) {
+	g.cache.Invalidate()
+	return nil, nil
+}
+
+func TestDecisionsEvaluatedDuringInvalidationAreNotCached(t *testing.T) {
+	c := NewDecisionCache(10, time.Minute, time.Minute)
+	c.IsAuthorized(invalidatingRuleGetter{cache: c}, podRequest("alice"))
+	if s := c.Stats(); s.Entries != 0 {
+		t.Errorf("Expected the decision not to be cached, got %+v", s)
+	}
+}
+
+func TestDecisionCacheRevision(t *testing.T) {
+	g := &countingRuleGetter{}
+	c := NewDecisionCache(10, time.Minute, time.Minute)
+	revision, revisionErr := "1", error(nil)
+	c.Revision = func() (string, error) { return revision, revisionErr }
+
+	for _, rev := range []string{"1", "1", "2", "2", "2", "3"} {
+		revision = rev
+		c.IsAuthorized(g, podRequest("alice"))
+		c.IsAuthorized(g, podRequest("bob"))
+	}
+	// The decisions are evaluated again for every new revision
+	if g.calls != 6 {
+		t.Errorf("Expected 6 evaluations, got %d", g.calls)
+	}
+	if s := c.Stats(); s.Invalidations != 3 || s.Entries != 2 {
+		t.Errorf("Expected an invalidation per revision and 2 entries, got %+v", s)
+	}
+
+	revisionErr = errors.New("unavailable")
+	c.IsAuthorized(g, podRequest("alice"))
+	if g.calls != 7 {
+		t.Errorf("Expected the decision to be evaluated when the revision cannot be read, got %d evaluations", g.calls)
+	}
+}
@@ -1,6 +1,7 @@
 package authorization
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/kismatic/kubernetes-rbac/api"
@@ -9,13 +10,43 @@ import (
 // RuleValidator determines if the APIAction is allowed by a PolicyRule
 type RuleValidator func(api.PolicyRule, APIAction) bool
 
+// Decision is the outcome of the authorization of a request.
+type Decision struct {
+	// Allowed is true if a rule of the policy allows the action.
+	Allowed bool
+	// Reason describes why the action is allowed or not.
+	Reason string
+	// Binding that grants the rule allowing the action. It is only known when the rule getter
+	// is a BoundRuleGetter.
+	Binding *BindingRef
+}
+
 // IsAuthorized determines whether the policy allows the action requested by the user
 func IsAuthorized(ruleGetter PolicyRuleGetter, ar *Request) (bool, error) {
+	d, err := Authorize(ruleGetter, ar)
+	return d.Allowed, err
+}
+
+// Authorize determines whether the policy allows the action requested by the user, and why.
+func Authorize(ruleGetter PolicyRuleGetter, ar *Request) (Decision, error) {
 
-	// Get all the PolicyRules that apply to the user in the given namespace
-	rules, err := ruleGetter.GetApplicableRules(ar.User, ar.Groups, ar.Action.Namespace)
-	if err != nil {
-		return false, err
+	// Get all the PolicyRules that apply to the user in the given namespace, along with
+	// their bindings if the rule getter knows them
+	var rules []BoundRule
+	if brg, ok := ruleGetter.(BoundRuleGetter); ok {
+		bound, err := brg.GetBoundRules(ar.User, ar.Groups, ar.Action.Namespace)
+		if err != nil {
+			return Decision{}, err
+		}
+		rules = bound
+	} else {
+		unbound, err := ruleGetter.GetApplicableRules(ar.User, ar.Groups, ar.Action.Namespace)
+		if err != nil {
+			return Decision{}, err
+		}
+		for _, r := range unbound {
+			rules = append(rules, BoundRule{PolicyRule: r})
+		}
 	}
 	log.Printf("Applicable rules for user '%s', groups: '%v' in namespace '%s': %v", ar.User, ar.Groups, ar.Action.Namespace, rules)
 
@@ -28,12 +59,16 @@ func IsAuthorized(ruleGetter PolicyRuleGetter, ar *Request) (bool, error) {
 
 	// Find a rule that allows the requested action
 	for _, r := range rules {
-		if validateRule(r, ar.Action) {
-			return true, nil
+		if validateRule(r.PolicyRule, ar.Action) {
+			if r.Binding.Kind == "" {
+				return Decision{Allowed: true, Reason: "allowed by a rule of the policy"}, nil
+			}
+			binding := r.Binding
+			return Decision{Allowed: true, Reason: fmt.Sprintf("allowed by %s", binding), Binding: &binding}, nil
 		}
 	}
 
-	return false, nil
+	return Decision{Reason: "no rule of the policy allows the action"}, nil
 }
 
 func isResourceActionAllowed(rule api.PolicyRule, action APIAction) bool {
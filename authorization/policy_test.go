@@ -342,3 +342,59 @@ func TestIsAuthorizedMultipleRules(t *testing.T) {
 	}
 
 }
+
+func TestAuthorizeReportsTheBinding(t *testing.T) {
+	bindings := []api.RoleBinding{
+		{
+			Name:      "viewers",
+			Namespace: "project1",
+			Subjects:  []api.Subject{{Kind: api.GroupKind, Name: "viewers"}},
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "view", Namespace: "project1"},
+		},
+	}
+	roles := []api.Role{
+		{
+			Name:      "view",
+			Namespace: "project1",
+			Rules:     []api.PolicyRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"pods"}}},
+		},
+	}
+	clusterRoles := []api.ClusterRole{
+		{
+			Name:  "metrics",
+			Rules: []api.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}}},
+		},
+	}
+	clusterRoleBindings := []api.ClusterRoleBinding{
+		{
+			Name:     "monitoring",
+			Subjects: []api.Subject{{Kind: api.UserKind, Name: "prometheus"}},
+			RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "metrics"},
+		},
+	}
+	ruleGetter := &RepoRuleGetter{fakeRepo{bindings, roles, clusterRoles, clusterRoleBindings}}
+
+	d, err := Authorize(ruleGetter, &Request{User: "alice", Groups: []string{"viewers"}, Action: APIAction{Verb: "get", Resource: "pods", Namespace: "project1"}})
+	if err != nil || !d.Allowed || d.Binding == nil || d.Binding.Kind != api.RoleBindingKind || d.Binding.Name != "viewers" || d.Binding.RoleRef.Name != "view" {
+		t.Errorf("Expected the request to be allowed by role binding viewers, got %+v, %v", d, err)
+	}
+	if d.Reason != "allowed by RoleBinding project1/viewers" {
+		t.Errorf("Expected the reason to name the binding, got %q", d.Reason)
+	}
+
+	d, err = Authorize(ruleGetter, &Request{User: "prometheus", Action: APIAction{Verb: "get", NonResourceURL: "/metrics"}})
+	if err != nil || !d.Allowed || d.Binding == nil || d.Binding.Kind != api.ClusterRoleBindingKind || d.Binding.Name != "monitoring" {
+		t.Errorf("Expected the request to be allowed by cluster role binding monitoring, got %+v, %v", d, err)
+	}
+
+	d, err = Authorize(ruleGetter, &Request{User: "alice", Groups: []string{"viewers"}, Action: APIAction{Verb: "delete", Resource: "pods", Namespace: "project1"}})
+	if err != nil || d.Allowed || d.Binding != nil || d.Reason == "" {
+		t.Errorf("Expected the request to be denied with a reason, got %+v, %v", d, err)
+	}
+
+	// Rule getters that do not know the bindings only report the decision
+	d, err = Authorize(dummyRuleGetter{roles[0].Rules}, &Request{Action: APIAction{Verb: "get", Resource: "pods"}})
+	if err != nil || !d.Allowed || d.Binding != nil {
+		t.Errorf("Expected the request to be allowed without a binding, got %+v, %v", d, err)
+	}
+}
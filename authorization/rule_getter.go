@@ -1,6 +1,7 @@
 package authorization
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -15,39 +16,99 @@ type PolicyRuleGetter interface {
 	GetApplicableRules(user string, groups []string, namespace string) ([]api.PolicyRule, error)
 }
 
+// BoundRule is a policy rule along with the binding that grants it.
+type BoundRule struct {
+	api.PolicyRule
+	// Binding that grants the rule.
+	Binding BindingRef
+}
+
+// BindingRef identifies the binding that grants a rule, and the role it references.
+type BindingRef struct {
+	// Kind of the binding, RoleBinding or ClusterRoleBinding.
+	Kind string `json:"kind"`
+	// Name of the binding.
+	Name string `json:"name"`
+	// Namespace of the binding. Empty for cluster role bindings.
+	Namespace string `json:"namespace,omitempty"`
+	// RoleRef is the role referenced by the binding.
+	RoleRef api.ObjectReference `json:"roleRef"`
+}
+
+func (b BindingRef) String() string {
+	return fmt.Sprintf("%s %s", b.Kind, repository.ObjectKey(b.Namespace, b.Name))
+}
+
+// BoundRuleGetter is implemented by rule getters that can tell which binding grants each rule.
+type BoundRuleGetter interface {
+	// GetBoundRules gets the policy rules that apply to the given user/group in the
+	// specified namespace, along with the bindings that grant them.
+	GetBoundRules(user string, groups []string, namespace string) ([]BoundRule, error)
+}
+
+var _ BoundRuleGetter = &RepoRuleGetter{}
+
 // RepoRuleGetter gets rules from policy repository
 type RepoRuleGetter struct {
 	Repo repository.PolicyRepository
 }
 
 // GetApplicableRules gets the policy rules that apply to the given user/group in the
-// specified namespace.
+// specified namespace. Bindings that reference roles that do not exist are skipped.
 func (g *RepoRuleGetter) GetApplicableRules(user string, groups []string, namespace string) ([]api.PolicyRule, error) {
-	// Get all bindings in the namespace
-	rbs, err := g.Repo.ListRoleBindings(namespace)
+	bound, err := g.GetBoundRules(user, groups, namespace)
 	if err != nil {
 		return nil, err
 	}
 	rules := []api.PolicyRule{}
+	for _, r := range bound {
+		rules = append(rules, r.PolicyRule)
+	}
+	return rules, nil
+}
+
+// GetBoundRules gets the policy rules that apply to the given user/group in the specified
+// namespace, along with the bindings that grant them.
+func (g *RepoRuleGetter) GetBoundRules(user string, groups []string, namespace string) ([]BoundRule, error) {
+	// Get all bindings in the namespace
+	rbs, err := g.Repo.ListRoleBindings(namespace, repository.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	rules := []BoundRule{}
 
 	// Check if the user is contained in any of the role bindings
-	for _, b := range rbs {
+	for _, b := range rbs.Items {
+		// The namespace of a review is never a wildcard: listing api.NamespaceAll returns the
+		// bindings of every namespace
+		if b.Namespace != namespace {
+			continue
+		}
 		for _, s := range b.Subjects {
 			// Add the rules if the subject matches the user being authorized
 			if subjectMatches(s, user, groups) {
+				ref := BindingRef{Kind: api.RoleBindingKind, Name: b.Name, Namespace: b.Namespace, RoleRef: b.RoleRef}
 				switch b.RoleRef.Kind {
 				case api.RoleKind:
 					role, err := g.Repo.GetRole(b.RoleRef.Name, b.RoleRef.Namespace)
+					if errors.Is(err, repository.ErrNotFound) {
+						log.Printf("WARNING: Role binding '%s' in namespace '%s' references a role that does not exist: %v", b.Name, b.Namespace, err)
+						continue
+					}
 					if err != nil {
 						return nil, err
 					}
-					rules = append(rules, role.Rules...)
+					rules = appendBound(rules, role.Rules, ref)
 				case api.ClusterRoleKind:
 					role, err := g.Repo.GetClusterRole(b.RoleRef.Name)
+					if errors.Is(err, repository.ErrNotFound) {
+						log.Printf("WARNING: Role binding '%s' in namespace '%s' references a cluster role that does not exist: %v", b.Name, b.Namespace, err)
+						continue
+					}
 					if err != nil {
 						return nil, err
 					}
-					rules = append(rules, role.Rules...)
+					rules = appendBound(rules, role.Rules, ref)
 				default:
 					return nil, fmt.Errorf("Unknown Role reference Kind '%s'", b.RoleRef.Kind)
 				}
@@ -56,19 +117,24 @@ func (g *RepoRuleGetter) GetApplicableRules(user string, groups []string, namesp
 	}
 
 	// Get all cluster rules that are bound to the user
-	cbs, err := g.Repo.ListClusterRoleBindings()
+	cbs, err := g.Repo.ListClusterRoleBindings(repository.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, b := range cbs {
+	for _, b := range cbs.Items {
 		for _, s := range b.Subjects {
 			if subjectMatches(s, user, groups) {
+				ref := BindingRef{Kind: api.ClusterRoleBindingKind, Name: b.Name, RoleRef: b.RoleRef}
 				r, err := g.Repo.GetClusterRole(b.RoleRef.Name)
+				if errors.Is(err, repository.ErrNotFound) {
+					log.Printf("WARNING: Cluster role binding '%s' references a cluster role that does not exist: %v", b.Name, err)
+					continue
+				}
 				if err != nil {
 					return nil, err
 				}
-				rules = append(rules, r.Rules...)
+				rules = appendBound(rules, r.Rules, ref)
 			}
 		}
 	}
@@ -76,6 +142,14 @@ func (g *RepoRuleGetter) GetApplicableRules(user string, groups []string, namesp
 	return rules, nil
 }
 
+// appendBound appends the rules granted by the binding.
+func appendBound(bound []BoundRule, rules []api.PolicyRule, binding BindingRef) []BoundRule {
+	for _, r := range rules {
+		bound = append(bound, BoundRule{PolicyRule: r, Binding: binding})
+	}
+	return bound
+}
+
 // returns true if the subject matches the user/groups
 func subjectMatches(s api.Subject, user string, groups []string) bool {
 	switch s.Kind {
@@ -1,11 +1,12 @@
 package authorization
 
 import (
-	"fmt"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
 )
 
 type fakeRepo struct {
@@ -18,19 +19,29 @@ type fakeRepo struct {
 func (r fakeRepo) GetRoleBinding(name, namespace string) (*api.RoleBinding, error) { return nil, nil }
 func (r fakeRepo) CreateRoleBinding(api.RoleBinding) error                         { return nil }
 func (r fakeRepo) UpdateRoleBinding(api.RoleBinding) error                         { return nil }
-func (r fakeRepo) DeleteRoleBinding(name, namespace string) error                  { return nil }
+func (r fakeRepo) DeleteRoleBinding(name, namespace, resourceVersion string) error { return nil }
 func (r fakeRepo) CreateRole(api.Role) error                                       { return nil }
 func (r fakeRepo) UpdateRole(api.Role) error                                       { return nil }
-func (r fakeRepo) DeleteRole(name, namespace string) error                         { return nil }
+func (r fakeRepo) DeleteRole(name, namespace, resourceVersion string) error        { return nil }
 
-func (r fakeRepo) ListRoleBindings(namespace string) ([]api.RoleBinding, error) {
+func (r fakeRepo) Apply(api.Policy, repository.ApplyOptions) ([]repository.Change, error) {
+	return nil, nil
+}
+func (r fakeRepo) ListRoles(namespace string, opts repository.ListOptions) (*api.RoleList, error) {
+	return nil, nil
+}
+func (r fakeRepo) ListClusterRoles(opts repository.ListOptions) (*api.ClusterRoleList, error) {
+	return nil, nil
+}
+
+func (r fakeRepo) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
 	bs := []api.RoleBinding{}
 	for _, b := range r.bindings {
 		if b.Namespace == namespace {
 			bs = append(bs, b)
 		}
 	}
-	return bs, nil
+	return &api.RoleBindingList{Items: bs}, nil
 }
 
 func (r fakeRepo) GetRole(name, namespace string) (*api.Role, error) {
@@ -39,7 +50,7 @@ func (r fakeRepo) GetRole(name, namespace string) (*api.Role, error) {
 			return &r, nil
 		}
 	}
-	return nil, fmt.Errorf("Role Not found")
+	return nil, &repository.NotFoundError{Kind: api.RoleKind, Name: name, Namespace: namespace}
 }
 
 func (r fakeRepo) GetClusterRole(name string) (*api.ClusterRole, error) {
@@ -48,11 +59,11 @@ func (r fakeRepo) GetClusterRole(name string) (*api.ClusterRole, error) {
 			return &r, nil
 		}
 	}
-	return nil, fmt.Errorf("Role not found")
+	return nil, &repository.NotFoundError{Kind: api.ClusterRoleKind, Name: name}
 }
 
-func (r fakeRepo) ListClusterRoleBindings() ([]api.ClusterRoleBinding, error) {
-	return r.clusterRoleBindings, nil
+func (r fakeRepo) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	return &api.ClusterRoleBindingList{Items: r.clusterRoleBindings}, nil
 }
 
 func TestRuleGetterNoBindings(t *testing.T) {
@@ -427,9 +438,66 @@ func TestClusterRoleDoesNotExist(t *testing.T) {
 		},
 	}
 	ruleGetter := RepoRuleGetter{fakeRepo{bindings, roles, clusterRoles, clusterRoleBindings}}
-	_, err := ruleGetter.GetApplicableRules("alice", []string{}, "some-project")
-	if err == nil {
-		t.Errorf("Expected an error, but got nil")
+	ar, err := ruleGetter.GetApplicableRules("alice", []string{}, "some-project")
+	if err != nil {
+		t.Errorf("Expected dangling cluster role reference to be skipped, but got error: %v", err)
+	}
+	if !reflect.DeepEqual(ar, []api.PolicyRule{}) {
+		t.Errorf("Expected no rules, got %v", ar)
+	}
+}
+
+func TestRoleDoesNotExist(t *testing.T) {
+	bindings := []api.RoleBinding{
+		{
+			Name:      "dangling",
+			Namespace: "project1",
+			Subjects:  []api.Subject{{Kind: "User", Name: "alice"}},
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "deleted", Namespace: "project1"},
+		},
+		{
+			Name:      "valid",
+			Namespace: "project1",
+			Subjects:  []api.Subject{{Kind: "User", Name: "alice"}},
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "role1", Namespace: "project1"},
+		},
+	}
+	roles := []api.Role{
+		{
+			Name:      "role1",
+			Namespace: "project1",
+			Rules:     []api.PolicyRule{{Verbs: []string{"role1"}}},
+		},
+	}
+	ruleGetter := RepoRuleGetter{fakeRepo{bindings, roles, []api.ClusterRole{}, []api.ClusterRoleBinding{}}}
+	ar, err := ruleGetter.GetApplicableRules("alice", []string{}, "project1")
+	if err != nil {
+		t.Fatalf("Expected dangling role reference to be skipped, but got error: %v", err)
+	}
+	if !reflect.DeepEqual(ar, roles[0].Rules) {
+		t.Errorf("Expected rules %v, got %v", roles[0].Rules, ar)
+	}
+}
+
+type unavailableRepo struct {
+	fakeRepo
+}
+
+func (r unavailableRepo) GetClusterRole(name string) (*api.ClusterRole, error) {
+	return nil, errors.New("repository unavailable")
+}
+
+func TestClusterRoleRepoError(t *testing.T) {
+	clusterRoleBindings := []api.ClusterRoleBinding{
+		{
+			Name:     "cluster1",
+			Subjects: []api.Subject{{Kind: "User", Name: "alice"}},
+			RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "role1"},
+		},
+	}
+	ruleGetter := RepoRuleGetter{unavailableRepo{fakeRepo{clusterRoleBindings: clusterRoleBindings}}}
+	if _, err := ruleGetter.GetApplicableRules("alice", []string{}, "some-project"); err == nil {
+		t.Errorf("Expected an error when the repository is unavailable, but got nil")
 	}
 }
 
@@ -485,3 +553,24 @@ func TestClusterRoleFromRoleBindingWrongNamespace(t *testing.T) {
 		t.Errorf("Expected rules are not equal to obtained rules")
 	}
 }
+
+func TestNamespaceAllIsNotAWildcard(t *testing.T) {
+	alice := api.Subject{Kind: api.UserKind, Name: "alice"}
+	repo := &repository.Document{Policy: api.Policy{
+		Roles: []api.Role{{Name: "admin", Namespace: "team-a", Rules: []api.PolicyRule{{Verbs: []string{"*"}, Resources: []string{"*"}}}}},
+		RoleBindings: []api.RoleBinding{
+			{Name: "admins", Namespace: "team-a", Subjects: []api.Subject{alice}, RoleRef: api.ObjectReference{Kind: api.RoleKind, Namespace: "team-a", Name: "admin"}},
+		},
+	}}
+	g := &RepoRuleGetter{Repo: repo}
+
+	for namespace, expected := range map[string]int{"team-a": 1, "team-b": 0, api.NamespaceAll: 0} {
+		rules, err := g.GetApplicableRules("alice", nil, namespace)
+		if err != nil {
+			t.Fatalf("Error getting rules in namespace %s: %v", namespace, err)
+		}
+		if len(rules) != expected {
+			t.Errorf("Expected %d rules in namespace %s, got %+v", expected, namespace, rules)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+	"github.com/kismatic/kubernetes-rbac/repository/composite"
+	"github.com/kismatic/kubernetes-rbac/repository/file"
+	"github.com/kismatic/kubernetes-rbac/repository/git"
+	"github.com/kismatic/kubernetes-rbac/repository/remote"
+	flag "github.com/spf13/pflag"
+)
+
+// command is a subcommand of kubernetes-rbac. Running kubernetes-rbac without a
+// subcommand starts the webhook service.
+type command struct {
+	// description is shown in the usage message
+	description string
+	// run the command with the arguments that follow the command name
+	run func(args []string) error
+}
+
+var commands = map[string]command{
+	"apply":             {"Apply a policy document to the RBAC policy", runApply},
+	"audit-only-report": {"Summarize the requests that the policy would have denied in audit-only mode, from audit log files", runAuditOnlyReport},
+	"conflicts":         {"Show the policy objects that are defined by more than one policy layer", runConflicts},
+	"delete-role":       {"Delete a role, and optionally the role bindings that reference it", runDeleteRole},
+	"export":            {"Export the RBAC policy as a List of Kubernetes RBAC objects in YAML", runExport},
+	"history":           {"List the revisions of the RBAC policy", runHistory},
+	"import":            {"Convert Kubernetes RBAC objects, such as kubectl get -o yaml output, into a policy document", runImport},
+	"import-abac":       {"Convert a Kubernetes ABAC policy file into a policy document", runImportABAC},
+	"introduced-by":     {"Show the git commit that introduced a policy object", runIntroducedBy},
+	"purge-namespace":   {"Delete all the roles and role bindings of a namespace", runPurgeNamespace},
+	"rollback":          {"Roll the RBAC policy back to a previous revision", runRollback},
+	"show":              {"Show the RBAC policy of a revision", runShow},
+	"sign":              {"Sign RBAC policy files and bundles", runSign},
+	"watch":             {"Print the changes to the RBAC policy as they happen", runWatch},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n       %s <command> [flags]\n\nCommands:\n", os.Args[0], os.Args[0])
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-19s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// repositoryFlags select the policy repository used by the webhook service and the commands.
+type repositoryFlags struct {
+	policyFile   string
+	gitRepo      string
+	gitRef       string
+	layers       []string
+	writeLayer   string
+	historyLimit int
+	bundleURL    string
+	bundleCAFile string
+	bundlePoll   time.Duration
+	trustRoot    string
+	signingKey   string
+}
+
+func (rf *repositoryFlags) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rf.policyFile, "rbac-policy-file", "rbac-policy.json", "File that defines the RBAC policy. With --rbac-policy-git-repo, the path of the file within the git repository")
+	fs.StringVar(&rf.gitRepo, "rbac-policy-git-repo", "", "Git working tree or bare repository that versions the RBAC policy file")
+	fs.StringVar(&rf.gitRef, "rbac-policy-git-ref", git.DefaultRef, "Git ref that holds the RBAC policy file")
+	fs.StringSliceVar(&rf.layers, "rbac-policy-layers", nil, "Comma separated list of name=file policy layers, from the lowest to the highest precedence. Overrides --rbac-policy-file")
+	fs.StringVar(&rf.writeLayer, "rbac-policy-write-layer", "", "Name of the policy layer that receives writes. Defaults to the layer with the highest precedence")
+	fs.StringVar(&rf.bundleURL, "rbac-policy-url", "", "HTTPS URL of a policy bundle to sync the RBAC policy from. Overrides --rbac-policy-file")
+	fs.StringVar(&rf.bundleCAFile, "rbac-policy-url-ca-file", "", "PEM encoded CA certificates used to verify the server of --rbac-policy-url, instead of the system roots")
+	fs.DurationVar(&rf.bundlePoll, "rbac-policy-url-poll-interval", remote.DefaultPollInterval, "How often the policy bundle is polled for changes")
+	fs.StringVar(&rf.trustRoot, "rbac-policy-trust-root", "", "PEM encoded ed25519 public keys that sign the RBAC policy. When set, unsigned and untrusted policies are refused")
+	fs.StringVar(&rf.signingKey, "rbac-policy-signing-key", "", "PEM encoded ed25519 private key used to sign the RBAC policy when it is written")
+	fs.IntVar(&rf.historyLimit, "rbac-policy-history-limit", file.DefaultHistoryLimit, "Number of revisions of the RBAC policy to keep. With --rbac-policy-git-repo, the number of commits to list")
+}
+
+// open the policy repository selected by the flags.
+func (rf *repositoryFlags) open() (repository.PolicyRepository, error) {
+	if len(rf.layers) > 0 {
+		repo, err := rf.openLayers()
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+	if rf.bundleURL != "" {
+		repo, err := rf.openBundle()
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+	if rf.gitRepo != "" {
+		repo, err := git.Create(rf.gitRepo, rf.gitRef, rf.policyFile)
+		if err != nil {
+			return nil, err
+		}
+		repo.HistoryLimit = rf.historyLimit
+		if repo.TrustRoot, repo.Signer, err = rf.keys(); err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+	return rf.openFile(rf.policyFile)
+}
+
+// source describes the policy repository selected by the flags, e.g. "file:rbac-policy.json".
+func (rf *repositoryFlags) source() string {
+	switch {
+	case len(rf.layers) > 0:
+		return "layers:" + strings.Join(rf.layers, ",")
+	case rf.bundleURL != "":
+		return "bundle:" + rf.bundleURL
+	case rf.gitRepo != "":
+		return fmt.Sprintf("git:%s@%s:%s", rf.gitRepo, rf.gitRef, rf.policyFile)
+	}
+	return "file:" + rf.policyFile
+}
+
+// openBundle opens the remote policy bundle, and syncs it once so that a policy is served.
+func (rf *repositoryFlags) openBundle() (*remote.Repository, error) {
+	var pool *x509.CertPool
+	if rf.bundleCAFile != "" {
+		pem, err := ioutil.ReadFile(rf.bundleCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in '%s'", rf.bundleCAFile)
+		}
+	}
+	// A fetch must not outlast the poll interval
+	timeout := remote.DefaultTimeout
+	if rf.bundlePoll > 0 && rf.bundlePoll < timeout {
+		timeout = rf.bundlePoll
+	}
+	client := remote.NewClient(pool, timeout)
+
+	repo, err := remote.Create(rf.bundleURL, client)
+	if err != nil {
+		return nil, err
+	}
+	repo.PollInterval = rf.bundlePoll
+	if repo.TrustRoot, _, err = rf.keys(); err != nil {
+		return nil, err
+	}
+	if err = repo.Sync(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// openFile opens the policy file at path.
+func (rf *repositoryFlags) openFile(path string) (*file.FlatFileRepository, error) {
+	repo, err := file.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	fr := repo.(*file.FlatFileRepository)
+	fr.HistoryLimit = rf.historyLimit
+	if fr.TrustRoot, fr.Signer, err = rf.keys(); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+// keys loads the trust root and the signing key, if they are set.
+func (rf *repositoryFlags) keys() (*repository.TrustRoot, *repository.Signer, error) {
+	var trustRoot *repository.TrustRoot
+	var signer *repository.Signer
+	var err error
+	if rf.trustRoot != "" {
+		if trustRoot, err = repository.LoadTrustRoot(rf.trustRoot); err != nil {
+			return nil, nil, err
+		}
+	}
+	if rf.signingKey != "" {
+		if signer, err = repository.LoadSigner(rf.signingKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	return trustRoot, signer, nil
+}
+
+// openHistory opens the policy repository selected by the flags, which must keep a history.
+func (rf *repositoryFlags) openHistory() (repository.HistoryRepository, error) {
+	repo, err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	h, ok := repo.(repository.HistoryRepository)
+	if !ok {
+		return nil, errors.New("the policy repository does not keep a history")
+	}
+	return h, nil
+}
+
+// openLayers opens the composite repository made of the policy layers.
+func (rf *repositoryFlags) openLayers() (*composite.Repository, error) {
+	layers := []composite.Layer{}
+	for _, l := range rf.layers {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid policy layer '%s': expected name=file", l)
+		}
+		repo, err := rf.openFile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		// Integrity is enforced on the merged policy
+		repo.AllowDanglingReferences = true
+		layers = append(layers, composite.Layer{Name: parts[0], Repo: repo})
+	}
+	// Writes take effect over every layer below the write layer
+	writeLayer := rf.writeLayer
+	if writeLayer == "" {
+		writeLayer = layers[len(layers)-1].Name
+	}
+	return composite.Create(writeLayer, layers...)
+}
+
+// newCommandFlagSet returns the flag set for the named command, with the
+// flags that are common to all commands.
+func newCommandFlagSet(name string) (*flag.FlagSet, *repositoryFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	rf := &repositoryFlags{}
+	rf.addFlags(fs)
+	return fs, rf
+}
+
+// readPolicyDocument reads a policy in the format of the RBAC policy file.
+func readPolicyDocument(path string) (api.Policy, error) {
+	p := api.Policy{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("Error unmarshalling policy document '%s': %v", path, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// runConflicts prints the policy objects that are defined by more than one layer.
+func runConflicts(args []string) error {
+	fs, repoFlags := newCommandFlagSet("conflicts")
+	fs.Parse(args)
+
+	if len(repoFlags.layers) == 0 {
+		return errors.New("--rbac-policy-layers is required")
+	}
+
+	repo, err := repoFlags.openLayers()
+	if err != nil {
+		return err
+	}
+
+	conflicts, err := repo.Conflicts()
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		fmt.Println(c)
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts")
+	}
+	return nil
+}
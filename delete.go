@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// runDeleteRole deletes a role. With --cascade, the role bindings that reference the role
+// are deleted with it, and the changes are printed.
+func runDeleteRole(args []string) error {
+	fs, repoFlags := newCommandFlagSet("delete-role")
+	namespace := fs.StringP("namespace", "n", "", "Namespace of the role")
+	cascade := fs.Bool("cascade", false, "Delete the role bindings that reference the role too")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without deleting anything. Requires --cascade")
+	author := fs.String("author", "", "Author of the change, in the \"Name <email>\" format. Requires --cascade")
+	message := fs.StringP("message", "m", "", "Message describing the change. Requires --cascade")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("the name of the role is required")
+	}
+	if *namespace == "" {
+		return errors.New("--namespace is required")
+	}
+
+	repo, err := repoFlags.open()
+	if err != nil {
+		return err
+	}
+	if !*cascade {
+		if *dryRun || *author != "" || *message != "" {
+			return errors.New("--dry-run, --author and --message require --cascade")
+		}
+		if err = repo.DeleteRole(fs.Arg(0), *namespace, ""); err != nil {
+			return err
+		}
+		fmt.Println(repository.Change{Type: repository.ChangeDelete, Kind: api.RoleKind, Name: fs.Arg(0), Namespace: *namespace})
+		return nil
+	}
+
+	cr, ok := repo.(repository.CascadeRepository)
+	if !ok {
+		return errors.New("the policy repository does not support cascading deletes")
+	}
+	changes, err := cr.DeleteRoleCascade(fs.Arg(0), *namespace, "", repository.ApplyOptions{DryRun: *dryRun, Author: *author, Message: *message})
+	if err != nil {
+		return err
+	}
+	printChanges(changes, *dryRun)
+	return nil
+}
+
+// runPurgeNamespace deletes the roles and role bindings of a namespace, and prints the changes.
+func runPurgeNamespace(args []string) error {
+	fs, repoFlags := newCommandFlagSet("purge-namespace")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without deleting anything")
+	author := fs.String("author", "", "Author of the change, in the \"Name <email>\" format, for repositories that keep history")
+	message := fs.StringP("message", "m", "", "Message describing the change, for repositories that keep history")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("the namespace is required")
+	}
+
+	repo, err := repoFlags.open()
+	if err != nil {
+		return err
+	}
+	cr, ok := repo.(repository.CascadeRepository)
+	if !ok {
+		return errors.New("the policy repository does not support purging namespaces")
+	}
+	changes, err := cr.PurgeNamespace(fs.Arg(0), repository.ApplyOptions{DryRun: *dryRun, Author: *author, Message: *message})
+	if err != nil {
+		return err
+	}
+	printChanges(changes, *dryRun)
+	return nil
+}
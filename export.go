@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kismatic/kubernetes-rbac/rbacv1"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// runExport writes the RBAC policy as a List of rbac.authorization.k8s.io/v1 objects in YAML,
+// and reports the objects and fields that could not be exported exactly.
+func runExport(args []string) error {
+	fs, repoFlags := newCommandFlagSet("export")
+	output := fs.StringP("output", "o", "", "File to write the YAML List to. Defaults to standard output")
+	strict := fs.Bool("strict", false, "Fail if any object cannot be exported exactly")
+	fs.Parse(args)
+
+	repo, err := repoFlags.open()
+	if err != nil {
+		return err
+	}
+	p, err := repository.ReadPolicy(repo)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	issues, err := rbacv1.Export(*p, buf)
+	if err != nil {
+		return err
+	}
+	for _, i := range issues {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", i)
+	}
+	if *strict && len(issues) > 0 {
+		return fmt.Errorf("%d objects cannot be exported exactly", len(issues))
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0644)
+}
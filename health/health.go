@@ -0,0 +1,124 @@
+// Package health serves the liveness, readiness and status endpoints of the webhook, for load
+// balancers, the kubelet and operators.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/kismatic/kubernetes-rbac/metrics"
+	"github.com/kismatic/kubernetes-rbac/repository/remote"
+)
+
+// Paths under which the handlers are served.
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+	StatusPath    = "/status"
+)
+
+// Checker decides whether the webhook is ready to authorize requests, which is when it has
+// read a valid policy and is not shutting down.
+type Checker struct {
+	Policy *metrics.PolicyMonitor
+	// Bundle, when set, is the remote policy bundle whose synchronization is reported.
+	Bundle *remote.Repository
+
+	draining int32
+}
+
+// Status of the webhook.
+type Status struct {
+	Ready bool `json:"ready"`
+	// Reason the webhook is not ready.
+	Reason string               `json:"reason,omitempty"`
+	Policy metrics.PolicyStatus `json:"policy"`
+	Sync   *remote.Status       `json:"sync,omitempty"`
+}
+
+// Drain makes the webhook permanently unready, so that load balancers stop sending it
+// requests before it shuts down.
+func (c *Checker) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+// Ready returns an error unless the webhook is ready.
+func (c *Checker) Ready() error {
+	if atomic.LoadInt32(&c.draining) != 0 {
+		return errors.New("The webhook is shutting down")
+	}
+	return c.Policy.Ready()
+}
+
+// Status returns the status of the webhook.
+func (c *Checker) Status() Status {
+	s := Status{Policy: c.Policy.Status()}
+	if err := c.Ready(); err != nil {
+		s.Reason = err.Error()
+	} else {
+		s.Ready = true
+	}
+	if c.Bundle != nil {
+		sync := c.Bundle.Status()
+		s.Sync = &sync
+	}
+	return s
+}
+
+// LivenessHandler reports that the process is serving requests:
+//
+//	GET /healthz
+type LivenessHandler struct{}
+
+func (LivenessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// ReadinessHandler responds with 200 OK when the webhook is ready, and 503 Service Unavailable
+// with the reason otherwise:
+//
+//	GET /readyz
+type ReadinessHandler struct {
+	Checker *Checker
+}
+
+func (rh *ReadinessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := rh.Checker.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// StatusHandler serves the status of the webhook: the source, revision, load time and last
+// error of the policy, and the synchronization of the policy bundle:
+//
+//	GET /status
+type StatusHandler struct {
+	Checker *Checker
+}
+
+func (sh *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := json.Marshal(sh.Checker.Status())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
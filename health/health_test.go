@@ -0,0 +1,74 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/metrics"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestReadiness(t *testing.T) {
+	repo := &repository.Document{}
+	c := &Checker{Policy: &metrics.PolicyMonitor{Repo: repo, Source: "file:rbac-policy.json"}}
+	ready := &ReadinessHandler{Checker: c}
+
+	if w := get(LivenessHandler{}, LivenessPath); w.Code != http.StatusOK {
+		t.Errorf("Expected the webhook to be live, got %d", w.Code)
+	}
+	if w := get(ready, ReadinessPath); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the webhook not to be ready before the policy is read, got %d", w.Code)
+	}
+
+	if err := c.Policy.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(ready, ReadinessPath); w.Code != http.StatusOK {
+		t.Errorf("Expected the webhook to be ready, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// A role without a namespace cannot be served, as when the policy file is edited by hand
+	repo.Roles = append(repo.Roles, api.Role{Name: "view"})
+	repo.NextResourceVersion()
+	if err := c.Policy.Check(); err == nil {
+		t.Errorf("Expected the invalid policy to be reported")
+	}
+	w := get(ready, ReadinessPath)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "namespace is required") {
+		t.Errorf("Expected the webhook not to be ready with an invalid policy, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = get(&StatusHandler{Checker: c}, StatusPath)
+	s := Status{}
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Error decoding status: %v", err)
+	}
+	if s.Ready || s.Reason == "" || s.Policy.Source != "file:rbac-policy.json" || s.Policy.Revision == "" || s.Policy.LoadTime.IsZero() || !strings.Contains(s.Policy.LastError, "namespace is required") || s.Sync != nil {
+		t.Errorf("Expected the status of the last valid revision with the error, got %s", w.Body.String())
+	}
+
+	repo.Roles = nil
+	repo.NextResourceVersion()
+	c.Policy.Check()
+	if w := get(ready, ReadinessPath); w.Code != http.StatusOK {
+		t.Errorf("Expected the webhook to be ready once the policy is fixed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	c.Drain()
+	if w := get(ready, ReadinessPath); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("Expected the webhook not to be ready while shutting down, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := get(LivenessHandler{}, LivenessPath); w.Code != http.StatusOK {
+		t.Errorf("Expected the webhook to be live while shutting down, got %d", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// runHistory prints the revisions of the policy, from the newest to the oldest.
+func runHistory(args []string) error {
+	fs, repoFlags := newCommandFlagSet("history")
+	fs.Parse(args)
+
+	repo, err := repoFlags.openHistory()
+	if err != nil {
+		return err
+	}
+	revs, err := repo.History()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tTIMESTAMP\tHASH\tAUTHOR\tMESSAGE")
+	for _, r := range revs {
+		fmt.Fprintf(w, "%s\t%s\t%.12s\t%s\t%s\n", r.ID, r.Timestamp.Format(time.RFC3339), r.Hash, r.Author, r.Message)
+	}
+	return w.Flush()
+}
+
+// runShow prints the policy of a revision, in the format of the RBAC policy file.
+func runShow(args []string) error {
+	fs, repoFlags := newCommandFlagSet("show")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("the revision is required")
+	}
+
+	repo, err := repoFlags.openHistory()
+	if err != nil {
+		return err
+	}
+	p, err := repo.ShowRevision(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// runRollback restores the policy of a revision, and prints the objects that are
+// created, updated and deleted.
+func runRollback(args []string) error {
+	fs, repoFlags := newCommandFlagSet("rollback")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without rolling back")
+	author := fs.String("author", "", "Author of the rollback, in the \"Name <email>\" format")
+	message := fs.StringP("message", "m", "", "Message describing the rollback")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("the revision is required")
+	}
+
+	repo, err := repoFlags.openHistory()
+	if err != nil {
+		return err
+	}
+	changes, err := repo.Rollback(fs.Arg(0), repository.ApplyOptions{DryRun: *dryRun, Author: *author, Message: *message})
+	if err != nil {
+		return err
+	}
+	printChanges(changes, *dryRun)
+	return nil
+}
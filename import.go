@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kismatic/kubernetes-rbac/rbacv1"
+	flag "github.com/spf13/pflag"
+)
+
+// runImport converts rbac.authorization.k8s.io/v1 objects, such as the output of kubectl get -o yaml,
+// into a policy document, and reports the objects and fields that could not be imported exactly.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	filename := fs.StringP("filename", "f", "", "YAML or JSON file with a List or a stream of RBAC objects. Use - for standard input")
+	output := fs.StringP("output", "o", "", "File to write the policy document to. Defaults to standard output")
+	strict := fs.Bool("strict", false, "Fail if any object cannot be imported exactly")
+	fs.Parse(args)
+
+	if *filename == "" {
+		return errors.New("--filename is required")
+	}
+
+	in := os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	res, err := rbacv1.Import(in)
+	if err != nil {
+		return fmt.Errorf("Error importing '%s': %v", *filename, err)
+	}
+
+	for _, i := range res.Issues {
+		fmt.Fprintf(os.Stderr, "WARNING: %s: %v\n", *filename, i)
+	}
+	if *strict && len(res.Issues) > 0 {
+		return fmt.Errorf("%d objects cannot be imported exactly", len(res.Issues))
+	}
+
+	b, err := json.MarshalIndent(res.Policy, "", "    ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(*output, b, 0644)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kismatic/kubernetes-rbac/abac"
+	flag "github.com/spf13/pflag"
+)
+
+// runImportABAC converts a Kubernetes ABAC policy file into a policy document, and reports
+// the grants that could not be converted exactly.
+func runImportABAC(args []string) error {
+	fs := flag.NewFlagSet("import-abac", flag.ExitOnError)
+	filename := fs.StringP("filename", "f", "", "ABAC policy file, with one JSON policy object per line")
+	output := fs.StringP("output", "o", "", "File to write the policy document to. Defaults to standard output")
+	strict := fs.Bool("strict", false, "Fail if any grant cannot be converted exactly")
+	fs.Parse(args)
+
+	if *filename == "" {
+		return errors.New("--filename is required")
+	}
+
+	in, err := os.Open(*filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	res, err := abac.Convert(in)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range res.Issues {
+		fmt.Fprintf(os.Stderr, "WARNING: %s: %v\n", *filename, i)
+	}
+	if *strict && len(res.Issues) > 0 {
+		return fmt.Errorf("%d grants cannot be converted exactly", len(res.Issues))
+	}
+
+	b, err := json.MarshalIndent(res.Policy, "", "    ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(*output, b, 0644)
+}
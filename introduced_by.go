@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository/git"
+)
+
+// runIntroducedBy prints the git commit that introduced a policy object.
+func runIntroducedBy(args []string) error {
+	fs, repoFlags := newCommandFlagSet("introduced-by")
+	kind := fs.String("kind", api.RoleBindingKind, "Kind of the policy object")
+	namespace := fs.StringP("namespace", "n", "", "Namespace of the policy object, if it is namespaced")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("the name of the policy object is required")
+	}
+	if repoFlags.gitRepo == "" {
+		return errors.New("--rbac-policy-git-repo is required")
+	}
+
+	repo, err := git.Create(repoFlags.gitRepo, repoFlags.gitRef, repoFlags.policyFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := repo.IntroducedBy(*kind, fs.Arg(0), *namespace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("commit %s\nAuthor: %s\nDate:   %s\n\n    %s\n", c.Hash, c.Author, c.Time, c.Message)
+	return nil
+}
@@ -16,24 +16,82 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/kismatic/kubernetes-rbac/audit"
 	"github.com/kismatic/kubernetes-rbac/authorization"
-	"github.com/kismatic/kubernetes-rbac/repository/file"
+	"github.com/kismatic/kubernetes-rbac/health"
+	"github.com/kismatic/kubernetes-rbac/metrics"
+	"github.com/kismatic/kubernetes-rbac/policyapi"
+	"github.com/kismatic/kubernetes-rbac/repository"
+	"github.com/kismatic/kubernetes-rbac/repository/composite"
+	"github.com/kismatic/kubernetes-rbac/repository/remote"
+	"github.com/kismatic/kubernetes-rbac/server"
 	"github.com/kismatic/kubernetes-rbac/webhook"
 	flag "github.com/spf13/pflag"
 )
 
 var flTLSCertFile = flag.String("tls-cert-file", "", "X509 certificate for HTTPS")
 var flTLSKeyFile = flag.String("tls-private-key-file", "", "X509 private key matching --tls-cert-file for HTTPS")
-var flPolicyFile = flag.String("rbac-policy-file", "rbac-policy.json", "File that defines the RBAC policy")
+var flListenAddress = flag.String("listen-address", ":4000", "Address on which the webhook service listens for HTTPS connections")
+var flClientCAFile = flag.String("client-ca-file", "", "PEM encoded CA certificates that sign the client certificates of the Kubernetes API server. Client certificates are verified when set")
+var flRequireClientCert = flag.Bool("require-client-cert", true, "Refuse clients without a certificate signed by --client-ca-file. Only applies with --client-ca-file")
+var flAllowedClientNames = flag.StringSlice("allowed-client-names", nil, "Comma separated list of the common names or subject alternative names of the client certificates that may connect. Requires --client-ca-file")
+var flTLSReloadInterval = flag.Duration("tls-reload-interval", server.DefaultReloadInterval, "How often --tls-cert-file, --tls-private-key-file and --client-ca-file are checked for changes, which are served without a restart")
+var flEnforcementMode = flag.String("enforcement-mode", string(webhook.Enforce), "Whether the decisions of the policy are enforced: enforce, audit-only, which records the requests that would be denied without denying them, or off")
+var flNamespaceEnforcementModes = flag.StringSlice("namespace-enforcement-modes", nil, "Comma separated list of namespace=mode enforcement modes of the namespaces that do not use --enforcement-mode")
+var flAuditOnlyResponse = flag.String("audit-only-response", "allow", "Response to the requests that the policy would deny in audit-only mode: allow, or no-opinion to leave the decision to the other authorizers of the API server")
+var flShutdownDelay = flag.Duration("shutdown-delay", server.DefaultShutdownDelay, "How long requests keep being served on SIGTERM once /readyz fails, so that load balancers stop sending requests")
+var flShutdownTimeout = flag.Duration("shutdown-timeout", server.DefaultShutdownTimeout, "How long in-flight requests are waited for on shutdown before they are aborted")
+var flTLSMinVersion = flag.String("tls-min-version", server.DefaultMinTLSVersion, "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+var flTLSCipherSuites = flag.StringSlice("tls-cipher-suites", nil, "Comma separated list of the cipher suites enabled for TLS 1.2 and earlier. Defaults to the secure cipher suites of the Go standard library")
+var flRepository = &repositoryFlags{}
 var flDebug = flag.Bool("debug", false, "enable debug logging")
+var flPolicyAPI = flag.Bool("enable-policy-api", false, "Serve the policy history endpoints under /policy/history on --admin-listen-address")
+var flHealthListenAddress = flag.String("health-listen-address", ":4001", "Address on which /healthz and /readyz listen for HTTPS connections without client certificates, for the kubelet probes, along with /status, /metrics and /tls/certificate for the clients of --health-client-ca-file")
+var flHealthClientCAFile = flag.String("health-client-ca-file", "", "PEM encoded CA certificates that sign the client certificates of Prometheus and the other clients of /status, /metrics and /tls/certificate on --health-listen-address. Without it, they are only served on --admin-listen-address")
+var flAdminListenAddress = flag.String("admin-listen-address", "", "Address on which the policy API, /policy/sync, /status, /metrics and /tls/certificate listen for HTTPS connections, separately from the authorization endpoints")
+var flAdminClientCAFile = flag.String("admin-client-ca-file", "", "PEM encoded CA certificates that sign the client certificates of the operators of the policy API. Clients of --admin-listen-address must present one")
+var flAdminAllowedClientNames = flag.StringSlice("admin-allowed-client-names", nil, "Comma separated list of the common names or subject alternative names of the client certificates that may use the policy API")
+var flFailurePolicy = flag.String("authorization-failure-policy", string(webhook.FailClosed), "Response when the RBAC policy cannot be evaluated: 'closed' denies the request, 'open' allows it")
+var flCacheSize = flag.Int("decision-cache-size", authorization.DefaultCacheSize, "Number of authorization decisions to cache. The cache is invalidated when the revision of the policy changes. 0 disables the cache")
+var flCacheAllowTTL = flag.Duration("decision-cache-allow-ttl", authorization.DefaultAllowTTL, "How long allowed decisions are cached")
+var flCacheDenyTTL = flag.Duration("decision-cache-deny-ttl", authorization.DefaultDenyTTL, "How long denied decisions are cached")
+var flAuditLogPath = flag.String("audit-log-path", "", "File to which an audit record is appended for every decision, as a line of JSON")
+var flAuditLogMaxSize = flag.Int64("audit-log-max-size", audit.DefaultMaxFileSize>>20, "Size in megabytes above which --audit-log-path is rotated")
+var flAuditLogMaxBackups = flag.Int("audit-log-max-backups", audit.DefaultMaxBackups, "Number of rotated audit log files to keep")
+var flAuditStdout = flag.Bool("audit-stdout", false, "Write an audit record for every decision to stdout, as a line of JSON")
+var flAuditSyslog = flag.String("audit-syslog", "", "Syslog server to which an audit record is sent for every decision, e.g. udp://localhost:514, tcp://syslog:601 or unix:///dev/log")
+var flAuditSyslogFacility = flag.String("audit-syslog-facility", audit.DefaultSyslogFacility, "Facility of the audit records sent to --audit-syslog")
+var flAuditSampleAllowed = flag.Float64("audit-sample-allowed", 1, "Fraction of the allowed decisions that are audited, from 0 to 1")
+var flAuditSampleDenied = flag.Float64("audit-sample-denied", 1, "Fraction of the denied decisions that are audited, from 0 to 1. Decisions that could not be made because of an error, and the denials of the audit-only mode, are always audited")
+var flAuditRedact = flag.StringSlice("audit-redact", nil, "Comma separated list of the fields of the audited requests that are redacted, wherever the record carries their value: user, groups, uid, name, namespace or path")
+var flAuditRedactKeyFile = flag.String("audit-redact-key-file", "", "File holding the secret key of the HMAC-SHA256 that replaces the values of --audit-redact, at least 16 bytes. Without a key, the values are removed")
+
+func init() {
+	flRepository.addFlags(flag.CommandLine)
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	flag.Usage = usage
 	flag.Parse()
 
 	if !*flDebug {
@@ -50,16 +108,275 @@ func main() {
 		os.Exit(1)
 	}
 
-	repo, err := file.Create(*flPolicyFile)
+	failurePolicy, err := webhook.ParseFailurePolicy(*flFailurePolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	enforcement, err := webhook.ParseEnforcement(*flEnforcementMode, *flNamespaceEnforcementModes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	switch *flAuditOnlyResponse {
+	case "allow":
+	case "no-opinion":
+		enforcement.NoOpinion = true
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid audit-only response '%s': expected 'allow' or 'no-opinion'\n", *flAuditOnlyResponse)
+		os.Exit(1)
+	}
+
+	tlsOptions := server.TLSOptions{
+		CertFile:           *flTLSCertFile,
+		KeyFile:            *flTLSKeyFile,
+		ClientCAFile:       *flClientCAFile,
+		RequireClientCert:  *flRequireClientCert && *flClientCAFile != "",
+		AllowedClientNames: *flAllowedClientNames,
+		MinVersion:         *flTLSMinVersion,
+		CipherSuites:       *flTLSCipherSuites,
+	}
+	certs, err := server.NewCertificateReloader(tlsOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring TLS: %v\n", err)
+		os.Exit(1)
+	}
+	certs.ReloadInterval = *flTLSReloadInterval
+	// Background work stops once the server is shut down
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go certs.Run(ctx)
+
+	// The probes are served on the health listener, as the kubelet has no client certificate
+	// for the authorization endpoints. The operator endpoints are served on the admin listener,
+	// and the metrics and the status of the webhook, which reveal the policy source and the
+	// namespaces being reviewed, also to the clients of the health listener with a certificate
+	// of the health client CAs.
+	healthMux := http.NewServeMux()
+	admin := http.NewServeMux()
+	private := func(path string, h http.Handler) {
+		admin.Handle(path, h)
+		if *flHealthClientCAFile != "" {
+			healthMux.Handle(path, server.RequireClientCertificate(h))
+		}
+	}
+	if *flHealthClientCAFile == "" && *flAdminListenAddress == "" {
+		log.Printf("%s, %s and %s are not served without --health-client-ca-file or --admin-listen-address", health.StatusPath, metrics.Path, server.CertificatePath)
+	}
+	private(server.CertificatePath, &server.CertificateStatusHandler{Reloader: certs})
+
+	registry := metrics.NewRegistry()
+	certs.RegisterMetrics(registry)
+	private(metrics.Path, registry)
+
+	repo, err := flRepository.open()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating repo: %v\n", err)
+		os.Exit(1)
+	}
+	// Refuse to start with a policy that cannot be read, e.g. because it is not trusted, or
+	// that is invalid
+	monitor := &metrics.PolicyMonitor{Repo: repo, Source: flRepository.source()}
+	if err = monitor.Check(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the RBAC policy: %v\n", err)
+		os.Exit(1)
+	}
+
+	if layers, ok := repo.(*composite.Repository); ok {
+		conflicts, err := layers.Conflicts()
+		if err != nil {
+			log.Printf("Error checking policy layers for conflicts: %v", err)
+		}
+		for _, c := range conflicts {
+			log.Printf("WARNING: %v", c)
+		}
+	}
+
+	checker := &health.Checker{Policy: monitor}
+	if bundle, ok := repo.(*remote.Repository); ok {
+		go bundle.Run(ctx)
+		admin.Handle(policyapi.SyncPath, &policyapi.SyncStatusHandler{Repo: bundle})
+		bundle.RegisterMetrics(registry)
+		checker.Bundle = bundle
+	}
+
+	go monitor.Run(ctx)
+	registry.Register(monitor)
+	healthMux.Handle(health.LivenessPath, health.LivenessHandler{})
+	healthMux.Handle(health.ReadinessPath, &health.ReadinessHandler{Checker: checker})
+	private(health.StatusPath, &health.StatusHandler{Checker: checker})
+
+	auditLog, err := openAuditLog()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating repo: %v", err)
+		fmt.Fprintf(os.Stderr, "Error opening the audit log: %v\n", err)
+		os.Exit(1)
+	}
+	// The requests that audit-only mode does not deny are only recorded in the audit log
+	if enforcement.HasAuditOnly() && auditLog == nil {
+		fmt.Fprintln(os.Stderr, "The audit-only enforcement mode requires an audit log: --audit-log-path, --audit-stdout or --audit-syslog.")
+		os.Exit(1)
+	}
+	if auditLog != nil {
+		registry.Register(metrics.NewCounterFunc("kubernetes_rbac_audit_records_dropped_total", "Number of sampled audit records dropped because the sinks could not keep up.", nil, func() []metrics.Sample {
+			return metrics.Value(float64(auditLog.Dropped()))
+		}))
 	}
 
-	rg := authorization.RepoRuleGetter{repo}
-	h := &webhook.AuthorizationHandler{&rg}
+	webhookMetrics := webhook.NewMetrics(registry)
+	rg := authorization.RepoRuleGetter{Repo: &metrics.LookupRepository{PolicyRepository: repo, Lookups: webhookMetrics.Lookups}}
+	h := &webhook.AuthorizationHandler{RuleGetter: &rg, FailurePolicy: failurePolicy, Metrics: webhookMetrics, Audit: auditLog, Revision: monitor.Revision, Enforcement: enforcement}
+	if *flCacheSize > 0 {
+		h.Cache = authorization.NewDecisionCache(*flCacheSize, *flCacheAllowTTL, *flCacheDenyTTL)
+		// The revision is checked on every review, so that no decision of a previous policy is
+		// served. Backends check it without reading the policy.
+		h.Cache.Revision = func() (string, error) {
+			return repository.ReadRevision(repo)
+		}
+		http.Handle(webhook.CacheStatsPath, &webhook.CacheStatsHandler{Cache: h.Cache})
+		webhook.RegisterCacheMetrics(registry, h.Cache)
+	}
 
 	http.Handle("/authorize", h)
+	http.Handle(webhook.BatchPath, &webhook.BatchHandler{Repo: repo, FailurePolicy: failurePolicy, Metrics: webhookMetrics, Audit: auditLog, Enforcement: enforcement})
 
-	log.Fatal(http.ListenAndServeTLS(":4000", *flTLSCertFile, *flTLSKeyFile, nil))
+	l, err := net.Listen("tcp", *flListenAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", *flListenAddress, err)
+		os.Exit(1)
+	}
+	endpoints := []server.Endpoint{{Server: &http.Server{TLSConfig: certs.TLSConfig()}, Listener: l}}
 
+	// Client certificates are only verified when presented, so that the probes need none
+	healthCerts, err := server.NewCertificateReloader(server.TLSOptions{
+		CertFile:     *flTLSCertFile,
+		KeyFile:      *flTLSKeyFile,
+		ClientCAFile: *flHealthClientCAFile,
+		MinVersion:   *flTLSMinVersion,
+		CipherSuites: *flTLSCipherSuites,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring the TLS of the health listener: %v\n", err)
+		os.Exit(1)
+	}
+	healthCerts.ReloadInterval = *flTLSReloadInterval
+	go healthCerts.Run(ctx)
+	hl, err := net.Listen("tcp", *flHealthListenAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", *flHealthListenAddress, err)
+		os.Exit(1)
+	}
+	endpoints = append(endpoints, server.Endpoint{Server: &http.Server{Handler: healthMux, TLSConfig: healthCerts.TLSConfig()}, Listener: hl})
+
+	if *flPolicyAPI {
+		if *flAdminListenAddress == "" {
+			fmt.Fprintln(os.Stderr, "--enable-policy-api requires --admin-listen-address.")
+			os.Exit(1)
+		}
+		history, ok := repo.(repository.HistoryRepository)
+		if !ok {
+			fmt.Fprintln(os.Stderr, "--enable-policy-api requires a policy repository that keeps a history.")
+			os.Exit(1)
+		}
+		hh := &policyapi.HistoryHandler{Repo: history}
+		admin.Handle(policyapi.HistoryPath, hh)
+		admin.Handle(policyapi.HistoryPath+"/", hh)
+	}
+	if *flAdminListenAddress != "" {
+		if *flAdminClientCAFile == "" {
+			fmt.Fprintln(os.Stderr, "--admin-listen-address requires --admin-client-ca-file.")
+			os.Exit(1)
+		}
+		adminCerts, err := server.NewCertificateReloader(server.TLSOptions{
+			CertFile:           *flTLSCertFile,
+			KeyFile:            *flTLSKeyFile,
+			ClientCAFile:       *flAdminClientCAFile,
+			RequireClientCert:  true,
+			AllowedClientNames: *flAdminAllowedClientNames,
+			MinVersion:         *flTLSMinVersion,
+			CipherSuites:       *flTLSCipherSuites,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring the TLS of the admin listener: %v\n", err)
+			os.Exit(1)
+		}
+		adminCerts.ReloadInterval = *flTLSReloadInterval
+		go adminCerts.Run(ctx)
+		al, err := net.Listen("tcp", *flAdminListenAddress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", *flAdminListenAddress, err)
+			os.Exit(1)
+		}
+		endpoints = append(endpoints, server.Endpoint{Server: &http.Server{Handler: admin, TLSConfig: adminCerts.TLSConfig()}, Listener: al})
+	}
+
+	// Shut down gracefully on SIGTERM, e.g. during a rolling restart, so that in-flight reviews
+	// are not dropped. A second signal exits immediately.
+	shutdown, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	err = server.ServeAll(shutdown, endpoints, server.ShutdownOptions{
+		Drain: func() {
+			stopSignals()
+			checker.Drain()
+		},
+		Delay:   *flShutdownDelay,
+		Timeout: *flShutdownTimeout,
+	})
+	stop()
+	if closeErr := auditLog.Close(); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Error flushing the audit log: %v\n", closeErr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// openAuditLog returns the audit logger configured by the flags, or nil if no sink is configured.
+func openAuditLog() (*audit.Logger, error) {
+	sinks := []audit.Sink{}
+	closeSinks := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+	if *flAuditLogPath != "" {
+		s, err := audit.NewFileSink(*flAuditLogPath, *flAuditLogMaxSize<<20, *flAuditLogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if *flAuditStdout {
+		sinks = append(sinks, &audit.WriterSink{W: os.Stdout})
+	}
+	if *flAuditSyslog != "" {
+		s, err := audit.NewSyslogSink(*flAuditSyslog, *flAuditSyslogFacility)
+		if err != nil {
+			closeSinks()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	var key []byte
+	if *flAuditRedactKeyFile != "" {
+		var err error
+		if key, err = ioutil.ReadFile(*flAuditRedactKeyFile); err != nil {
+			closeSinks()
+			return nil, err
+		}
+	}
+	l, err := audit.NewLogger(audit.Options{
+		AllowedSampleRate: *flAuditSampleAllowed,
+		DeniedSampleRate:  *flAuditSampleDenied,
+		RedactedFields:    *flAuditRedact,
+		RedactionKey:      key,
+	}, sinks...)
+	if err != nil {
+		closeSinks()
+		return nil, err
+	}
+	return l, nil
 }
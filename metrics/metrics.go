@@ -0,0 +1,321 @@
+// Package metrics exposes the metrics of the webhook in the Prometheus text format.
+//
+// Label values often come from requests, e.g. the namespace of a SubjectAccessReview, so the
+// number of distinct values of every label is bounded: once MaxLabelValues values have been
+// seen, new values are recorded as OtherLabelValue. The number of series of every vector is
+// bounded too, since bounded labels still combine into many series: once MaxSeries series have
+// been recorded, new combinations of values are recorded in the series whose values are all
+// OtherLabelValue.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Path is the path under which the Registry is served.
+const Path = "/metrics"
+
+const (
+	// MaxLabelValues is the maximum number of distinct values recorded for a label.
+	MaxLabelValues = 100
+	// MaxSeries is the maximum number of series recorded by a vector, besides the series of
+	// the combinations beyond it.
+	MaxSeries = 1000
+	// OtherLabelValue replaces the values of a label beyond the first MaxLabelValues, and all
+	// the values of the series beyond the first MaxSeries.
+	OtherLabelValue = "other"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of latency histograms.
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Collector writes metric families in the text format.
+type Collector interface {
+	Collect(w io.Writer)
+}
+
+// Registry serves the metrics of its collectors:
+//
+//	GET /metrics
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds collectors to the registry. Their metrics are served in the order of registration.
+func (r *Registry) Register(cs ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, cs...)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s is not allowed, expected GET", req.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	r.mu.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(buf)
+	}
+	buf.Flush()
+}
+
+// Sample is a value of a metric, with the values of its labels.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// labels bounds the values of a set of labels, and formats them.
+type labels struct {
+	names []string
+	seen  []map[string]bool
+}
+
+func newLabels(names []string) labels {
+	l := labels{names: names, seen: make([]map[string]bool, len(names))}
+	for i := range l.seen {
+		l.seen[i] = map[string]bool{}
+	}
+	return l
+}
+
+// bound returns the values, with the values beyond the first MaxLabelValues of a label replaced
+// with OtherLabelValue. The caller must serialize calls.
+func (l labels) bound(values []string) []string {
+	if len(values) != len(l.names) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(l.names), len(values)))
+	}
+	bounded := make([]string, len(values))
+	for i, v := range values {
+		if !l.seen[i][v] {
+			if len(l.seen[i]) >= MaxLabelValues {
+				v = OtherLabelValue
+			} else {
+				l.seen[i][v] = true
+			}
+		}
+		bounded[i] = v
+	}
+	return bounded
+}
+
+// series returns the key and the values of the series that records the label values. The values
+// are bounded, and values that would add a series to the MaxSeries of keys are all replaced with
+// OtherLabelValue. The caller must serialize calls.
+func (l labels) series(keys map[string][]string, values []string) (string, []string) {
+	values = l.bound(values)
+	key := seriesKey(values)
+	if _, ok := keys[key]; !ok && len(keys) >= MaxSeries {
+		for i := range values {
+			values[i] = OtherLabelValue
+		}
+		key = seriesKey(values)
+	}
+	return key, values
+}
+
+// format returns the label set of the values, e.g. {verb="get",resource="pods"}, followed by
+// any extra label pairs.
+func format(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+// series is a sample keyed on its label values, so that samples are written in a stable order.
+type series struct {
+	key    string
+	values []string
+}
+
+func sortedSeries(keys map[string][]string) []series {
+	s := make([]series, 0, len(keys))
+	for k, v := range keys {
+		s = append(s, series{key: k, values: v})
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].key < s[j].key })
+	return s
+}
+
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a set of counters, one for every combination of label values.
+type CounterVec struct {
+	name, help string
+
+	mu     sync.Mutex
+	labels labels
+	keys   map[string][]string
+	counts map[string]float64
+}
+
+// NewCounterVec returns a CounterVec with the given labels.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: newLabels(labelNames), keys: map[string][]string{}, counts: map[string]float64{}}
+}
+
+// Inc increments the counter of the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of the label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, values := c.labels.series(c.keys, labelValues)
+	c.keys[key] = values
+	c.counts[key] += v
+}
+
+// Collect writes the counters.
+func (c *CounterVec) Collect(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, s := range sortedSeries(c.keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, format(c.labels.names, s.values), formatValue(c.counts[s.key]))
+	}
+}
+
+// HistogramVec is a set of histograms, one for every combination of label values.
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	labels labels
+	keys   map[string][]string
+	hists  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec returns a HistogramVec with the given bucket upper bounds, in increasing order,
+// and labels.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, buckets: buckets, labels: newLabels(labelNames), keys: map[string][]string{}, hists: map[string]*histogram{}}
+}
+
+// Observe records a value in the histogram of the label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key, values := h.labels.series(h.keys, labelValues)
+	hist, ok := h.hists[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.keys[key], h.hists[key] = values, hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Collect writes the histograms, with cumulative buckets.
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, s := range sortedSeries(h.keys) {
+		hist := h.hists[s.key]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, format(h.labels.names, s.values, "le", formatValue(b)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, format(h.labels.names, s.values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, format(h.labels.names, s.values), formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, format(h.labels.names, s.values), hist.count)
+	}
+}
+
+// Func is a metric whose samples are computed when it is collected, e.g. from the status of
+// another component.
+type Func struct {
+	name, help, typ string
+	labelNames      []string
+	samples         func() []Sample
+}
+
+// NewGaugeFunc returns a gauge whose samples are returned by f.
+func NewGaugeFunc(name, help string, labelNames []string, f func() []Sample) *Func {
+	return &Func{name: name, help: help, typ: "gauge", labelNames: labelNames, samples: f}
+}
+
+// NewCounterFunc returns a counter whose samples are returned by f. The values must never decrease.
+func NewCounterFunc(name, help string, labelNames []string, f func() []Sample) *Func {
+	return &Func{name: name, help: help, typ: "counter", labelNames: labelNames, samples: f}
+}
+
+// Collect writes the samples.
+func (f *Func) Collect(w io.Writer) {
+	writeHeader(w, f.name, f.help, f.typ)
+	for _, s := range f.samples() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, format(f.labelNames, s.LabelValues), formatValue(s.Value))
+	}
+}
+
+// Value returns a single sample without labels, for metrics that have none.
+func Value(v float64) []Sample {
+	return []Sample{{Value: v}}
+}
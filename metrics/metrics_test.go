@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", Path, nil))
+	if w.Code != 200 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected metrics in the text format, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	return w.Body.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	r := NewRegistry()
+	decisions := NewCounterVec("decisions_total", "Number of decisions.", "result", "path")
+	latency := NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "op")
+	r.Register(decisions, latency, NewGaugeFunc("up", "Whether it is up.\nAlways.", nil, func() []Sample { return Value(1) }))
+
+	decisions.Inc("allowed", "/api")
+	decisions.Add(2, "denied", `C:\"x"`+"\n")
+	decisions.Inc("allowed", "/api")
+	latency.Observe(0.05, "get")
+	latency.Observe(0.5, "get")
+	latency.Observe(2, "get")
+
+	expected := `# HELP decisions_total Number of decisions.
+# TYPE decisions_total counter
+decisions_total{result="allowed",path="/api"} 2
+decisions_total{result="denied",path="C:\\\"x\"\n"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 1
+latency_seconds_bucket{op="get",le="1"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 2.55
+latency_seconds_count{op="get"} 3
+# HELP up Whether it is up.\nAlways.
+# TYPE up gauge
+up 1
+`
+	if got := scrape(t, r); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestLabelValuesAreBounded(t *testing.T) {
+	c := NewCounterVec("requests_total", "Requests.", "namespace", "verb")
+	for i := 0; i < MaxLabelValues*3; i++ {
+		c.Inc(fmt.Sprintf("ns-%d", i), "get")
+	}
+	// Values seen before the limit are still recorded
+	c.Inc("ns-0", "list")
+
+	r := NewRegistry()
+	r.Register(c)
+	out := scrape(t, r)
+	if series := strings.Count(out, "requests_total{"); series != MaxLabelValues+2 {
+		t.Errorf("Expected %d series, got %d", MaxLabelValues+2, series)
+	}
+	if !strings.Contains(out, `requests_total{namespace="other",verb="get"} 200`) {
+		t.Errorf("Expected the values beyond the limit to be recorded as %s, got:\n%s", OtherLabelValue, out)
+	}
+	if !strings.Contains(out, `requests_total{namespace="ns-0",verb="list"} 1`) {
+		t.Errorf("Expected known values to be recorded, got:\n%s", out)
+	}
+}
+
+func TestSeriesAreBounded(t *testing.T) {
+	c := NewCounterVec("requests_total", "Requests.", "namespace", "resource", "verb")
+	h := NewHistogramVec("latency_seconds", "Latency.", []float64{1}, "namespace", "resource")
+	// Every label stays within MaxLabelValues, but their combinations do not
+	for i := 0; i < MaxSeries*2; i++ {
+		ns, resource := fmt.Sprintf("ns-%d", i%MaxLabelValues), fmt.Sprintf("r-%d", i/MaxLabelValues)
+		c.Inc(ns, resource, "get")
+		h.Observe(0.5, ns, resource)
+	}
+	// Series recorded before the limit are still recorded
+	c.Inc("ns-0", "r-0", "get")
+
+	r := NewRegistry()
+	r.Register(c, h)
+	out := scrape(t, r)
+	if series := strings.Count(out, "requests_total{"); series != MaxSeries+1 {
+		t.Errorf("Expected %d counter series, got %d", MaxSeries+1, series)
+	}
+	if series := strings.Count(out, "latency_seconds_count{"); series != MaxSeries+1 {
+		t.Errorf("Expected %d histogram series, got %d", MaxSeries+1, series)
+	}
+	if !strings.Contains(out, `requests_total{namespace="other",resource="other",verb="other"} 1000`) {
+		t.Errorf("Expected the series beyond the limit to be recorded as %s, got:\n%s", OtherLabelValue, out)
+	}
+	if !strings.Contains(out, `latency_seconds_count{namespace="other",resource="other"} 1000`) {
+		t.Errorf("Expected the series beyond the limit to be recorded as %s, got:\n%s", OtherLabelValue, out)
+	}
+	if !strings.Contains(out, `requests_total{namespace="ns-0",resource="r-0",verb="get"} 2`) {
+		t.Errorf("Expected known series to be recorded, got:\n%s", out)
+	}
+}
+
+// failingRepo fails to be read while err is set.
+type failingRepo struct {
+	*repository.Document
+	err error
+}
+
+func (r *failingRepo) Snapshot() (*repository.Snapshot, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.Document.Snapshot()
+}
+
+func TestPolicyMonitor(t *testing.T) {
+	repo := &failingRepo{Document: &repository.Document{}}
+	if err := repo.CreateRole(api.Role{Name: "view", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	m := &PolicyMonitor{Repo: repo}
+	r := NewRegistry()
+	r.Register(m)
+
+	m.Check()
+	m.Check()
+	repo.err = errors.New("unavailable")
+	if err := m.Check(); err == nil {
+		t.Errorf("Expected error reading the policy")
+	}
+	repo.err = nil
+	if err := repo.CreateRole(api.Role{Name: "edit", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Check()
+
+	out := scrape(t, r)
+	for _, expected := range []string{
+		`kubernetes_rbac_policy_reloads_total{result="success"} 2`,
+		`kubernetes_rbac_policy_reloads_total{result="failure"} 1`,
+		`kubernetes_rbac_policy_revision_info{revision="2"} 1`,
+		`kubernetes_rbac_policy_objects{kind="Role"} 2`,
+		`kubernetes_rbac_policy_objects{kind="ClusterRole"} 0`,
+	} {
+		if !strings.Contains(out, expected+"\n") {
+			t.Errorf("Expected %s, got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestLookupRepository(t *testing.T) {
+	lookups := NewHistogramVec("lookups_seconds", "Lookups.", DefaultBuckets, "operation")
+	repo := &LookupRepository{PolicyRepository: &repository.Document{}, Lookups: lookups}
+	repo.ListRoleBindings(api.NamespaceAll, repository.ListOptions{})
+	repo.GetRole("view", "default")
+	repo.ListRoles(api.NamespaceAll, repository.ListOptions{})
+
+	r := NewRegistry()
+	r.Register(lookups)
+	out := scrape(t, r)
+	if !strings.Contains(out, `lookups_seconds_count{operation="ListRoleBindings"} 1`) || !strings.Contains(out, `lookups_seconds_count{operation="GetRole"} 1`) {
+		t.Errorf("Expected the lookups to be recorded, got:\n%s", out)
+	}
+	if strings.Contains(out, "ListRoles") {
+		t.Errorf("Expected other calls not to be recorded, got:\n%s", out)
+	}
+}
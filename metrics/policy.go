@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// DefaultPolicyCheckInterval is the default for how often a PolicyMonitor reads the policy.
+const DefaultPolicyCheckInterval = 10 * time.Second
+
+// PolicyMonitor reads the policy every CheckInterval, and reports whether it could be read and
+// is valid, its revision and the number of objects of every kind.
+type PolicyMonitor struct {
+	Repo repository.PolicyRepository
+	// Source describes where the policy is read from, e.g. "file:rbac-policy.json".
+	Source string
+	// CheckInterval is how often the policy is read. Defaults to DefaultPolicyCheckInterval.
+	CheckInterval time.Duration
+
+	mu         sync.Mutex
+	revision   string
+	counts     map[string]int
+	reloads    uint64
+	failures   uint64
+	lastReload time.Time
+	lastCheck  time.Time
+	lastErr    error
+}
+
+// PolicyStatus describes the policy as of the last check of a PolicyMonitor.
+type PolicyStatus struct {
+	Source string `json:"source,omitempty"`
+	// Revision of the last valid policy that was read. Empty until a valid policy is read.
+	Revision string `json:"revision"`
+	// LoadTime is the time at which the revision was first read.
+	LoadTime time.Time `json:"loadTime"`
+	// LastCheck is the time of the last check.
+	LastCheck time.Time `json:"lastCheck"`
+	// LastError is the error of the last check, if the policy could not be read or is invalid.
+	LastError string `json:"lastError,omitempty"`
+	// Objects is the number of objects of the policy, by kind.
+	Objects map[string]int `json:"objects,omitempty"`
+}
+
+// Check reads and validates the policy. A new valid revision counts as a successful reload, and
+// an error as a failed one.
+func (m *PolicyMonitor) Check() error {
+	snap, err := repository.ReadSnapshot(m.Repo)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastCheck = time.Now()
+	if err == nil && (snap.Revision != m.revision || m.counts == nil) {
+		if err = repository.ValidatePolicy(snap.Policy); err != nil {
+			err = fmt.Errorf("Invalid policy revision %s: %v", snap.Revision, err)
+		}
+	}
+	m.lastErr = err
+	if err != nil {
+		m.failures++
+		return err
+	}
+	if snap.Revision == m.revision && m.counts != nil {
+		return nil
+	}
+	m.revision = snap.Revision
+	m.counts = map[string]int{
+		api.RoleKind:               len(snap.Policy.Roles),
+		api.RoleBindingKind:        len(snap.Policy.RoleBindings),
+		api.ClusterRoleKind:        len(snap.Policy.ClusterRoles),
+		api.ClusterRoleBindingKind: len(snap.Policy.ClusterRoleBindings),
+	}
+	m.reloads++
+	m.lastReload = time.Now()
+	return nil
+}
+
+// Revision returns the revision of the policy as of the last check, or an empty string if it
+// could not be read yet.
+func (m *PolicyMonitor) Revision() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revision
+}
+
+// Status returns the status of the policy as of the last check.
+func (m *PolicyMonitor) Status() PolicyStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := PolicyStatus{
+		Source:    m.Source,
+		Revision:  m.revision,
+		LoadTime:  m.lastReload,
+		LastCheck: m.lastCheck,
+	}
+	if m.lastErr != nil {
+		s.LastError = m.lastErr.Error()
+	}
+	if m.counts != nil {
+		s.Objects = map[string]int{}
+		for kind, n := range m.counts {
+			s.Objects[kind] = n
+		}
+	}
+	return s
+}
+
+// Ready returns an error unless the last check read a valid policy.
+func (m *PolicyMonitor) Ready() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.lastErr != nil:
+		return m.lastErr
+	case m.counts == nil:
+		return errors.New("The policy has not been read yet")
+	}
+	return nil
+}
+
+// Run checks the policy every CheckInterval until the context is done. Errors are logged.
+func (m *PolicyMonitor) Run(ctx context.Context) {
+	interval := m.CheckInterval
+	if interval <= 0 {
+		interval = DefaultPolicyCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(); err != nil {
+			log.Printf("Error reading the RBAC policy: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Collect writes the metrics of the policy.
+func (m *PolicyMonitor) Collect(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	NewCounterFunc("kubernetes_rbac_policy_reloads_total", "Number of times a new revision of the policy was loaded, or could not be read.", []string{"result"}, func() []Sample {
+		return []Sample{{LabelValues: []string{"success"}, Value: float64(m.reloads)}, {LabelValues: []string{"failure"}, Value: float64(m.failures)}}
+	}).Collect(w)
+	if m.counts == nil {
+		return
+	}
+	NewGaugeFunc("kubernetes_rbac_policy_revision_info", "Revision of the policy being served.", []string{"revision"}, func() []Sample {
+		return []Sample{{LabelValues: []string{m.revision}, Value: 1}}
+	}).Collect(w)
+	NewGaugeFunc("kubernetes_rbac_policy_last_reload_timestamp_seconds", "Time at which the revision of the policy being served was loaded.", nil, func() []Sample {
+		return Value(float64(m.lastReload.Unix()))
+	}).Collect(w)
+	NewGaugeFunc("kubernetes_rbac_policy_objects", "Number of objects of the policy, by kind.", []string{"kind"}, func() []Sample {
+		samples := []Sample{}
+		for _, kind := range []string{api.RoleKind, api.RoleBindingKind, api.ClusterRoleKind, api.ClusterRoleBindingKind} {
+			samples = append(samples, Sample{LabelValues: []string{kind}, Value: float64(m.counts[kind])})
+		}
+		return samples
+	}).Collect(w)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// LookupRepository records how long the lookups used to evaluate reviews take, labeled by
+// operation. Other calls are passed through without being recorded.
+type LookupRepository struct {
+	repository.PolicyRepository
+	Lookups *HistogramVec
+}
+
+func (r *LookupRepository) observe(operation string, start time.Time) {
+	r.Lookups.Observe(time.Since(start).Seconds(), operation)
+}
+
+// GetRole with the given name and namespace.
+func (r *LookupRepository) GetRole(name, namespace string) (*api.Role, error) {
+	defer r.observe("GetRole", time.Now())
+	return r.PolicyRepository.GetRole(name, namespace)
+}
+
+// ListRoleBindings in the given namespace, or in all namespaces if namespace is api.NamespaceAll.
+func (r *LookupRepository) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
+	defer r.observe("ListRoleBindings", time.Now())
+	return r.PolicyRepository.ListRoleBindings(namespace, opts)
+}
+
+// GetClusterRole with the given name.
+func (r *LookupRepository) GetClusterRole(name string) (*api.ClusterRole, error) {
+	defer r.observe("GetClusterRole", time.Now())
+	return r.PolicyRepository.GetClusterRole(name)
+}
+
+// ListClusterRoleBindings that match the given options.
+func (r *LookupRepository) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	defer r.observe("ListClusterRoleBindings", time.Now())
+	return r.PolicyRepository.ListClusterRoleBindings(opts)
+}
@@ -0,0 +1,125 @@
+// Package policyapi implements HTTP endpoints for managing the RBAC policy.
+package policyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// HistoryPath is the path under which the HistoryHandler is served.
+const HistoryPath = "/policy/history"
+
+// HistoryHandler serves the revision history of the policy:
+//
+//	GET  /policy/history                lists the revisions, from the newest to the oldest
+//	GET  /policy/history/<rev>          returns the policy of a revision
+//	POST /policy/history/<rev>/rollback rolls the policy back to a revision
+//
+// Rollbacks accept the dryRun and message query parameters, and return the changes that were
+// made. Their author is the client: the common name of its verified certificate, or its first
+// DNS name. The author query parameter is only added to the message, as the person on whose
+// behalf the client rolled back.
+type HistoryHandler struct {
+	Repo repository.HistoryRepository
+}
+
+func (hh *HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, HistoryPath), "/")
+	parts := []string{}
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		revs, err := hh.Repo.History()
+		writeResponse(w, revs, err)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		p, err := hh.Repo.ShowRevision(parts[0])
+		writeResponse(w, p, err)
+	case len(parts) == 2 && parts[1] == "rollback" && r.Method == http.MethodPost:
+		author := clientName(r)
+		if author == "" {
+			writeError(w, http.StatusForbidden, "a verified client certificate with a name is required to roll back")
+			return
+		}
+		q := r.URL.Query()
+		opts := repository.ApplyOptions{
+			DryRun:  q.Get("dryRun") == "true",
+			Author:  author,
+			Message: q.Get("message"),
+		}
+		if onBehalfOf := q.Get("author"); onBehalfOf != "" {
+			if opts.Message == "" {
+				opts.Message = fmt.Sprintf("Rollback to revision %s", parts[0])
+			}
+			opts.Message += fmt.Sprintf(" (on behalf of %s)", onBehalfOf)
+		}
+		changes, err := hh.Repo.Rollback(parts[0], opts)
+		if changes == nil {
+			changes = []repository.Change{}
+		}
+		writeResponse(w, changes, err)
+	case len(parts) <= 1 || (len(parts) == 2 && parts[1] == "rollback"):
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// clientName returns the common name of the verified client certificate of the request, or its
+// first DNS name, or an empty string without one.
+func clientName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := r.TLS.PeerCertificates[0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// errorResponse is the body of error responses.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeResponse writes v as JSON, or the error with the status code that matches it.
+func writeResponse(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		log.Printf("Error handling policy request: %v", err)
+		code := repository.HTTPStatusCode(err)
+		msg := err.Error()
+		if code == http.StatusInternalServerError {
+			// Do not leak the details of internal errors, such as file paths
+			msg = "internal error"
+		}
+		writeError(w, code, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorResponse{Error: msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(payload)
+}
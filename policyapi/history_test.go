@@ -0,0 +1,124 @@
+package policyapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+	"github.com/kismatic/kubernetes-rbac/repository/file"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-policyapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := file.Create(filepath.Join(dir, "policy.json"))
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	role := api.Role{Name: "view", Namespace: "default", Rules: []api.PolicyRule{{Verbs: []string{"get"}}}}
+	if err = repo.CreateRole(role); err != nil {
+		t.Fatalf("Error creating role: %v", err)
+	}
+	if err = repo.DeleteRole(role.Name, role.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+
+	server := httptest.NewServer(&HistoryHandler{Repo: repo.(repository.HistoryRepository)})
+	defer server.Close()
+
+	revs := []repository.Revision{}
+	if code := doRequest(t, http.MethodGet, server.URL+HistoryPath, &revs); code != http.StatusOK {
+		t.Fatalf("Expected status 200 listing revisions, got %d", code)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("Expected 3 revisions, got %+v", revs)
+	}
+
+	p := api.Policy{}
+	if code := doRequest(t, http.MethodGet, server.URL+HistoryPath+"/"+revs[1].ID, &p); code != http.StatusOK {
+		t.Fatalf("Expected status 200 showing revision, got %d", code)
+	}
+	if len(p.Roles) != 1 {
+		t.Errorf("Expected revision %s to have the role, got %+v", revs[1].ID, p)
+	}
+
+	// Rollbacks are authored by the client certificate, whatever the author query parameter
+	hh := &HistoryHandler{Repo: repo.(repository.HistoryRepository)}
+	rollback := httptest.NewRequest(http.MethodPost, HistoryPath+"/"+revs[1].ID+"/rollback?message=restore&author=mallory", nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	rollback.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w := httptest.NewRecorder()
+	hh.ServeHTTP(w, rollback)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 rolling back, got %d: %s", w.Code, w.Body.String())
+	}
+	changes := []repository.Change{}
+	if err = json.NewDecoder(w.Body).Decode(&changes); err != nil || len(changes) != 1 {
+		t.Errorf("Expected 1 change rolling back, got %v, %v", changes, err)
+	}
+	if _, err = repo.GetRole(role.Name, role.Namespace); err != nil {
+		t.Errorf("Expected role to be restored: %v", err)
+	}
+	if revs, err = repo.(repository.HistoryRepository).History(); err != nil {
+		t.Fatalf("Error listing revisions: %v", err)
+	}
+	if revs[0].Author != "alice" || revs[0].Message != "restore (on behalf of mallory)" {
+		t.Errorf("Expected the rollback to be authored by the client certificate, got %+v", revs[0])
+	}
+
+	rollback = httptest.NewRequest(http.MethodPost, HistoryPath+"/999/rollback", nil)
+	rollback.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w = httptest.NewRecorder()
+	hh.ServeHTTP(w, rollback)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 rolling back to a missing revision, got %d", w.Code)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, HistoryPath + "/999", http.StatusNotFound},
+		{http.MethodPost, HistoryPath + "/1/rollback?author=alice", http.StatusForbidden},
+		{http.MethodGet, HistoryPath + "/1/rollback", http.StatusMethodNotAllowed},
+		{http.MethodDelete, HistoryPath, http.StatusMethodNotAllowed},
+		{http.MethodGet, HistoryPath + "/1/other", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if code := doRequest(t, test.method, server.URL+test.path, nil); code != test.code {
+			t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, test.code, code)
+		}
+	}
+}
+
+func doRequest(t *testing.T, method, url string, v interface{}) int {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+	}
+	return resp.StatusCode
+}
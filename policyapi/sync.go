@@ -0,0 +1,32 @@
+package policyapi
+
+import (
+	"net/http"
+
+	"github.com/kismatic/kubernetes-rbac/repository/remote"
+)
+
+// SyncPath is the path under which the SyncStatusHandler is served.
+const SyncPath = "/policy/sync"
+
+// SyncStatusHandler serves the synchronization status of a remote policy bundle:
+//
+//	GET /policy/sync
+type SyncStatusHandler struct {
+	Repo *remote.Repository
+}
+
+// syncStatus is the body of the response, with the age of the last successful sync in seconds.
+type syncStatus struct {
+	remote.Status
+	AgeSeconds float64 `json:"ageSeconds"`
+}
+
+func (sh *SyncStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s := sh.Repo.Status()
+	writeJSON(w, http.StatusOK, syncStatus{Status: s, AgeSeconds: s.Age.Seconds()})
+}
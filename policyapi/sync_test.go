@@ -0,0 +1,42 @@
+package policyapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/repository/remote"
+)
+
+func TestSyncStatusHandler(t *testing.T) {
+	bundle := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"ClusterRoles": [{"name": "view"}]}`))
+	}))
+	defer bundle.Close()
+
+	repo, err := remote.Create(bundle.URL, bundle.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	(&SyncStatusHandler{Repo: repo}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SyncPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	status := map[string]interface{}{}
+	if err = json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status["etag"] != `"v1"` || status["revision"] == "" {
+		t.Errorf("Unexpected sync status: %v", status)
+	}
+	if _, ok := status["ageSeconds"].(float64); !ok {
+		t.Errorf("Expected age of the last sync in seconds, got %v", status)
+	}
+}
@@ -0,0 +1,465 @@
+// Package rbacv1 converts the policy to and from the rbac.authorization.k8s.io/v1 objects of
+// the Kubernetes API.
+//
+// The policy is exported as a List of Roles, RoleBindings, ClusterRoles and ClusterRoleBindings
+// in YAML, which can be created with kubectl, and is imported from the output of
+// 'kubectl get roles,rolebindings,clusterroles,clusterrolebindings -A -o yaml'. Names,
+// namespaces, labels, annotations, rules, subjects and role references are mapped field for
+// field, including the apiGroup of subjects and role references. The uid, resourceVersion and
+// creationTimestamp are assigned by the API server or by the policy repository, so they are
+// neither exported nor imported. Fields and objects that cannot be translated are returned as
+// Issues.
+package rbacv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+	"gopkg.in/yaml.v2"
+)
+
+// Issue is a field or object that could not be translated exactly.
+type Issue struct {
+	// Kind of the object.
+	Kind string `json:"kind"`
+	// Name of the object.
+	Name string `json:"name"`
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	// Message describes the issue.
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s '%s': %s", i.Kind, repository.ObjectKey(i.Namespace, i.Name), i.Message)
+}
+
+// Result of an import.
+type Result struct {
+	// Policy holds the imported roles, cluster roles and bindings.
+	Policy api.Policy
+	// Issues holds the objects and fields that were not imported.
+	Issues []Issue
+}
+
+// issues collects the issues of a conversion.
+type issues []Issue
+
+func (is *issues) report(kind, name, namespace, format string, args ...interface{}) {
+	*is = append(*is, Issue{Kind: kind, Name: name, Namespace: namespace, Message: fmt.Sprintf(format, args...)})
+}
+
+// Export writes the policy as a List of RBAC objects in YAML. Roles and role bindings come
+// first, followed by cluster roles and cluster role bindings, in the order of the policy.
+func Export(p api.Policy, w io.Writer) ([]Issue, error) {
+	is := issues{}
+	list := List{APIVersion: listAPIVersion, Kind: ListKind, Items: []Object{}}
+	for _, r := range p.Roles {
+		list.Items = append(list.Items, Object{
+			APIVersion: APIVersion,
+			Kind:       api.RoleKind,
+			Metadata:   ObjectMeta{Name: r.Name, Namespace: r.Namespace, Labels: r.Labels, Annotations: r.Annotations},
+			Rules:      exportRules(r.Rules),
+		})
+	}
+	for _, rb := range p.RoleBindings {
+		report := func(format string, args ...interface{}) {
+			is.report(api.RoleBindingKind, rb.Name, rb.Namespace, format, args...)
+		}
+		if rb.RoleRef.Kind == api.RoleKind && rb.RoleRef.Namespace != rb.Namespace {
+			report("the role reference is in namespace '%s', but Kubernetes only references roles in the namespace of the binding; not exported", rb.RoleRef.Namespace)
+			continue
+		}
+		if rb.RoleRef.Kind == api.ClusterRoleKind && rb.RoleRef.Namespace != "" {
+			report("the namespace of the cluster role reference has no Kubernetes equivalent and was dropped")
+		}
+		list.Items = append(list.Items, Object{
+			APIVersion: APIVersion,
+			Kind:       api.RoleBindingKind,
+			Metadata:   ObjectMeta{Name: rb.Name, Namespace: rb.Namespace, Labels: rb.Labels, Annotations: rb.Annotations},
+			RoleRef:    &RoleRef{APIGroup: GroupName, Kind: rb.RoleRef.Kind, Name: rb.RoleRef.Name},
+			Subjects:   exportSubjects(rb.Subjects, report),
+		})
+	}
+	for _, cr := range p.ClusterRoles {
+		list.Items = append(list.Items, Object{
+			APIVersion: APIVersion,
+			Kind:       api.ClusterRoleKind,
+			Metadata:   ObjectMeta{Name: cr.Name, Labels: cr.Labels, Annotations: cr.Annotations},
+			Rules:      exportRules(cr.Rules),
+		})
+	}
+	for _, crb := range p.ClusterRoleBindings {
+		report := func(format string, args ...interface{}) {
+			is.report(api.ClusterRoleBindingKind, crb.Name, "", format, args...)
+		}
+		if crb.RoleRef.Namespace != "" {
+			report("the namespace of the cluster role reference has no Kubernetes equivalent and was dropped")
+		}
+		list.Items = append(list.Items, Object{
+			APIVersion: APIVersion,
+			Kind:       api.ClusterRoleBindingKind,
+			Metadata:   ObjectMeta{Name: crb.Name, Labels: crb.Labels, Annotations: crb.Annotations},
+			RoleRef:    &RoleRef{APIGroup: GroupName, Kind: crb.RoleRef.Kind, Name: crb.RoleRef.Name},
+			Subjects:   exportSubjects(crb.Subjects, report),
+		})
+	}
+
+	b, err := yaml.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	return is, nil
+}
+
+func exportRules(rules []api.PolicyRule) []PolicyRule {
+	out := []PolicyRule{}
+	for _, r := range rules {
+		out = append(out, PolicyRule{
+			APIGroups:       r.APIGroups,
+			NonResourceURLs: r.NonResourceURLs,
+			ResourceNames:   r.ResourceNames,
+			Resources:       r.Resources,
+			Verbs:           r.Verbs,
+		})
+	}
+	return out
+}
+
+func exportSubjects(subjects []api.Subject, report func(format string, args ...interface{})) []Subject {
+	out := []Subject{}
+	for _, s := range subjects {
+		switch s.Kind {
+		case api.UserKind, api.GroupKind:
+			if s.Kind == api.UserKind && s.Name == api.UserAll {
+				report("the subject User '%s' matches every user, which Kubernetes subjects cannot express; not exported", s.Name)
+				continue
+			}
+			if s.Namespace != "" {
+				report("the namespace '%s' of the subject %s '%s' has no Kubernetes equivalent and was dropped", s.Namespace, s.Kind, s.Name)
+			}
+			out = append(out, Subject{APIGroup: GroupName, Kind: s.Kind, Name: s.Name})
+		default:
+			out = append(out, Subject{Kind: s.Kind, Name: s.Name, Namespace: s.Namespace})
+		}
+	}
+	return out
+}
+
+var (
+	// serverFields are the metadata fields assigned by the API server, which are not imported.
+	serverFields = fieldSet("uid", "resourceVersion", "creationTimestamp", "generation", "selfLink", "managedFields")
+	metaFields   = fieldSet("name", "namespace", "labels", "annotations")
+	roleFields   = fieldSet("apiVersion", "kind", "metadata", "rules")
+	// aggregationRule is reported separately
+	clusterRoleFields = fieldSet("apiVersion", "kind", "metadata", "rules", "aggregationRule")
+	bindingFields     = fieldSet("apiVersion", "kind", "metadata", "roleRef", "subjects")
+	ruleFields        = fieldSet("apiGroups", "nonResourceURLs", "resourceNames", "resources", "verbs")
+	subjectFields     = fieldSet("apiGroup", "kind", "name", "namespace")
+	roleRefFields     = fieldSet("apiGroup", "kind", "name")
+)
+
+// Import reads RBAC objects in YAML or JSON, either as a List or as a stream of objects, and
+// converts them. An error is returned if the input cannot be parsed.
+func Import(r io.Reader) (*Result, error) {
+	is := issues{}
+	p := api.Policy{}
+
+	dec := yaml.NewDecoder(r)
+	for n := 1; ; n++ {
+		var raw interface{}
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing document %d: %v", n, err)
+		}
+		if raw == nil {
+			continue
+		}
+		doc, err := toJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing document %d: %v", n, err)
+		}
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Error parsing document %d: expected an object", n)
+		}
+
+		items := []interface{}{obj}
+		if obj["kind"] == ListKind {
+			if items, ok = obj["items"].([]interface{}); !ok && obj["items"] != nil {
+				return nil, fmt.Errorf("Error parsing document %d: expected the items of the List to be a sequence", n)
+			}
+		}
+		for i, item := range items {
+			if err = importObject(item, &p, &is); err != nil {
+				return nil, fmt.Errorf("Error parsing object %d of document %d: %v", i+1, n, err)
+			}
+		}
+	}
+	return &Result{Policy: p, Issues: is}, nil
+}
+
+// importObject adds an RBAC object to the policy, and records the issues that prevent
+// converting it exactly.
+func importObject(item interface{}, p *api.Policy, is *issues) error {
+	raw, ok := item.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected an object")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	obj := Object{}
+	if err = json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	meta := obj.Metadata
+	report := func(format string, args ...interface{}) {
+		is.report(obj.Kind, meta.Name, meta.Namespace, format, args...)
+	}
+
+	var known map[string]bool
+	switch obj.Kind {
+	case api.RoleKind:
+		known = roleFields
+	case api.ClusterRoleKind:
+		known = clusterRoleFields
+	case api.RoleBindingKind, api.ClusterRoleBindingKind:
+		known = bindingFields
+	default:
+		report("the object is not an RBAC object; not imported")
+		return nil
+	}
+	if obj.APIVersion != APIVersion {
+		report("the API version '%s' is not supported, expected '%s'; not imported", obj.APIVersion, APIVersion)
+		return nil
+	}
+	if meta.Name == "" {
+		report("the object has no name; not imported")
+		return nil
+	}
+	namespaced := obj.Kind == api.RoleKind || obj.Kind == api.RoleBindingKind
+	if namespaced && meta.Namespace == "" {
+		report("the object has no namespace; not imported")
+		return nil
+	}
+	if !namespaced && meta.Namespace != "" {
+		report("the namespace of a cluster-scoped object has no meaning and was dropped")
+		meta.Namespace = ""
+	}
+
+	for _, f := range unknownFields(raw, known) {
+		report("the field '%s' has no equivalent and was dropped", f)
+	}
+	if m, ok := raw["metadata"].(map[string]interface{}); ok {
+		for _, f := range unknownFields(m, metaFields, serverFields) {
+			report("the field 'metadata.%s' has no equivalent and was dropped", f)
+		}
+	}
+	for i, r := range sequence(raw["rules"]) {
+		for _, f := range unknownFields(r, ruleFields) {
+			report("the field 'rules[%d].%s' has no equivalent and was dropped", i, f)
+		}
+	}
+	for i, s := range sequence(raw["subjects"]) {
+		for _, f := range unknownFields(s, subjectFields) {
+			report("the field 'subjects[%d].%s' has no equivalent and was dropped", i, f)
+		}
+	}
+	if r, ok := raw["roleRef"].(map[string]interface{}); ok {
+		for _, f := range unknownFields(r, roleRefFields) {
+			report("the field 'roleRef.%s' has no equivalent and was dropped", f)
+		}
+	}
+
+	switch obj.Kind {
+	case api.RoleKind:
+		p.Roles = append(p.Roles, api.Role{
+			Name:        meta.Name,
+			Namespace:   meta.Namespace,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+			Rules:       importRules(obj.Rules),
+		})
+	case api.ClusterRoleKind:
+		if _, ok := raw["aggregationRule"]; ok {
+			report("cluster role aggregation is not supported; only the rules were imported")
+		}
+		p.ClusterRoles = append(p.ClusterRoles, api.ClusterRole{
+			Name:        meta.Name,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+			Rules:       importRules(obj.Rules),
+		})
+	case api.RoleBindingKind:
+		ref, ok := importRoleRef(obj.RoleRef, meta.Namespace, report)
+		if !ok {
+			return nil
+		}
+		p.RoleBindings = append(p.RoleBindings, api.RoleBinding{
+			Name:        meta.Name,
+			Namespace:   meta.Namespace,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+			Subjects:    importSubjects(obj.Subjects, report),
+			RoleRef:     ref,
+		})
+	case api.ClusterRoleBindingKind:
+		ref, ok := importRoleRef(obj.RoleRef, "", report)
+		if !ok {
+			return nil
+		}
+		p.ClusterRoleBindings = append(p.ClusterRoleBindings, api.ClusterRoleBinding{
+			Name:        meta.Name,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+			Subjects:    importSubjects(obj.Subjects, report),
+			RoleRef:     ref,
+		})
+	}
+	return nil
+}
+
+func importRules(rules []PolicyRule) []api.PolicyRule {
+	out := []api.PolicyRule{}
+	for _, r := range rules {
+		out = append(out, api.PolicyRule{
+			Verbs:           r.Verbs,
+			APIGroups:       r.APIGroups,
+			Resources:       r.Resources,
+			ResourceNames:   r.ResourceNames,
+			NonResourceURLs: r.NonResourceURLs,
+		})
+	}
+	return out
+}
+
+// importRoleRef returns the reference to the role of a binding in the given namespace, which
+// is empty for cluster role bindings.
+func importRoleRef(ref *RoleRef, namespace string, report func(format string, args ...interface{})) (api.ObjectReference, bool) {
+	switch {
+	case ref == nil:
+		report("the binding has no role reference; not imported")
+		return api.ObjectReference{}, false
+	case ref.APIGroup != GroupName:
+		report("the role reference has API group '%s', expected '%s'; not imported", ref.APIGroup, GroupName)
+		return api.ObjectReference{}, false
+	case ref.Kind == api.RoleKind && namespace != "":
+		return api.ObjectReference{Kind: api.RoleKind, Name: ref.Name, Namespace: namespace}, true
+	case ref.Kind == api.ClusterRoleKind:
+		return api.ObjectReference{Kind: api.ClusterRoleKind, Name: ref.Name}, true
+	}
+	report("the role reference has unsupported kind '%s'; not imported", ref.Kind)
+	return api.ObjectReference{}, false
+}
+
+func importSubjects(subjects []Subject, report func(format string, args ...interface{})) []api.Subject {
+	out := []api.Subject{}
+	for _, s := range subjects {
+		switch s.Kind {
+		case api.UserKind, api.GroupKind:
+			// The API server defaults the API group of users and groups
+			if s.APIGroup != GroupName && s.APIGroup != "" {
+				report("the subject %s '%s' has API group '%s', expected '%s'; not imported", s.Kind, s.Name, s.APIGroup, GroupName)
+				continue
+			}
+			if s.Kind == api.UserKind && s.Name == api.UserAll {
+				report("the subject User '%s' would match every user in this policy; not imported", s.Name)
+				continue
+			}
+			if s.Namespace != "" {
+				report("the namespace '%s' of the subject %s '%s' has no meaning and was dropped", s.Namespace, s.Kind, s.Name)
+			}
+			out = append(out, api.Subject{Kind: s.Kind, Name: s.Name})
+		case api.ServiceAccountKind:
+			if s.APIGroup != "" {
+				report("the subject ServiceAccount '%s' has API group '%s', expected none; not imported", s.Name, s.APIGroup)
+				continue
+			}
+			if s.Namespace == "" {
+				report("the subject ServiceAccount '%s' has no namespace; not imported", s.Name)
+				continue
+			}
+			out = append(out, api.Subject{Kind: s.Kind, Name: s.Name, Namespace: s.Namespace})
+		default:
+			report("the subject kind '%s' is not supported; subject '%s' not imported", s.Kind, s.Name)
+		}
+	}
+	return out
+}
+
+func fieldSet(fields ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, f := range fields {
+		set[f] = true
+	}
+	return set
+}
+
+// unknownFields returns the sorted fields of an object that are in none of the given sets.
+func unknownFields(obj interface{}, sets ...map[string]bool) []string {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	fields := []string{}
+	for f := range m {
+		known := false
+		for _, set := range sets {
+			known = known || set[f]
+		}
+		if !known {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func sequence(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// toJSON converts a decoded YAML value to the equivalent JSON value, in which the keys of
+// mappings are strings.
+func toJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported mapping key %v", k)
+			}
+			converted, err := toJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			converted, err := toJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = converted
+		}
+		return s, nil
+	}
+	return v, nil
+}
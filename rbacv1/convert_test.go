@@ -0,0 +1,265 @@
+package rbacv1
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// kubectlOutput is trimmed output of kubectl get roles,rolebindings,clusterroles,clusterrolebindings -A -o yaml
+const kubectlOutput = `apiVersion: v1
+items:
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: Role
+  metadata:
+    annotations:
+      owner: payments
+    creationTimestamp: "2026-01-02T03:04:05Z"
+    labels:
+      team: payments
+    name: pod-reader
+    namespace: payments
+    resourceVersion: "1234"
+    uid: 5f0c2b4e-0d7e-4a59-9b0e-3c5d0e7c1a11
+  rules:
+  - apiGroups:
+    - ""
+    resources:
+    - pods
+    - pods/log
+    verbs:
+    - get
+    - list
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: RoleBinding
+  metadata:
+    name: read-pods
+    namespace: payments
+    ownerReferences:
+    - apiVersion: v1
+      kind: ConfigMap
+      name: owner
+      uid: 7a6e
+  roleRef:
+    apiGroup: rbac.authorization.k8s.io
+    kind: Role
+    name: pod-reader
+  subjects:
+  - apiGroup: rbac.authorization.k8s.io
+    kind: User
+    name: alice
+  - kind: ServiceAccount
+    name: deployer
+    namespace: ci
+  - apiGroup: rbac.authorization.k8s.io
+    kind: User
+    name: "*"
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRole
+  metadata:
+    name: monitoring
+  aggregationRule:
+    clusterRoleSelectors:
+    - matchLabels:
+        rbac.example.com/aggregate-to-monitoring: "true"
+  rules:
+  - nonResourceURLs:
+    - /metrics
+    verbs:
+    - get
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRoleBinding
+  metadata:
+    name: monitoring
+  roleRef:
+    apiGroup: rbac.authorization.k8s.io
+    kind: ClusterRole
+    name: monitoring
+  subjects:
+  - apiGroup: rbac.authorization.k8s.io
+    kind: Group
+    name: ops
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRoleBinding
+  metadata:
+    name: foreign
+  roleRef:
+    apiGroup: example.com
+    kind: ClusterRole
+    name: monitoring
+  subjects: []
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: settings
+    namespace: payments
+kind: List
+metadata:
+  resourceVersion: ""
+`
+
+func TestImport(t *testing.T) {
+	res, err := Import(strings.NewReader(kubectlOutput))
+	if err != nil {
+		t.Fatalf("Error importing: %v", err)
+	}
+
+	expected := api.Policy{
+		Roles: []api.Role{{
+			Name:        "pod-reader",
+			Namespace:   "payments",
+			Labels:      map[string]string{"team": "payments"},
+			Annotations: map[string]string{"owner": "payments"},
+			Rules: []api.PolicyRule{{
+				Verbs:     []string{"get", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"pods", "pods/log"},
+			}},
+		}},
+		RoleBindings: []api.RoleBinding{{
+			Name:      "read-pods",
+			Namespace: "payments",
+			Subjects: []api.Subject{
+				{Kind: api.UserKind, Name: "alice"},
+				{Kind: api.ServiceAccountKind, Name: "deployer", Namespace: "ci"},
+			},
+			RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "pod-reader", Namespace: "payments"},
+		}},
+		ClusterRoles: []api.ClusterRole{{
+			Name:  "monitoring",
+			Rules: []api.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}}},
+		}},
+		ClusterRoleBindings: []api.ClusterRoleBinding{{
+			Name:     "monitoring",
+			Subjects: []api.Subject{{Kind: api.GroupKind, Name: "ops"}},
+			RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "monitoring"},
+		}},
+	}
+	if !reflect.DeepEqual(res.Policy, expected) {
+		t.Errorf("Expected policy:\n%+v\ngot:\n%+v", expected, res.Policy)
+	}
+	if err = repository.ValidatePolicy(res.Policy); err != nil {
+		t.Errorf("Expected imported policy to be valid: %v", err)
+	}
+
+	expectedIssues := []string{
+		"RoleBinding 'payments/read-pods': the field 'metadata.ownerReferences' has no equivalent and was dropped",
+		"RoleBinding 'payments/read-pods': the subject User '*' would match every user in this policy; not imported",
+		"ClusterRole 'monitoring': cluster role aggregation is not supported; only the rules were imported",
+		"ClusterRoleBinding 'foreign': the role reference has API group 'example.com', expected 'rbac.authorization.k8s.io'; not imported",
+		"ConfigMap 'payments/settings': the object is not an RBAC object; not imported",
+	}
+	issues := []string{}
+	for _, i := range res.Issues {
+		issues = append(issues, i.String())
+	}
+	if !reflect.DeepEqual(issues, expectedIssues) {
+		t.Errorf("Expected issues:\n%s\ngot:\n%s", strings.Join(expectedIssues, "\n"), strings.Join(issues, "\n"))
+	}
+}
+
+func TestImportObjectStream(t *testing.T) {
+	stream := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: viewer
+rules:
+- apiGroups: ["*"]
+  resources: ["*"]
+  verbs: ["get"]
+  attributeRestrictions: {}
+---
+{"apiVersion": "rbac.authorization.k8s.io/v1beta1", "kind": "ClusterRole", "metadata": {"name": "old"}}
+`
+	res, err := Import(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Error importing: %v", err)
+	}
+	if len(res.Policy.ClusterRoles) != 1 || res.Policy.ClusterRoles[0].Name != "viewer" {
+		t.Errorf("Expected only the viewer cluster role to be imported, got %+v", res.Policy.ClusterRoles)
+	}
+	if len(res.Issues) != 2 {
+		t.Fatalf("Expected 2 issues, got %v", res.Issues)
+	}
+	if !strings.Contains(res.Issues[0].Message, "'rules[0].attributeRestrictions'") {
+		t.Errorf("Expected the unknown rule field to be reported, got %v", res.Issues[0])
+	}
+	if !strings.Contains(res.Issues[1].Message, "API version 'rbac.authorization.k8s.io/v1beta1' is not supported") {
+		t.Errorf("Expected the API version to be reported, got %v", res.Issues[1])
+	}
+}
+
+func TestImportMalformed(t *testing.T) {
+	if _, err := Import(strings.NewReader("kind: List\nitems: 3\n")); err == nil {
+		t.Errorf("Expected an error for items that are not a sequence")
+	}
+	if _, err := Import(strings.NewReader("- a\n- b\n")); err == nil {
+		t.Errorf("Expected an error for a document that is not an object")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	res, err := Import(strings.NewReader(kubectlOutput))
+	if err != nil {
+		t.Fatalf("Error importing: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	issues, err := Export(res.Policy, buf)
+	if err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	if len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+	for _, s := range []string{"apiVersion: v1\n", "kind: List\n", "apiVersion: rbac.authorization.k8s.io/v1\n", "    apiGroup: rbac.authorization.k8s.io\n"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("Expected export to contain %q, got:\n%s", s, buf.String())
+		}
+	}
+
+	again, err := Import(buf)
+	if err != nil {
+		t.Fatalf("Error importing the export: %v", err)
+	}
+	if len(again.Issues) != 0 {
+		t.Errorf("Expected no issues importing the export, got %v", again.Issues)
+	}
+	if !reflect.DeepEqual(again.Policy, res.Policy) {
+		t.Errorf("Expected policy:\n%+v\ngot:\n%+v", res.Policy, again.Policy)
+	}
+}
+
+func TestExportIssues(t *testing.T) {
+	p := api.Policy{
+		RoleBindings: []api.RoleBinding{{
+			Name:      "cross-namespace",
+			Namespace: "a",
+			Subjects:  []api.Subject{{Kind: api.UserKind, Name: "alice"}},
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "reader", Namespace: "b"},
+		}},
+		ClusterRoleBindings: []api.ClusterRoleBinding{{
+			Name:     "everyone",
+			Subjects: []api.Subject{{Kind: api.UserKind, Name: api.UserAll}, {Kind: api.GroupKind, Name: "ops", Namespace: "x"}},
+			RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "viewer"},
+		}},
+	}
+	buf := &bytes.Buffer{}
+	issues, err := Export(p, buf)
+	if err != nil {
+		t.Fatalf("Error exporting: %v", err)
+	}
+	if len(issues) != 3 {
+		t.Fatalf("Expected 3 issues, got %v", issues)
+	}
+	if strings.Contains(buf.String(), "cross-namespace") {
+		t.Errorf("Expected the cross-namespace binding not to be exported, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "'*'") || strings.Contains(buf.String(), "namespace: x") {
+		t.Errorf("Expected the wildcard user and the group namespace not to be exported, got:\n%s", buf.String())
+	}
+}
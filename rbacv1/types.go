@@ -0,0 +1,69 @@
+package rbacv1
+
+const (
+	// GroupName is the API group of the RBAC objects.
+	GroupName = "rbac.authorization.k8s.io"
+	// APIVersion of the RBAC objects.
+	APIVersion = GroupName + "/v1"
+	// ListKind is the kind of the list that holds the exported objects.
+	ListKind = "List"
+	// listAPIVersion is the API version of lists, as printed by kubectl.
+	listAPIVersion = "v1"
+)
+
+// ObjectMeta holds the metadata of an RBAC object that translates to the policy.
+type ObjectMeta struct {
+	Name        string            `json:"name,omitempty" yaml:"name,omitempty"`
+	Namespace   string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// PolicyRule is a rule of a Role or ClusterRole.
+type PolicyRule struct {
+	APIGroups       []string `json:"apiGroups,omitempty" yaml:"apiGroups,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty" yaml:"nonResourceURLs,omitempty"`
+	ResourceNames   []string `json:"resourceNames,omitempty" yaml:"resourceNames,omitempty"`
+	Resources       []string `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Verbs           []string `json:"verbs" yaml:"verbs"`
+}
+
+// Subject of a RoleBinding or ClusterRoleBinding.
+type Subject struct {
+	APIGroup  string `json:"apiGroup,omitempty" yaml:"apiGroup,omitempty"`
+	Kind      string `json:"kind" yaml:"kind"`
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+// RoleRef is the role referenced by a binding. The referenced Role is always in the
+// namespace of the binding.
+type RoleRef struct {
+	APIGroup string `json:"apiGroup" yaml:"apiGroup"`
+	Kind     string `json:"kind" yaml:"kind"`
+	Name     string `json:"name" yaml:"name"`
+}
+
+// Object is a Role, RoleBinding, ClusterRole or ClusterRoleBinding. Roles only use Rules,
+// and bindings only use RoleRef and Subjects.
+type Object struct {
+	APIVersion string       `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string       `json:"kind" yaml:"kind"`
+	Metadata   ObjectMeta   `json:"metadata" yaml:"metadata"`
+	Rules      []PolicyRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+	RoleRef    *RoleRef     `json:"roleRef,omitempty" yaml:"roleRef,omitempty"`
+	Subjects   []Subject    `json:"subjects,omitempty" yaml:"subjects,omitempty"`
+}
+
+// ListMeta is the metadata of a List.
+type ListMeta struct {
+	ResourceVersion string `json:"resourceVersion" yaml:"resourceVersion"`
+}
+
+// List of RBAC objects, in the format printed by kubectl get -o yaml.
+type List struct {
+	APIVersion string   `json:"apiVersion" yaml:"apiVersion"`
+	Items      []Object `json:"items" yaml:"items"`
+	Kind       string   `json:"kind" yaml:"kind"`
+	Metadata   ListMeta `json:"metadata" yaml:"metadata"`
+}
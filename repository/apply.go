@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// ChangeType describes what happens to an object when a policy is applied.
+type ChangeType string
+
+const (
+	// ChangeCreate means that the object does not exist and will be created.
+	ChangeCreate ChangeType = "create"
+	// ChangeUpdate means that the object exists and will be modified.
+	ChangeUpdate ChangeType = "update"
+	// ChangeDelete means that the object is not in the applied policy and will be deleted.
+	ChangeDelete ChangeType = "delete"
+)
+
+// Change is a single object change computed when applying a policy.
+type Change struct {
+	// Type of change.
+	Type ChangeType `json:"type"`
+	// Kind of the changed object, e.g. "Role".
+	Kind string `json:"kind"`
+	// Name of the changed object.
+	Name string `json:"name"`
+	// Namespace of the changed object. Empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Type, c.Kind, ObjectKey(c.Namespace, c.Name))
+}
+
+// ApplyOptions control how a policy is applied.
+type ApplyOptions struct {
+	// DryRun computes the changes without persisting them.
+	DryRun bool
+	// Author of the change, in the "Name <email>" format. Backends that keep a history record it.
+	Author string
+	// Message describing the change. Backends that keep a history record it.
+	Message string
+}
+
+// PlanApply computes the changes that turn the current policy into the desired one, and returns
+// the policy that results from making them. Unchanged objects keep their metadata, updated objects
+// keep their UID and creation timestamp, and created or updated objects get a new resource version
+// from nextResourceVersion. If a desired object specifies a resource version that does not match
+// the current object, a ConflictError is returned.
+func PlanApply(current, desired api.Policy, nextResourceVersion func() string) (api.Policy, []Change, error) {
+	if err := ValidatePolicy(desired); err != nil {
+		return api.Policy{}, nil, err
+	}
+
+	pl := planner{nextResourceVersion: nextResourceVersion}
+	result := api.Policy{
+		Roles:               make([]api.Role, len(desired.Roles)),
+		RoleBindings:        make([]api.RoleBinding, len(desired.RoleBindings)),
+		ClusterRoles:        make([]api.ClusterRole, len(desired.ClusterRoles)),
+		ClusterRoleBindings: make([]api.ClusterRoleBinding, len(desired.ClusterRoleBindings)),
+	}
+
+	roles := map[string]api.Role{}
+	for _, r := range current.Roles {
+		roles[ObjectKey(r.Namespace, r.Name)] = r
+	}
+	for i, r := range desired.Roles {
+		key := ObjectKey(r.Namespace, r.Name)
+		old, ok := roles[key]
+		delete(roles, key)
+		if err := pl.planRole(ok, old, &r); err != nil {
+			return api.Policy{}, nil, err
+		}
+		result.Roles[i] = r
+	}
+	deleted := []Change{}
+	for _, r := range roles {
+		deleted = append(deleted, Change{Type: ChangeDelete, Kind: api.RoleKind, Name: r.Name, Namespace: r.Namespace})
+	}
+	pl.delete(deleted)
+
+	bindings := map[string]api.RoleBinding{}
+	for _, rb := range current.RoleBindings {
+		bindings[ObjectKey(rb.Namespace, rb.Name)] = rb
+	}
+	for i, rb := range desired.RoleBindings {
+		key := ObjectKey(rb.Namespace, rb.Name)
+		old, ok := bindings[key]
+		delete(bindings, key)
+		if err := pl.planRoleBinding(ok, old, &rb); err != nil {
+			return api.Policy{}, nil, err
+		}
+		result.RoleBindings[i] = rb
+	}
+	deleted = []Change{}
+	for _, rb := range bindings {
+		deleted = append(deleted, Change{Type: ChangeDelete, Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace})
+	}
+	pl.delete(deleted)
+
+	clusterRoles := map[string]api.ClusterRole{}
+	for _, cr := range current.ClusterRoles {
+		clusterRoles[cr.Name] = cr
+	}
+	for i, cr := range desired.ClusterRoles {
+		old, ok := clusterRoles[cr.Name]
+		delete(clusterRoles, cr.Name)
+		if err := pl.planClusterRole(ok, old, &cr); err != nil {
+			return api.Policy{}, nil, err
+		}
+		result.ClusterRoles[i] = cr
+	}
+	deleted = []Change{}
+	for name := range clusterRoles {
+		deleted = append(deleted, Change{Type: ChangeDelete, Kind: api.ClusterRoleKind, Name: name})
+	}
+	pl.delete(deleted)
+
+	clusterBindings := map[string]api.ClusterRoleBinding{}
+	for _, crb := range current.ClusterRoleBindings {
+		clusterBindings[crb.Name] = crb
+	}
+	for i, crb := range desired.ClusterRoleBindings {
+		old, ok := clusterBindings[crb.Name]
+		delete(clusterBindings, crb.Name)
+		if err := pl.planClusterRoleBinding(ok, old, &crb); err != nil {
+			return api.Policy{}, nil, err
+		}
+		result.ClusterRoleBindings[i] = crb
+	}
+	deleted = []Change{}
+	for name := range clusterBindings {
+		deleted = append(deleted, Change{Type: ChangeDelete, Kind: api.ClusterRoleBindingKind, Name: name})
+	}
+	pl.delete(deleted)
+
+	return result, pl.changes, nil
+}
+
+type planner struct {
+	nextResourceVersion func() string
+	changes             []Change
+}
+
+// planRole records the change for a desired role, and sets its metadata.
+func (pl *planner) planRole(exists bool, current api.Role, desired *api.Role) error {
+	if !exists {
+		pl.create(api.RoleKind, desired.Name, desired.Namespace, &desired.UID, &desired.CreationTimestamp, &desired.ResourceVersion)
+		return nil
+	}
+	if err := CheckResourceVersion(api.RoleKind, desired.Name, desired.Namespace, current.ResourceVersion, desired.ResourceVersion); err != nil {
+		return err
+	}
+	desired.UID, desired.CreationTimestamp, desired.ResourceVersion = current.UID, current.CreationTimestamp, current.ResourceVersion
+	if !sameRole(current, *desired) {
+		pl.update(api.RoleKind, desired.Name, desired.Namespace, &desired.ResourceVersion)
+	}
+	return nil
+}
+
+// planRoleBinding records the change for a desired role binding, and sets its metadata.
+func (pl *planner) planRoleBinding(exists bool, current api.RoleBinding, desired *api.RoleBinding) error {
+	if !exists {
+		pl.create(api.RoleBindingKind, desired.Name, desired.Namespace, &desired.UID, &desired.CreationTimestamp, &desired.ResourceVersion)
+		return nil
+	}
+	if err := CheckResourceVersion(api.RoleBindingKind, desired.Name, desired.Namespace, current.ResourceVersion, desired.ResourceVersion); err != nil {
+		return err
+	}
+	desired.UID, desired.CreationTimestamp, desired.ResourceVersion = current.UID, current.CreationTimestamp, current.ResourceVersion
+	if !sameRoleBinding(current, *desired) {
+		pl.update(api.RoleBindingKind, desired.Name, desired.Namespace, &desired.ResourceVersion)
+	}
+	return nil
+}
+
+// planClusterRole records the change for a desired cluster role, and sets its metadata.
+func (pl *planner) planClusterRole(exists bool, current api.ClusterRole, desired *api.ClusterRole) error {
+	if !exists {
+		pl.create(api.ClusterRoleKind, desired.Name, "", &desired.UID, &desired.CreationTimestamp, &desired.ResourceVersion)
+		return nil
+	}
+	if err := CheckResourceVersion(api.ClusterRoleKind, desired.Name, "", current.ResourceVersion, desired.ResourceVersion); err != nil {
+		return err
+	}
+	desired.UID, desired.CreationTimestamp, desired.ResourceVersion = current.UID, current.CreationTimestamp, current.ResourceVersion
+	if !sameClusterRole(current, *desired) {
+		pl.update(api.ClusterRoleKind, desired.Name, "", &desired.ResourceVersion)
+	}
+	return nil
+}
+
+// planClusterRoleBinding records the change for a desired cluster role binding, and sets its metadata.
+func (pl *planner) planClusterRoleBinding(exists bool, current api.ClusterRoleBinding, desired *api.ClusterRoleBinding) error {
+	if !exists {
+		pl.create(api.ClusterRoleBindingKind, desired.Name, "", &desired.UID, &desired.CreationTimestamp, &desired.ResourceVersion)
+		return nil
+	}
+	if err := CheckResourceVersion(api.ClusterRoleBindingKind, desired.Name, "", current.ResourceVersion, desired.ResourceVersion); err != nil {
+		return err
+	}
+	desired.UID, desired.CreationTimestamp, desired.ResourceVersion = current.UID, current.CreationTimestamp, current.ResourceVersion
+	if !sameClusterRoleBinding(current, *desired) {
+		pl.update(api.ClusterRoleBindingKind, desired.Name, "", &desired.ResourceVersion)
+	}
+	return nil
+}
+
+// create records the creation of an object, and assigns its metadata.
+func (pl *planner) create(kind, name, namespace string, uid, creationTimestamp, resourceVersion *string) {
+	*uid, *creationTimestamp, *resourceVersion = NewUID(), CreationTimestamp(), pl.nextResourceVersion()
+	pl.changes = append(pl.changes, Change{Type: ChangeCreate, Kind: kind, Name: name, Namespace: namespace})
+}
+
+// update records the update of an object, and assigns its new resource version.
+func (pl *planner) update(kind, name, namespace string, resourceVersion *string) {
+	*resourceVersion = pl.nextResourceVersion()
+	pl.changes = append(pl.changes, Change{Type: ChangeUpdate, Kind: kind, Name: name, Namespace: namespace})
+}
+
+// delete records the deletions of the objects of a kind, sorted by namespace and name.
+func (pl *planner) delete(deleted []Change) {
+	sort.Slice(deleted, func(i, j int) bool {
+		return ObjectKey(deleted[i].Namespace, deleted[i].Name) < ObjectKey(deleted[j].Namespace, deleted[j].Name)
+	})
+	pl.changes = append(pl.changes, deleted...)
+}
+
+// The comparisons ignore the metadata assigned by the repository, and treat nil and empty
+// slices and maps as equal, so that objects that went through a JSON round trip compare equal
+// to the original.
+
+func sameRole(a, b api.Role) bool {
+	return stringMapsEqual(a.Labels, b.Labels) && stringMapsEqual(a.Annotations, b.Annotations) && rulesEqual(a.Rules, b.Rules)
+}
+
+func sameRoleBinding(a, b api.RoleBinding) bool {
+	return stringMapsEqual(a.Labels, b.Labels) && stringMapsEqual(a.Annotations, b.Annotations) && subjectsEqual(a.Subjects, b.Subjects) && a.RoleRef == b.RoleRef
+}
+
+func sameClusterRole(a, b api.ClusterRole) bool {
+	return stringMapsEqual(a.Labels, b.Labels) && stringMapsEqual(a.Annotations, b.Annotations) && rulesEqual(a.Rules, b.Rules)
+}
+
+func sameClusterRoleBinding(a, b api.ClusterRoleBinding) bool {
+	return stringMapsEqual(a.Labels, b.Labels) && stringMapsEqual(a.Annotations, b.Annotations) && subjectsEqual(a.Subjects, b.Subjects) && a.RoleRef == b.RoleRef
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+func rulesEqual(a, b []api.PolicyRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !stringsEqual(a[i].Verbs, b[i].Verbs) || !stringsEqual(a[i].APIGroups, b[i].APIGroups) || !stringsEqual(a[i].Resources, b[i].Resources) ||
+			!stringsEqual(a[i].ResourceNames, b[i].ResourceNames) || !stringsEqual(a[i].NonResourceURLs, b[i].NonResourceURLs) {
+			return false
+		}
+	}
+	return true
+}
+
+func subjectsEqual(a, b []api.Subject) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
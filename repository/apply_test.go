@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+func versionCounter() func() string {
+	v := 100
+	return func() string {
+		v++
+		return strconv.Itoa(v)
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	current := api.Policy{
+		Roles: []api.Role{
+			{Name: "admin", Namespace: "ns1", UID: "uid-admin", ResourceVersion: "1", Rules: []api.PolicyRule{{Verbs: []string{"*"}}}},
+			{Name: "view", Namespace: "ns1", UID: "uid-view", ResourceVersion: "2", Rules: []api.PolicyRule{{Verbs: []string{"get"}}}},
+		},
+		RoleBindings: []api.RoleBinding{
+			{Name: "admins", Namespace: "ns1", UID: "uid-admins", ResourceVersion: "3", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin"}},
+			{Name: "old", Namespace: "ns1", UID: "uid-old", ResourceVersion: "4", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin"}},
+		},
+	}
+	desired := api.Policy{
+		Roles: []api.Role{
+			// Unchanged, apart from an empty slice that was nil
+			{Name: "admin", Namespace: "ns1", Rules: []api.PolicyRule{{Verbs: []string{"*"}, ResourceNames: []string{}}}},
+			{Name: "view", Namespace: "ns1", Rules: []api.PolicyRule{{Verbs: []string{"get", "list"}}}},
+			{Name: "edit", Namespace: "ns1"},
+		},
+		RoleBindings: []api.RoleBinding{
+			{Name: "admins", Namespace: "ns1", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin"}},
+		},
+	}
+
+	result, changes, err := PlanApply(current, desired, versionCounter())
+	if err != nil {
+		t.Fatalf("Error planning apply: %v", err)
+	}
+
+	expected := []Change{
+		{Type: ChangeUpdate, Kind: api.RoleKind, Name: "view", Namespace: "ns1"},
+		{Type: ChangeCreate, Kind: api.RoleKind, Name: "edit", Namespace: "ns1"},
+		{Type: ChangeDelete, Kind: api.RoleBindingKind, Name: "old", Namespace: "ns1"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+
+	if len(result.Roles) != 3 || len(result.RoleBindings) != 1 {
+		t.Fatalf("Unexpected resulting policy: %+v", result)
+	}
+	if admin := result.Roles[0]; admin.UID != "uid-admin" || admin.ResourceVersion != "1" {
+		t.Errorf("Expected unchanged role to keep its metadata, got %+v", admin)
+	}
+	if view := result.Roles[1]; view.UID != "uid-view" || view.ResourceVersion == "2" {
+		t.Errorf("Expected updated role to keep its UID and get a new resource version, got %+v", view)
+	}
+	if edit := result.Roles[2]; edit.UID == "" || edit.ResourceVersion == "" || edit.CreationTimestamp == "" {
+		t.Errorf("Expected created role to be assigned metadata, got %+v", edit)
+	}
+}
+
+func TestPlanApplyConflict(t *testing.T) {
+	current := api.Policy{
+		ClusterRoles: []api.ClusterRole{{Name: "view", ResourceVersion: "5"}},
+	}
+	desired := api.Policy{
+		ClusterRoles: []api.ClusterRole{{Name: "view", ResourceVersion: "4", Rules: []api.PolicyRule{{Verbs: []string{"get"}}}}},
+	}
+	if _, _, err := PlanApply(current, desired, versionCounter()); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict error when applying stale object, got %v", err)
+	}
+}
+
+func TestPlanApplyInvalid(t *testing.T) {
+	desired := api.Policy{
+		Roles: []api.Role{{Name: "admin", Namespace: "ns1"}, {Name: "admin", Namespace: "ns1"}},
+	}
+	if _, _, err := PlanApply(api.Policy{}, desired, versionCounter()); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected invalid error when applying duplicate objects, got %v", err)
+	}
+}
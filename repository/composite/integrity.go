@@ -0,0 +1,143 @@
+package composite
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.CascadeRepository = &Repository{}
+
+// DeleteRoleCascade deletes the role from the write layer, along with the role bindings of the
+// write layer that reference it. Bindings of other layers that still reference the role make
+// the deletion fail, unless a lower layer defines the role too.
+func (r *Repository) DeleteRoleCascade(name, namespace, resourceVersion string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.GetRole(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if layer := current.Annotations[LayerAnnotation]; layer != r.write.Name {
+		return nil, r.readOnlyError(api.RoleKind, name, namespace, layer)
+	}
+	if err = repository.CheckResourceVersion(api.RoleKind, name, namespace, current.ResourceVersion, resourceVersion); err != nil {
+		return nil, err
+	}
+
+	p, err := repository.ReadPolicy(r.write.Repo)
+	if err != nil {
+		return nil, r.layerError(r.write, err)
+	}
+	if opts.Message == "" {
+		opts.Message = fmt.Sprintf("Delete role %s and its role bindings", repository.ObjectKey(namespace, name))
+	}
+	return r.apply(repository.CascadePolicy(*p, name, namespace), opts)
+}
+
+// PurgeNamespace deletes all the roles and role bindings of the namespace from the write
+// layer. It fails if other layers define objects in the namespace, as they cannot be deleted.
+func (r *Repository) PurgeNamespace(namespace string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, l := range r.Layers {
+		if l.Name == r.write.Name {
+			continue
+		}
+		roles, err := l.Repo.ListRoles(namespace, repository.ListOptions{Limit: 1})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		if len(roles.Items) > 0 {
+			return nil, r.readOnlyError(api.RoleKind, roles.Items[0].Name, namespace, l.Name)
+		}
+		bindings, err := l.Repo.ListRoleBindings(namespace, repository.ListOptions{Limit: 1})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		if len(bindings.Items) > 0 {
+			return nil, r.readOnlyError(api.RoleBindingKind, bindings.Items[0].Name, namespace, l.Name)
+		}
+	}
+
+	p, err := repository.ReadPolicy(r.write.Repo)
+	if err != nil {
+		return nil, r.layerError(r.write, err)
+	}
+	if opts.Message == "" {
+		opts.Message = fmt.Sprintf("Purge namespace %s", namespace)
+	}
+	return r.apply(repository.PurgeNamespacePolicy(*p, namespace), opts)
+}
+
+// checkUnreferenced returns a ReferencedError if deleting the role of the write layer would
+// leave bindings of the merged policy without a role. Deleting an override of a role that a
+// lower layer defines is always possible.
+func (r *Repository) checkUnreferenced(name, namespace string) error {
+	for _, l := range r.Layers {
+		if l.Name == r.write.Name {
+			break
+		}
+		_, err := l.Repo.GetRole(name, namespace)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return r.layerError(l, err)
+		}
+	}
+	p, err := repository.ReadPolicy(r)
+	if err != nil {
+		return err
+	}
+	return repository.CheckUnreferenced(*p, api.ObjectReference{Kind: api.RoleKind, Name: name, Namespace: namespace})
+}
+
+// mergeWith returns the merged policy of the layers, with the policy of the write layer
+// replaced by the given one.
+func (r *Repository) mergeWith(p api.Policy) (api.Policy, error) {
+	roles := map[string]api.Role{}
+	bindings := map[string]api.RoleBinding{}
+	clusterRoles := map[string]api.ClusterRole{}
+	clusterBindings := map[string]api.ClusterRoleBinding{}
+	for _, l := range r.Layers {
+		lp := &p
+		if l.Name != r.write.Name {
+			var err error
+			if lp, err = repository.ReadPolicy(l.Repo); err != nil {
+				return api.Policy{}, r.layerError(l, err)
+			}
+		}
+		for _, o := range lp.Roles {
+			roles[repository.ObjectKey(o.Namespace, o.Name)] = o
+		}
+		for _, o := range lp.RoleBindings {
+			bindings[repository.ObjectKey(o.Namespace, o.Name)] = o
+		}
+		for _, o := range lp.ClusterRoles {
+			clusterRoles[o.Name] = o
+		}
+		for _, o := range lp.ClusterRoleBindings {
+			clusterBindings[o.Name] = o
+		}
+	}
+
+	merged := api.Policy{}
+	for _, o := range roles {
+		merged.Roles = append(merged.Roles, o)
+	}
+	for _, o := range bindings {
+		merged.RoleBindings = append(merged.RoleBindings, o)
+	}
+	for _, o := range clusterRoles {
+		merged.ClusterRoles = append(merged.ClusterRoles, o)
+	}
+	for _, o := range clusterBindings {
+		merged.ClusterRoleBindings = append(merged.ClusterRoleBindings, o)
+	}
+	return merged, nil
+}
@@ -0,0 +1,530 @@
+// Package composite implements a policy repository that merges the policy of several layers.
+//
+// Layers are ordered by precedence. When more than one layer defines the same object, the
+// object of the layer with the highest precedence is used. Writes are sent to a single
+// designated layer, which can override the objects of the layers below it but cannot delete
+// them. The objects of the layers above it are read-only, as an override would not take effect.
+//
+// Bindings commonly reference roles of other layers, so referential integrity is enforced on
+// the merged policy. The layers themselves should allow dangling references.
+package composite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// LayerAnnotation is the annotation set on the objects returned by the repository to record
+// the name of the layer they come from. It is not persisted.
+const LayerAnnotation = "kubernetes-rbac/layer"
+
+// Layer is a named source of policy.
+type Layer struct {
+	// Name of the layer, e.g. "base" or "override".
+	Name string
+	// Repo holding the policy of the layer.
+	Repo repository.PolicyRepository
+}
+
+// Repository merges the policy of its layers.
+type Repository struct {
+	// Layers ordered from the lowest to the highest precedence.
+	Layers []Layer
+	// WatchInterval is how often Watch checks the layers for changes. Defaults to
+	// repository.DefaultWatchInterval.
+	WatchInterval time.Duration
+	// write is the layer that receives writes.
+	write Layer
+	// mu serializes the writes, which check the merged policy before writing to the write layer.
+	mu sync.Mutex
+}
+
+// Conflict describes an object that is defined by more than one layer.
+type Conflict struct {
+	// Kind of the object, e.g. "Role".
+	Kind string `json:"kind"`
+	// Name of the object.
+	Name string `json:"name"`
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	// Layers that define the object, from the lowest to the highest precedence. The object
+	// of the last layer is the one in effect.
+	Layers []string `json:"layers"`
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s is defined by layers %v, using %s", c.Kind, repository.ObjectKey(c.Namespace, c.Name), c.Layers, c.Layers[len(c.Layers)-1])
+}
+
+// Create returns a Repository with the given layers, ordered from the lowest to the highest
+// precedence. Writes are sent to the layer named writeLayer.
+func Create(writeLayer string, layers ...Layer) (*Repository, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("At least one layer is required")
+	}
+	r := &Repository{Layers: layers}
+	seen := map[string]bool{}
+	for _, l := range layers {
+		if seen[l.Name] {
+			return nil, fmt.Errorf("Layer '%s' is defined more than once", l.Name)
+		}
+		seen[l.Name] = true
+		if l.Name == writeLayer {
+			r.write = l
+		}
+	}
+	if r.write.Repo == nil {
+		return nil, fmt.Errorf("Write layer '%s' is not one of the layers", writeLayer)
+	}
+	return r, nil
+}
+
+// GetRole from the layer with the highest precedence that defines it.
+func (r *Repository) GetRole(name, namespace string) (*api.Role, error) {
+	for i := len(r.Layers) - 1; i >= 0; i-- {
+		role, err := r.Layers[i].Repo.GetRole(name, namespace)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, r.layerError(r.Layers[i], err)
+		}
+		role.Annotations = annotate(role.Annotations, r.Layers[i].Name)
+		return role, nil
+	}
+	return nil, &repository.NotFoundError{Kind: api.RoleKind, Name: name, Namespace: namespace}
+}
+
+// CreateRole in the write layer. Roles defined by any layer cannot be created again.
+func (r *Repository) CreateRole(role api.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.GetRole(role.Name, role.Namespace); err == nil {
+		return &repository.AlreadyExistsError{Kind: api.RoleKind, Name: role.Name, Namespace: role.Namespace}
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	role.Annotations = unannotate(role.Annotations)
+	return r.write.Repo.CreateRole(role)
+}
+
+// UpdateRole in the write layer. Updating a role of a layer below the write layer overrides it in
+// the write layer. The roles of the layers above it cannot be updated.
+func (r *Repository) UpdateRole(role api.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.GetRole(role.Name, role.Namespace)
+	if err != nil {
+		return err
+	}
+	layer := current.Annotations[LayerAnnotation]
+	if r.above(layer) {
+		return r.readOnlyError(api.RoleKind, role.Name, role.Namespace, layer)
+	}
+	role.Annotations = unannotate(role.Annotations)
+	if layer == r.write.Name {
+		return r.write.Repo.UpdateRole(role)
+	}
+	if err = repository.CheckResourceVersion(api.RoleKind, role.Name, role.Namespace, current.ResourceVersion, role.ResourceVersion); err != nil {
+		return err
+	}
+	role.ResourceVersion = ""
+	return r.write.Repo.CreateRole(role)
+}
+
+// DeleteRole from the write layer. Deleting an override reveals the role of the layer below it.
+func (r *Repository) DeleteRole(name, namespace, resourceVersion string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.GetRole(name, namespace)
+	if err != nil {
+		return err
+	}
+	if layer := current.Annotations[LayerAnnotation]; layer != r.write.Name {
+		return r.readOnlyError(api.RoleKind, name, namespace, layer)
+	}
+	if err = r.checkUnreferenced(name, namespace); err != nil {
+		return err
+	}
+	return r.write.Repo.DeleteRole(name, namespace, resourceVersion)
+}
+
+// ListRoles of all the layers.
+func (r *Repository) ListRoles(namespace string, opts repository.ListOptions) (*api.RoleList, error) {
+	sel, err := repository.ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]api.Role{}
+	for _, l := range r.Layers {
+		list, err := l.Repo.ListRoles(namespace, repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, role := range list.Items {
+			role.Annotations = annotate(role.Annotations, l.Name)
+			merged[repository.ObjectKey(role.Namespace, role.Name)] = role
+		}
+	}
+
+	roles := []api.Role{}
+	for _, o := range merged {
+		if sel.Matches(o.Labels) {
+			roles = append(roles, o)
+		}
+	}
+	key := func(i int) string { return repository.ObjectKey(roles[i].Namespace, roles[i].Name) }
+	sort.Slice(roles, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := repository.Page(len(roles), key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RoleList{Items: roles[start:end], Continue: next}, nil
+}
+
+// GetRoleBinding from the layer with the highest precedence that defines it.
+func (r *Repository) GetRoleBinding(name, namespace string) (*api.RoleBinding, error) {
+	for i := len(r.Layers) - 1; i >= 0; i-- {
+		rb, err := r.Layers[i].Repo.GetRoleBinding(name, namespace)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, r.layerError(r.Layers[i], err)
+		}
+		rb.Annotations = annotate(rb.Annotations, r.Layers[i].Name)
+		return rb, nil
+	}
+	return nil, &repository.NotFoundError{Kind: api.RoleBindingKind, Name: name, Namespace: namespace}
+}
+
+// CreateRoleBinding in the write layer. Role bindings defined by any layer cannot be created again.
+func (r *Repository) CreateRoleBinding(rb api.RoleBinding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.GetRoleBinding(rb.Name, rb.Namespace); err == nil {
+		return &repository.AlreadyExistsError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace}
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if err := repository.ValidateRoleRef(r, api.RoleBindingKind, rb.Name, rb.Namespace, rb.RoleRef); err != nil {
+		return err
+	}
+	rb.Annotations = unannotate(rb.Annotations)
+	return r.write.Repo.CreateRoleBinding(rb)
+}
+
+// UpdateRoleBinding in the write layer. Updating a role binding of a layer below the write layer
+// overrides it in the write layer. The role bindings of the layers above it cannot be updated.
+func (r *Repository) UpdateRoleBinding(rb api.RoleBinding) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.GetRoleBinding(rb.Name, rb.Namespace)
+	if err != nil {
+		return err
+	}
+	layer := current.Annotations[LayerAnnotation]
+	if r.above(layer) {
+		return r.readOnlyError(api.RoleBindingKind, rb.Name, rb.Namespace, layer)
+	}
+	if err = repository.ValidateRoleRef(r, api.RoleBindingKind, rb.Name, rb.Namespace, rb.RoleRef); err != nil {
+		return err
+	}
+	rb.Annotations = unannotate(rb.Annotations)
+	if layer == r.write.Name {
+		return r.write.Repo.UpdateRoleBinding(rb)
+	}
+	if err = repository.CheckResourceVersion(api.RoleBindingKind, rb.Name, rb.Namespace, current.ResourceVersion, rb.ResourceVersion); err != nil {
+		return err
+	}
+	rb.ResourceVersion = ""
+	return r.write.Repo.CreateRoleBinding(rb)
+}
+
+// DeleteRoleBinding from the write layer. Deleting an override reveals the role binding of the
+// layer below it.
+func (r *Repository) DeleteRoleBinding(name, namespace, resourceVersion string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.GetRoleBinding(name, namespace)
+	if err != nil {
+		return err
+	}
+	if layer := current.Annotations[LayerAnnotation]; layer != r.write.Name {
+		return r.readOnlyError(api.RoleBindingKind, name, namespace, layer)
+	}
+	return r.write.Repo.DeleteRoleBinding(name, namespace, resourceVersion)
+}
+
+// ListRoleBindings of all the layers.
+func (r *Repository) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
+	sel, err := repository.ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]api.RoleBinding{}
+	for _, l := range r.Layers {
+		list, err := l.Repo.ListRoleBindings(namespace, repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, rb := range list.Items {
+			rb.Annotations = annotate(rb.Annotations, l.Name)
+			merged[repository.ObjectKey(rb.Namespace, rb.Name)] = rb
+		}
+	}
+
+	bindings := []api.RoleBinding{}
+	for _, o := range merged {
+		if sel.Matches(o.Labels) {
+			bindings = append(bindings, o)
+		}
+	}
+	key := func(i int) string { return repository.ObjectKey(bindings[i].Namespace, bindings[i].Name) }
+	sort.Slice(bindings, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := repository.Page(len(bindings), key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &api.RoleBindingList{Items: bindings[start:end], Continue: next}, nil
+}
+
+// GetClusterRole from the layer with the highest precedence that defines it.
+func (r *Repository) GetClusterRole(name string) (*api.ClusterRole, error) {
+	for i := len(r.Layers) - 1; i >= 0; i-- {
+		cr, err := r.Layers[i].Repo.GetClusterRole(name)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, r.layerError(r.Layers[i], err)
+		}
+		cr.Annotations = annotate(cr.Annotations, r.Layers[i].Name)
+		return cr, nil
+	}
+	return nil, &repository.NotFoundError{Kind: api.ClusterRoleKind, Name: name}
+}
+
+// ListClusterRoles of all the layers.
+func (r *Repository) ListClusterRoles(opts repository.ListOptions) (*api.ClusterRoleList, error) {
+	sel, err := repository.ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]api.ClusterRole{}
+	for _, l := range r.Layers {
+		list, err := l.Repo.ListClusterRoles(repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, cr := range list.Items {
+			cr.Annotations = annotate(cr.Annotations, l.Name)
+			merged[cr.Name] = cr
+		}
+	}
+
+	roles := []api.ClusterRole{}
+	for _, o := range merged {
+		if sel.Matches(o.Labels) {
+			roles = append(roles, o)
+		}
+	}
+	key := func(i int) string { return roles[i].Name }
+	sort.Slice(roles, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := repository.Page(len(roles), key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ClusterRoleList{Items: roles[start:end], Continue: next}, nil
+}
+
+// ListClusterRoleBindings of all the layers.
+func (r *Repository) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	sel, err := repository.ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]api.ClusterRoleBinding{}
+	for _, l := range r.Layers {
+		list, err := l.Repo.ListClusterRoleBindings(repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, crb := range list.Items {
+			crb.Annotations = annotate(crb.Annotations, l.Name)
+			merged[crb.Name] = crb
+		}
+	}
+
+	bindings := []api.ClusterRoleBinding{}
+	for _, o := range merged {
+		if sel.Matches(o.Labels) {
+			bindings = append(bindings, o)
+		}
+	}
+	key := func(i int) string { return bindings[i].Name }
+	sort.Slice(bindings, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := repository.Page(len(bindings), key, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &api.ClusterRoleBindingList{Items: bindings[start:end], Continue: next}, nil
+}
+
+// Apply replaces the policy of the write layer with the given one. The other layers are not
+// modified. The bindings of the resulting merged policy must reference roles that exist.
+func (r *Repository) Apply(desired api.Policy, opts repository.ApplyOptions) ([]repository.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(desired, opts)
+}
+
+// apply is Apply, called with the lock held.
+func (r *Repository) apply(desired api.Policy, opts repository.ApplyOptions) ([]repository.Change, error) {
+	p := api.Policy{}
+	for _, role := range desired.Roles {
+		role.Annotations = unannotate(role.Annotations)
+		p.Roles = append(p.Roles, role)
+	}
+	for _, rb := range desired.RoleBindings {
+		rb.Annotations = unannotate(rb.Annotations)
+		p.RoleBindings = append(p.RoleBindings, rb)
+	}
+	for _, cr := range desired.ClusterRoles {
+		cr.Annotations = unannotate(cr.Annotations)
+		p.ClusterRoles = append(p.ClusterRoles, cr)
+	}
+	for _, crb := range desired.ClusterRoleBindings {
+		crb.Annotations = unannotate(crb.Annotations)
+		p.ClusterRoleBindings = append(p.ClusterRoleBindings, crb)
+	}
+	merged, err := r.mergeWith(p)
+	if err != nil {
+		return nil, err
+	}
+	if err = repository.ValidateReferences(merged); err != nil {
+		return nil, err
+	}
+	return r.write.Repo.Apply(p, opts)
+}
+
+// Conflicts returns the objects that are defined by more than one layer.
+func (r *Repository) Conflicts() ([]Conflict, error) {
+	type object struct{ kind, name, namespace string }
+	layers := map[object][]string{}
+
+	for _, l := range r.Layers {
+		roles, err := l.Repo.ListRoles(api.NamespaceAll, repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, role := range roles.Items {
+			o := object{api.RoleKind, role.Name, role.Namespace}
+			layers[o] = append(layers[o], l.Name)
+		}
+		bindings, err := l.Repo.ListRoleBindings(api.NamespaceAll, repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, rb := range bindings.Items {
+			o := object{api.RoleBindingKind, rb.Name, rb.Namespace}
+			layers[o] = append(layers[o], l.Name)
+		}
+		clusterRoles, err := l.Repo.ListClusterRoles(repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, cr := range clusterRoles.Items {
+			o := object{api.ClusterRoleKind, cr.Name, ""}
+			layers[o] = append(layers[o], l.Name)
+		}
+		clusterBindings, err := l.Repo.ListClusterRoleBindings(repository.ListOptions{})
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		for _, crb := range clusterBindings.Items {
+			o := object{api.ClusterRoleBindingKind, crb.Name, ""}
+			layers[o] = append(layers[o], l.Name)
+		}
+	}
+
+	conflicts := []Conflict{}
+	for o, ls := range layers {
+		if len(ls) > 1 {
+			conflicts = append(conflicts, Conflict{Kind: o.kind, Name: o.name, Namespace: o.namespace, Layers: ls})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Kind != conflicts[j].Kind {
+			return conflicts[i].Kind < conflicts[j].Kind
+		}
+		return repository.ObjectKey(conflicts[i].Namespace, conflicts[i].Name) < repository.ObjectKey(conflicts[j].Namespace, conflicts[j].Name)
+	})
+	return conflicts, nil
+}
+
+func (r *Repository) layerError(l Layer, err error) error {
+	return fmt.Errorf("Error reading layer '%s': %w", l.Name, err)
+}
+
+// above returns whether the named layer has a higher precedence than the write layer.
+func (r *Repository) above(layer string) bool {
+	for i := len(r.Layers) - 1; i >= 0; i-- {
+		switch r.Layers[i].Name {
+		case r.write.Name:
+			return false
+		case layer:
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Repository) readOnlyError(kind, name, namespace, layer string) error {
+	return &repository.InvalidError{Kind: kind, Name: name, Namespace: namespace, Reason: fmt.Sprintf("defined by layer '%s', which is read-only", layer)}
+}
+
+// annotate returns a copy of the annotations with the layer annotation set.
+func annotate(annotations map[string]string, layer string) map[string]string {
+	a := map[string]string{}
+	for k, v := range annotations {
+		a[k] = v
+	}
+	a[LayerAnnotation] = layer
+	return a
+}
+
+// unannotate returns a copy of the annotations without the layer annotation, so that
+// it is not persisted.
+func unannotate(annotations map[string]string) map[string]string {
+	if _, ok := annotations[LayerAnnotation]; !ok {
+		return annotations
+	}
+	a := map[string]string{}
+	for k, v := range annotations {
+		if k != LayerAnnotation {
+			a[k] = v
+		}
+	}
+	if len(a) == 0 {
+		return nil
+	}
+	return a
+}
@@ -0,0 +1,247 @@
+package composite
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func newLayer(t *testing.T, name string, roles ...api.Role) Layer {
+	doc := &repository.Document{AllowDanglingReferences: true}
+	for _, r := range roles {
+		if err := doc.CreateRole(r); err != nil {
+			t.Fatalf("Error creating role in layer %s: %v", name, err)
+		}
+	}
+	return Layer{Name: name, Repo: doc}
+}
+
+func role(name, verb string) api.Role {
+	return api.Role{Name: name, Namespace: "ns", Rules: []api.PolicyRule{{Verbs: []string{verb}}}}
+}
+
+func TestPrecedence(t *testing.T) {
+	base := newLayer(t, "base", role("admin", "base"), role("view", "base"))
+	cluster := newLayer(t, "cluster", role("admin", "cluster"), role("edit", "cluster"))
+	override := newLayer(t, "override", role("admin", "override"))
+
+	repo, err := Create("cluster", base, cluster, override)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		layer string
+	}{
+		{"admin", "override"},
+		{"view", "base"},
+		{"edit", "cluster"},
+	}
+	for _, c := range cases {
+		r, err := repo.GetRole(c.name, "ns")
+		if err != nil {
+			t.Errorf("Error getting role %s: %v", c.name, err)
+			continue
+		}
+		if r.Rules[0].Verbs[0] != c.layer || r.Annotations[LayerAnnotation] != c.layer {
+			t.Errorf("Expected role %s from layer %s, got %+v", c.name, c.layer, r)
+		}
+	}
+
+	l, err := repo.ListRoles("ns", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing roles: %v", err)
+	}
+	got := map[string]string{}
+	for _, r := range l.Items {
+		got[r.Name] = r.Annotations[LayerAnnotation]
+	}
+	expected := map[string]string{"admin": "override", "view": "base", "edit": "cluster"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected listed roles from layers %v, got %v", expected, got)
+	}
+
+	conflicts, err := repo.Conflicts()
+	if err != nil {
+		t.Fatalf("Error getting conflicts: %v", err)
+	}
+	expectedConflicts := []Conflict{{Kind: api.RoleKind, Name: "admin", Namespace: "ns", Layers: []string{"base", "cluster", "override"}}}
+	if !reflect.DeepEqual(conflicts, expectedConflicts) {
+		t.Errorf("Expected conflicts %v, got %v", expectedConflicts, conflicts)
+	}
+}
+
+func TestWritesGoToWriteLayer(t *testing.T) {
+	base := newLayer(t, "base", role("view", "base"))
+	cluster := newLayer(t, "cluster")
+	repo, err := Create("cluster", base, cluster)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	if err = repo.CreateRole(role("view", "cluster")); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Errorf("Expected already exists error creating role defined by base layer, got %v", err)
+	}
+	if err = repo.DeleteRole("view", "ns", ""); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error deleting role of read-only layer, got %v", err)
+	}
+
+	// Updating the base role overrides it in the write layer
+	view, err := repo.GetRole("view", "ns")
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	view.Rules = []api.PolicyRule{{Verbs: []string{"cluster"}}}
+	if err = repo.UpdateRole(*view); err != nil {
+		t.Fatalf("Error updating role: %v", err)
+	}
+	stored, err := cluster.Repo.GetRole("view", "ns")
+	if err != nil {
+		t.Fatalf("Expected override in write layer: %v", err)
+	}
+	if _, ok := stored.Annotations[LayerAnnotation]; ok {
+		t.Errorf("Expected layer annotation not to be persisted")
+	}
+
+	// Deleting the override reveals the base role
+	if err = repo.DeleteRole("view", "ns", ""); err != nil {
+		t.Fatalf("Error deleting override: %v", err)
+	}
+	view, err = repo.GetRole("view", "ns")
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	if view.Annotations[LayerAnnotation] != "base" {
+		t.Errorf("Expected base role after deleting override, got %+v", view)
+	}
+}
+
+func TestWritesBelowHigherLayers(t *testing.T) {
+	base := newLayer(t, "base", role("view", "base"), role("admin", "base"))
+	override := newLayer(t, "override", role("admin", "override"))
+	repo, err := Create("base", base, override)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	// The role of the override layer shadows the role of the write layer
+	admin, err := repo.GetRole("admin", "ns")
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	admin.Rules = []api.PolicyRule{{Verbs: []string{"base"}}}
+	if err = repo.UpdateRole(*admin); !errors.Is(err, repository.ErrInvalid) || !strings.Contains(err.Error(), "override") {
+		t.Errorf("Expected invalid error naming the override layer updating its role, got %v", err)
+	}
+	if admin, err = repo.GetRole("admin", "ns"); err != nil || admin.Rules[0].Verbs[0] != "override" {
+		t.Errorf("Expected the role of the override layer, got %+v, %v", admin, err)
+	}
+
+	rb := api.RoleBinding{Name: "admins", Namespace: "ns", Subjects: []api.Subject{{Kind: api.UserKind, Name: "alice"}}, RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin", Namespace: "ns"}}
+	if err = override.Repo.CreateRoleBinding(rb); err != nil {
+		t.Fatal(err)
+	}
+	rb.Subjects = nil
+	if err = repo.UpdateRoleBinding(rb); !errors.Is(err, repository.ErrInvalid) || !strings.Contains(err.Error(), "override") {
+		t.Errorf("Expected invalid error naming the override layer updating its role binding, got %v", err)
+	}
+
+	// Objects that are not shadowed are written as usual
+	view, err := repo.GetRole("view", "ns")
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	view.Rules = []api.PolicyRule{{Verbs: []string{"updated"}}}
+	if err = repo.UpdateRole(*view); err != nil {
+		t.Errorf("Error updating role of the write layer: %v", err)
+	}
+}
+
+func TestCreateWithUnknownWriteLayer(t *testing.T) {
+	if _, err := Create("other", newLayer(t, "base")); err == nil {
+		t.Errorf("Expected error when the write layer is not one of the layers")
+	}
+}
+
+func TestIntegrityAcrossLayers(t *testing.T) {
+	base := newLayer(t, "base", role("view", "base"))
+	cluster := newLayer(t, "cluster", role("admin", "cluster"))
+	// The base binding references a role that only the cluster layer defines
+	admins := api.RoleBinding{Name: "admins", Namespace: "ns", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin", Namespace: "ns"}}
+	if err := base.Repo.CreateRoleBinding(admins); err != nil {
+		t.Fatalf("Error creating role binding in base layer: %v", err)
+	}
+	repo, err := Create("cluster", base, cluster)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	viewers := api.RoleBinding{Name: "viewers", Namespace: "ns", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "view", Namespace: "ns"}}
+	if err = repo.CreateRoleBinding(viewers); err != nil {
+		t.Errorf("Expected binding to a role of another layer to be allowed: %v", err)
+	}
+	missing := api.RoleBinding{Name: "editors", Namespace: "ns", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "edit", Namespace: "ns"}}
+	if err = repo.CreateRoleBinding(missing); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error creating binding to a missing role, got %v", err)
+	}
+
+	if err = repo.DeleteRole("admin", "ns", ""); !errors.Is(err, repository.ErrReferenced) {
+		t.Errorf("Expected referenced error deleting a role used by another layer, got %v", err)
+	}
+	if _, err = repo.DeleteRoleCascade("admin", "ns", "", repository.ApplyOptions{}); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error when the cascade reaches a read-only layer, got %v", err)
+	}
+	if _, err = repo.PurgeNamespace("ns", repository.ApplyOptions{}); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error purging a namespace with objects in a read-only layer, got %v", err)
+	}
+
+	// Overrides of a role defined by a lower layer can always be deleted
+	if err = repo.UpdateRole(role("view", "cluster")); err != nil {
+		t.Fatalf("Error overriding role: %v", err)
+	}
+	if err = repo.DeleteRole("view", "ns", ""); err != nil {
+		t.Errorf("Expected deleting an override in use to be allowed: %v", err)
+	}
+}
+
+func TestConcurrentWritesKeepIntegrity(t *testing.T) {
+	repo, err := Create("cluster", newLayer(t, "base"), newLayer(t, "cluster"))
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	admins := api.RoleBinding{Name: "admins", Namespace: "ns", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin", Namespace: "ns"}}
+	for i := 0; i < 100; i++ {
+		if err = repo.CreateRole(role("admin", "cluster")); err != nil {
+			t.Fatalf("Error creating role: %v", err)
+		}
+		// Either the role is deleted before the binding is created, or the binding is
+		// created and prevents the deletion
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			repo.DeleteRole("admin", "ns", "")
+		}()
+		go func() {
+			defer wg.Done()
+			repo.CreateRoleBinding(admins)
+		}()
+		wg.Wait()
+
+		_, roleErr := repo.GetRole("admin", "ns")
+		_, bindingErr := repo.GetRoleBinding("admins", "ns")
+		if roleErr != nil && bindingErr == nil {
+			t.Fatalf("Expected no binding to a deleted role, got a binding after %d iterations", i)
+		}
+		if bindingErr == nil {
+			repo.DeleteRoleBinding("admins", "ns", "")
+		}
+		repo.DeleteRole("admin", "ns", "")
+	}
+}
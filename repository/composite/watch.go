@@ -0,0 +1,74 @@
+package composite
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.WatchRepository = &Repository{}
+var _ repository.SnapshotRepository = &Repository{}
+var _ repository.RevisionRepository = &Repository{}
+
+// Snapshot returns the merged policy of a snapshot of every layer. Its revision is the
+// content hash of the merged policy.
+func (r *Repository) Snapshot() (*repository.Snapshot, error) {
+	layers := make([]Layer, len(r.Layers))
+	for i, l := range r.Layers {
+		snap, err := repository.ReadSnapshot(l.Repo)
+		if err != nil {
+			return nil, r.layerError(l, err)
+		}
+		layers[i] = Layer{Name: l.Name, Repo: &repository.Document{Policy: snap.Policy, AllowDanglingReferences: true}}
+	}
+	// Merge the snapshots as the layers are merged, without going back to the layers
+	frozen := &Repository{Layers: layers}
+	p, err := repository.ReadPolicy(frozen)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := repository.ContentHash(*p)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.Snapshot{Policy: *p, Revision: hash}, nil
+}
+
+// CurrentRevision returns the revisions of the layers, which change whenever one of them does.
+// Unlike the revision of a Snapshot, it does not require reading and merging the layers.
+func (r *Repository) CurrentRevision() (string, error) {
+	revisions := make([]string, len(r.Layers))
+	for i, l := range r.Layers {
+		rev, err := repository.ReadRevision(l.Repo)
+		if err != nil {
+			return "", r.layerError(l, err)
+		}
+		revisions[i] = l.Name + "=" + rev
+	}
+	return strings.Join(revisions, ","), nil
+}
+
+// Watch the merged policy of the layers for changes. The layers are read every WatchInterval,
+// so that changes to any layer, including those that do not go through the repository, are
+// observed. Layers do not share revisions, so the revision of the events is the content hash
+// of the merged policy.
+func (r *Repository) Watch(ctx context.Context) (<-chan repository.Event, error) {
+	interval := r.WatchInterval
+	if interval <= 0 {
+		interval = repository.DefaultWatchInterval
+	}
+
+	last := ""
+	return repository.PollWatch(ctx, interval, func() (*repository.Snapshot, error) {
+		snap, err := r.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		if snap.Revision == last {
+			return nil, nil
+		}
+		last = snap.Revision
+		return snap, nil
+	})
+}
@@ -0,0 +1,63 @@
+package composite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+func TestWatchMergedPolicy(t *testing.T) {
+	base := newLayer(t, "base", role("admin", "base"), role("view", "base"))
+	override := newLayer(t, "override", role("admin", "override"))
+
+	repo, err := Create("base", base, override)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := repo.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Error watching: %v", err)
+	}
+
+	layers := map[string]string{}
+	for len(layers) < 2 {
+		select {
+		case e := <-ch:
+			layers[e.Name] = e.Object.(api.Role).Annotations[LayerAnnotation]
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for events, got %v", layers)
+		}
+	}
+	if layers["admin"] != "override" || layers["view"] != "base" {
+		t.Errorf("Expected events for the objects in effect, got %v", layers)
+	}
+}
+
+func TestCurrentRevision(t *testing.T) {
+	base := newLayer(t, "base", role("view", "base"))
+	override := newLayer(t, "override", role("admin", "override"))
+	repo, err := Create("override", base, override)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	rev, err := repo.CurrentRevision()
+	if err != nil {
+		t.Fatalf("Error reading the revision: %v", err)
+	}
+	if again, err := repo.CurrentRevision(); err != nil || again != rev {
+		t.Errorf("Expected revision %s of an unchanged policy, got %s, %v", rev, again, err)
+	}
+	// A change to a layer that is not written through the repository
+	if err = base.Repo.CreateRole(role("edit", "base")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.CurrentRevision(); err != nil || got == rev {
+		t.Errorf("Expected a new revision after a layer changed, got %s, %v", got, err)
+	}
+}
@@ -0,0 +1,367 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// Document is a complete policy along with the resource version counter, as persisted by
+// the repository backends. Its methods implement the repository operations in memory, so
+// that backends only need to load and store documents. A Document is a PolicyRepository,
+// but it is not safe for concurrent use.
+//
+// Writes keep the policy referentially intact: bindings cannot reference roles that do not
+// exist, and roles cannot be deleted while bindings reference them.
+type Document struct {
+	api.Policy
+	// ResourceVersion is the version assigned to the most recently written object. It is
+	// bumped by every write, including deletes, so it also identifies the revision of the policy.
+	ResourceVersion uint64 `json:",omitempty"`
+	// AllowDanglingReferences disables the referential integrity checks, for documents that
+	// only hold part of a policy, such as a layer of a composite repository. It is not persisted.
+	AllowDanglingReferences bool `json:"-"`
+}
+
+// Revision returns the identifier of the current revision of the policy.
+func (d *Document) Revision() string {
+	return strconv.FormatUint(d.ResourceVersion, 10)
+}
+
+var _ PolicyRepository = &Document{}
+var _ CascadeRepository = &Document{}
+var _ SnapshotRepository = &Document{}
+
+// Snapshot returns the policy of the document at its current revision. The policy shares
+// its objects with the document, so it must not be used after the document is written.
+func (d *Document) Snapshot() (*Snapshot, error) {
+	return &Snapshot{Policy: d.Policy, Revision: d.Revision()}, nil
+}
+
+// NextResourceVersion bumps the document's resource version and returns it, so that it
+// can be assigned to the object being written.
+func (d *Document) NextResourceVersion() string {
+	d.ResourceVersion++
+	return strconv.FormatUint(d.ResourceVersion, 10)
+}
+
+// GetRole with the given name and namespace.
+func (d *Document) GetRole(name, namespace string) (*api.Role, error) {
+	i := findRoleIndex(d.Roles, name, namespace)
+	if i < 0 {
+		return nil, &NotFoundError{Kind: api.RoleKind, Name: name, Namespace: namespace}
+	}
+	r := d.Roles[i]
+	return &r, nil
+}
+
+// CreateRole the given role.
+func (d *Document) CreateRole(role api.Role) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+
+	i := findRoleIndex(d.Roles, role.Name, role.Namespace)
+	if i >= 0 {
+		return &AlreadyExistsError{Kind: api.RoleKind, Name: role.Name, Namespace: role.Namespace}
+	}
+
+	role.UID = NewUID()
+	role.CreationTimestamp = CreationTimestamp()
+	role.ResourceVersion = d.NextResourceVersion()
+	d.Roles = append(d.Roles, role)
+	return nil
+}
+
+// UpdateRole the given role.
+func (d *Document) UpdateRole(role api.Role) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+
+	i := findRoleIndex(d.Roles, role.Name, role.Namespace)
+	if i < 0 {
+		return &NotFoundError{Kind: api.RoleKind, Name: role.Name, Namespace: role.Namespace}
+	}
+
+	current := d.Roles[i]
+	if err := CheckResourceVersion(api.RoleKind, role.Name, role.Namespace, current.ResourceVersion, role.ResourceVersion); err != nil {
+		return err
+	}
+
+	role.UID = current.UID
+	role.CreationTimestamp = current.CreationTimestamp
+	role.ResourceVersion = d.NextResourceVersion()
+	d.Roles[i] = role
+	return nil
+}
+
+// DeleteRole with the given name and namespace.
+func (d *Document) DeleteRole(name, namespace, resourceVersion string) error {
+	i := findRoleIndex(d.Roles, name, namespace)
+	if i < 0 {
+		return &NotFoundError{Kind: api.RoleKind, Name: name, Namespace: namespace}
+	}
+
+	if err := CheckResourceVersion(api.RoleKind, name, namespace, d.Roles[i].ResourceVersion, resourceVersion); err != nil {
+		return err
+	}
+	if !d.AllowDanglingReferences {
+		if err := CheckUnreferenced(d.Policy, api.ObjectReference{Kind: api.RoleKind, Name: name, Namespace: namespace}); err != nil {
+			return err
+		}
+	}
+
+	d.Roles = append(d.Roles[:i], d.Roles[i+1:]...)
+	d.NextResourceVersion()
+	return nil
+}
+
+// DeleteRoleCascade deletes the role with the given name and namespace, along with the role
+// bindings that reference it.
+func (d *Document) DeleteRoleCascade(name, namespace, resourceVersion string, opts ApplyOptions) ([]Change, error) {
+	i := findRoleIndex(d.Roles, name, namespace)
+	if i < 0 {
+		return nil, &NotFoundError{Kind: api.RoleKind, Name: name, Namespace: namespace}
+	}
+
+	if err := CheckResourceVersion(api.RoleKind, name, namespace, d.Roles[i].ResourceVersion, resourceVersion); err != nil {
+		return nil, err
+	}
+
+	return d.Apply(CascadePolicy(d.Policy, name, namespace), opts)
+}
+
+// PurgeNamespace deletes all the roles and role bindings of the namespace.
+func (d *Document) PurgeNamespace(namespace string, opts ApplyOptions) ([]Change, error) {
+	return d.Apply(PurgeNamespacePolicy(d.Policy, namespace), opts)
+}
+
+// ListRoles in the given namespace, or in all namespaces.
+func (d *Document) ListRoles(namespace string, opts ListOptions) (*api.RoleList, error) {
+	sel, err := ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []api.Role{}
+	for _, r := range d.Roles {
+		if (namespace == api.NamespaceAll || r.Namespace == namespace) && sel.Matches(r.Labels) {
+			roles = append(roles, r)
+		}
+	}
+
+	key := func(i int) string { return ObjectKey(roles[i].Namespace, roles[i].Name) }
+	sort.Slice(roles, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := Page(len(roles), key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RoleList{Items: roles[start:end], Continue: next}, nil
+}
+
+// GetRoleBinding with the given name and namespace.
+func (d *Document) GetRoleBinding(name, namespace string) (*api.RoleBinding, error) {
+	i := findRoleBindingIndex(d.RoleBindings, name, namespace)
+	if i < 0 {
+		return nil, &NotFoundError{Kind: api.RoleBindingKind, Name: name, Namespace: namespace}
+	}
+	rb := d.RoleBindings[i]
+	return &rb, nil
+}
+
+// CreateRoleBinding the given role binding.
+func (d *Document) CreateRoleBinding(rb api.RoleBinding) error {
+	if err := ValidateRoleBinding(rb); err != nil {
+		return err
+	}
+	if !d.AllowDanglingReferences {
+		if err := ValidateRoleRef(d, api.RoleBindingKind, rb.Name, rb.Namespace, rb.RoleRef); err != nil {
+			return err
+		}
+	}
+
+	i := findRoleBindingIndex(d.RoleBindings, rb.Name, rb.Namespace)
+	if i >= 0 {
+		return &AlreadyExistsError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace}
+	}
+
+	rb.UID = NewUID()
+	rb.CreationTimestamp = CreationTimestamp()
+	rb.ResourceVersion = d.NextResourceVersion()
+	d.RoleBindings = append(d.RoleBindings, rb)
+	return nil
+}
+
+// UpdateRoleBinding with the new role binding.
+func (d *Document) UpdateRoleBinding(rb api.RoleBinding) error {
+	if err := ValidateRoleBinding(rb); err != nil {
+		return err
+	}
+	if !d.AllowDanglingReferences {
+		if err := ValidateRoleRef(d, api.RoleBindingKind, rb.Name, rb.Namespace, rb.RoleRef); err != nil {
+			return err
+		}
+	}
+
+	i := findRoleBindingIndex(d.RoleBindings, rb.Name, rb.Namespace)
+	if i < 0 {
+		return &NotFoundError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace}
+	}
+
+	current := d.RoleBindings[i]
+	if err := CheckResourceVersion(api.RoleBindingKind, rb.Name, rb.Namespace, current.ResourceVersion, rb.ResourceVersion); err != nil {
+		return err
+	}
+
+	rb.UID = current.UID
+	rb.CreationTimestamp = current.CreationTimestamp
+	rb.ResourceVersion = d.NextResourceVersion()
+	d.RoleBindings[i] = rb
+	return nil
+}
+
+// DeleteRoleBinding with the given name and namespace.
+func (d *Document) DeleteRoleBinding(name, namespace, resourceVersion string) error {
+	i := findRoleBindingIndex(d.RoleBindings, name, namespace)
+	if i < 0 {
+		return &NotFoundError{Kind: api.RoleBindingKind, Name: name, Namespace: namespace}
+	}
+
+	if err := CheckResourceVersion(api.RoleBindingKind, name, namespace, d.RoleBindings[i].ResourceVersion, resourceVersion); err != nil {
+		return err
+	}
+
+	d.RoleBindings = append(d.RoleBindings[:i], d.RoleBindings[i+1:]...)
+	d.NextResourceVersion()
+	return nil
+}
+
+// ListRoleBindings in the given namespace, or in all namespaces.
+func (d *Document) ListRoleBindings(namespace string, opts ListOptions) (*api.RoleBindingList, error) {
+	sel, err := ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []api.RoleBinding{}
+	for _, b := range d.RoleBindings {
+		if (namespace == api.NamespaceAll || b.Namespace == namespace) && sel.Matches(b.Labels) {
+			bindings = append(bindings, b)
+		}
+	}
+
+	key := func(i int) string { return ObjectKey(bindings[i].Namespace, bindings[i].Name) }
+	sort.Slice(bindings, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := Page(len(bindings), key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RoleBindingList{Items: bindings[start:end], Continue: next}, nil
+}
+
+// GetClusterRole with the given name.
+func (d *Document) GetClusterRole(name string) (*api.ClusterRole, error) {
+	for _, cr := range d.ClusterRoles {
+		if cr.Name == name {
+			return &cr, nil
+		}
+	}
+	return nil, &NotFoundError{Kind: api.ClusterRoleKind, Name: name}
+}
+
+// ListClusterRoles that match the given options.
+func (d *Document) ListClusterRoles(opts ListOptions) (*api.ClusterRoleList, error) {
+	sel, err := ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []api.ClusterRole{}
+	for _, cr := range d.ClusterRoles {
+		if sel.Matches(cr.Labels) {
+			roles = append(roles, cr)
+		}
+	}
+
+	key := func(i int) string { return roles[i].Name }
+	sort.Slice(roles, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := Page(len(roles), key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ClusterRoleList{Items: roles[start:end], Continue: next}, nil
+}
+
+// ListClusterRoleBindings that match the given options.
+func (d *Document) ListClusterRoleBindings(opts ListOptions) (*api.ClusterRoleBindingList, error) {
+	sel, err := ParseSelector(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := []api.ClusterRoleBinding{}
+	for _, b := range d.ClusterRoleBindings {
+		if sel.Matches(b.Labels) {
+			bindings = append(bindings, b)
+		}
+	}
+
+	key := func(i int) string { return bindings[i].Name }
+	sort.Slice(bindings, func(i, j int) bool { return key(i) < key(j) })
+	start, end, next, err := Page(len(bindings), key, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ClusterRoleBindingList{Items: bindings[start:end], Continue: next}, nil
+}
+
+// Apply replaces the policy of the document with the given one. The document is left
+// untouched when the options specify a dry run.
+func (d *Document) Apply(desired api.Policy, opts ApplyOptions) ([]Change, error) {
+	next := d.ResourceVersion
+	result, changes, err := PlanApply(d.Policy, desired, func() string {
+		next++
+		return strconv.FormatUint(next, 10)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !d.AllowDanglingReferences {
+		if err = ValidateReferences(result); err != nil {
+			return nil, err
+		}
+	}
+	if len(changes) > 0 && next == d.ResourceVersion {
+		// Only deletions, which still make a new revision of the policy
+		next++
+	}
+	if !opts.DryRun {
+		d.Policy = result
+		d.ResourceVersion = next
+	}
+	return changes, nil
+}
+
+func findRoleIndex(roles []api.Role, name, namespace string) int {
+	for i, r := range roles {
+		if r.Name == name && r.Namespace == namespace {
+			return i
+		}
+	}
+	return -1
+}
+
+func findRoleBindingIndex(bindings []api.RoleBinding, name, namespace string) int {
+	for i, rb := range bindings {
+		if rb.Name == name && rb.Namespace == namespace {
+			return i
+		}
+	}
+	return -1
+}
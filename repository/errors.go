@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotFound is matched by errors returned when the requested object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is matched by errors returned when creating an object that already exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrConflict is matched by errors returned when a write is based on a stale resource version.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid is matched by errors returned when an object fails validation.
+	ErrInvalid = errors.New("invalid")
+	// ErrReferenced is matched by errors returned when deleting a role that bindings still reference.
+	ErrReferenced = errors.New("referenced")
+)
+
+// NotFoundError is returned when the requested object does not exist.
+// It matches ErrNotFound with errors.Is.
+type NotFoundError struct {
+	// Kind of the object, e.g. "Role".
+	Kind string
+	// Name of the object.
+	Name string
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s does not exist", describe(e.Kind, e.Name, e.Namespace))
+}
+
+// Is reports whether target is ErrNotFound.
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// AlreadyExistsError is returned when creating an object that already exists.
+// It matches ErrAlreadyExists with errors.Is.
+type AlreadyExistsError struct {
+	// Kind of the object, e.g. "Role".
+	Kind string
+	// Name of the object.
+	Name string
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return fmt.Sprintf("%s already exists", describe(e.Kind, e.Name, e.Namespace))
+}
+
+// Is reports whether target is ErrAlreadyExists.
+func (e *AlreadyExistsError) Is(target error) bool {
+	return target == ErrAlreadyExists
+}
+
+// ConflictError is returned when an update or delete specifies a resource version
+// that does not match the version currently stored in the repository.
+// It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	// Kind of the object being written, e.g. "Role".
+	Kind string
+	// Name of the object being written.
+	Name string
+	// Namespace of the object being written. Empty for cluster-scoped objects.
+	Namespace string
+	// ResourceVersion that was requested by the caller.
+	ResourceVersion string
+	// CurrentResourceVersion is the version stored in the repository.
+	CurrentResourceVersion string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s has been modified: requested resource version '%s' but current is '%s'", describe(e.Kind, e.Name, e.Namespace), e.ResourceVersion, e.CurrentResourceVersion)
+}
+
+// Is reports whether target is ErrConflict.
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// InvalidError is returned when an object fails validation.
+// It matches ErrInvalid with errors.Is.
+type InvalidError struct {
+	// Kind of the object, e.g. "Role".
+	Kind string
+	// Name of the object.
+	Name string
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string
+	// Reason describes why the object is invalid.
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return fmt.Sprintf("%s is invalid: %s", describe(e.Kind, e.Name, e.Namespace), e.Reason)
+}
+
+// Is reports whether target is ErrInvalid.
+func (e *InvalidError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// ReferencedError is returned when deleting a role that role bindings or cluster role bindings
+// still reference. It matches ErrReferenced with errors.Is.
+type ReferencedError struct {
+	// Kind of the object being deleted, e.g. "Role".
+	Kind string
+	// Name of the object being deleted.
+	Name string
+	// Namespace of the object being deleted. Empty for cluster-scoped objects.
+	Namespace string
+	// ReferencedBy describes the bindings that reference the object, e.g. "RoleBinding 'ns/name'".
+	ReferencedBy []string
+}
+
+func (e *ReferencedError) Error() string {
+	return fmt.Sprintf("%s is referenced by %s", describe(e.Kind, e.Name, e.Namespace), strings.Join(e.ReferencedBy, ", "))
+}
+
+// Is reports whether target is ErrReferenced.
+func (e *ReferencedError) Is(target error) bool {
+	return target == ErrReferenced
+}
+
+// HTTPStatusCode returns the HTTP status code that corresponds to the given repository error.
+// Errors that do not match any of the repository errors are reported as internal server errors.
+func HTTPStatusCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAlreadyExists), errors.Is(err, ErrConflict), errors.Is(err, ErrReferenced):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalid):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func describe(kind, name, namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("%s '%s'", kind, name)
+	}
+	return fmt.Sprintf("%s '%s' in namespace '%s'", kind, name, namespace)
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	cases := []struct {
+		err      error
+		sentinel error
+	}{
+		{&NotFoundError{Kind: "Role", Name: "admin", Namespace: "default"}, ErrNotFound},
+		{&AlreadyExistsError{Kind: "Role", Name: "admin", Namespace: "default"}, ErrAlreadyExists},
+		{&ConflictError{Kind: "Role", Name: "admin", Namespace: "default"}, ErrConflict},
+		{&InvalidError{Kind: "Role", Reason: "name is required"}, ErrInvalid},
+		{&ReferencedError{Kind: "Role", Name: "admin", Namespace: "default"}, ErrReferenced},
+	}
+	all := []error{ErrNotFound, ErrAlreadyExists, ErrConflict, ErrInvalid, ErrReferenced}
+
+	for i, c := range cases {
+		wrapped := fmt.Errorf("wrapped: %w", c.err)
+		for _, s := range all {
+			if got := errors.Is(wrapped, s); got != (s == c.sentinel) {
+				t.Errorf("Case %d: errors.Is(%v, %v) = %v", i, c.err, s, got)
+			}
+		}
+	}
+}
+
+func TestHTTPStatusCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, http.StatusOK},
+		{&NotFoundError{Kind: "Role"}, http.StatusNotFound},
+		{&AlreadyExistsError{Kind: "Role"}, http.StatusConflict},
+		{&ConflictError{Kind: "Role"}, http.StatusConflict},
+		{&InvalidError{Kind: "Role"}, http.StatusUnprocessableEntity},
+		{&ReferencedError{Kind: "Role"}, http.StatusConflict},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for i, c := range cases {
+		if got := HTTPStatusCode(c.err); got != c.code {
+			t.Errorf("Case %d: expected status code %d, got %d", i, c.code, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package file
+
+import (
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// Apply replaces the policy with the given one in a single write. The author and message
+// of the options are recorded in the history.
+func (fr *FlatFileRepository) Apply(desired api.Policy, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = "Apply policy"
+	}
+
+	var changes []repository.Change
+	err := fr.update(opts.Author, message, func(p *repository.Document) error {
+		var err error
+		changes, err = p.Apply(desired, opts)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
+
+var _ repository.CascadeRepository = &FlatFileRepository{}
+
+// DeleteRoleCascade deletes the role along with the role bindings that reference it, in a
+// single write.
+func (fr *FlatFileRepository) DeleteRoleCascade(name, namespace, resourceVersion string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Delete role %s and its role bindings", repository.ObjectKey(namespace, name))
+	}
+
+	var changes []repository.Change
+	err := fr.update(opts.Author, message, func(p *repository.Document) error {
+		var err error
+		changes, err = p.DeleteRoleCascade(name, namespace, resourceVersion, opts)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
+
+// PurgeNamespace deletes all the roles and role bindings of the namespace in a single write.
+func (fr *FlatFileRepository) PurgeNamespace(namespace string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Purge namespace %s", namespace)
+	}
+
+	var changes []repository.Change
+	err := fr.update(opts.Author, message, func(p *repository.Document) error {
+		var err error
+		changes, err = p.PurgeNamespace(namespace, opts)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
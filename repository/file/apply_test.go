@@ -0,0 +1,165 @@
+package file
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestApply(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	rb := testRoleBinding
+	rb.RoleRef = api.ObjectReference{Kind: api.ClusterRoleKind, Name: "view"}
+	desired := api.Policy{
+		RoleBindings: []api.RoleBinding{rb},
+		ClusterRoles: []api.ClusterRole{{Name: "view", Rules: []api.PolicyRule{{Verbs: []string{"get"}}}}},
+	}
+
+	before, err := ioutil.ReadFile(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := repo.Apply(desired, repository.ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Error applying policy: %v", err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("Expected 3 changes, got %v", changes)
+	}
+	after, err := ioutil.ReadFile(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected dry run to leave the policy file untouched")
+	}
+
+	if _, err = repo.Apply(desired, repository.ApplyOptions{}); err != nil {
+		t.Fatalf("Error applying policy: %v", err)
+	}
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); err == nil {
+		t.Errorf("Expected role to be deleted by apply")
+	}
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Errorf("Expected role binding to be created by apply: %v", err)
+	}
+
+	// Applying the same policy again is a no-op
+	changes, err = repo.Apply(desired, repository.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error applying policy: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes when re-applying the policy, got %v", changes)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyInvalidPolicyChangesNothing(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	desired := api.Policy{
+		RoleBindings: []api.RoleBinding{testRoleBinding},
+		Roles:        []api.Role{{Name: "no-namespace"}},
+	}
+	if _, err = repo.Apply(desired, repository.ApplyOptions{}); err == nil {
+		t.Fatalf("Expected error applying invalid policy")
+	}
+
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); err != nil {
+		t.Errorf("Expected role to survive failed apply: %v", err)
+	}
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err == nil {
+		t.Errorf("Expected role binding not to be created by failed apply")
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteRoleCascadeIsOneRevision(t *testing.T) {
+	repo, err := createRepoWithRoleBinding(testRoleBinding)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+
+	if err = repo.DeleteRole(testRole.Name, testRole.Namespace, ""); !errors.Is(err, repository.ErrReferenced) {
+		t.Errorf("Expected referenced error deleting a role in use, got %v", err)
+	}
+
+	changes, err := fr.DeleteRoleCascade(testRole.Name, testRole.Namespace, "", repository.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("Expected the role and its binding to be deleted, got %v", changes)
+	}
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected role binding to be deleted, got %v", err)
+	}
+
+	revs, err := fr.History()
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(revs) != 4 || revs[0].Message != "Delete role default/SomeRole and its role bindings" {
+		t.Errorf("Expected a single revision for the cascading delete, got %+v", revs)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeNamespaceIsOneRevision(t *testing.T) {
+	repo, err := createRepoWithRoleBinding(testRoleBinding)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+
+	// The binding references a role in another namespace, which cannot be purged first
+	if _, err = fr.PurgeNamespace(testRole.Namespace, repository.ApplyOptions{}); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error purging a namespace with referenced roles, got %v", err)
+	}
+	for _, ns := range []string{testRoleBinding.Namespace, testRole.Namespace} {
+		if _, err = fr.PurgeNamespace(ns, repository.ApplyOptions{}); err != nil {
+			t.Fatalf("Error purging namespace %s: %v", ns, err)
+		}
+	}
+	p, err := repository.ReadPolicy(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Roles) != 0 || len(p.RoleBindings) != 0 {
+		t.Errorf("Expected namespace to be purged, got %+v", p)
+	}
+
+	revs, err := fr.History()
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(revs) != 5 || revs[0].Message != "Purge namespace default" || revs[1].Message != "Purge namespace namespace" {
+		t.Errorf("Expected a single revision for each purge, got %+v", revs)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
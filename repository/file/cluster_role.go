@@ -1,23 +1,24 @@
 package file
 
 import (
-	"fmt"
-
 	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
 )
 
 // GetClusterRole with the given name
 func (fr *FlatFileRepository) GetClusterRole(name string) (*api.ClusterRole, error) {
-	fr.RLock()
-	defer fr.RUnlock()
-	p, err := fr.readPolicy()
+	p, err := fr.view()
 	if err != nil {
 		return nil, err
 	}
-	for _, cr := range p.ClusterRoles {
-		if cr.Name == name {
-			return &cr, nil
-		}
+	return p.GetClusterRole(name)
+}
+
+// ListClusterRoles that match the given options
+func (fr *FlatFileRepository) ListClusterRoles(opts repository.ListOptions) (*api.ClusterRoleList, error) {
+	p, err := fr.view()
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Cluster role '%s' does not exist", name)
+	return p.ListClusterRoles(opts)
 }
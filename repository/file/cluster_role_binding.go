@@ -1,14 +1,15 @@
 package file
 
-import "github.com/kismatic/kubernetes-rbac/api"
+import (
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
 
-// ListClusterRoleBindings returns a list of all cluster role bindings
-func (fr *FlatFileRepository) ListClusterRoleBindings() ([]api.ClusterRoleBinding, error) {
-	fr.RLock()
-	defer fr.RUnlock()
-	p, err := fr.readPolicy()
+// ListClusterRoleBindings returns a list of the cluster role bindings that match the given options
+func (fr *FlatFileRepository) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	p, err := fr.view()
 	if err != nil {
 		return nil, err
 	}
-	return p.ClusterRoleBindings, nil
+	return p.ListClusterRoleBindings(opts)
 }
@@ -0,0 +1,220 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.HistoryRepository = &FlatFileRepository{}
+
+// revisionRecord is the content of a file in the history directory.
+type revisionRecord struct {
+	Revision repository.Revision `json:"revision"`
+	Document repository.Document `json:"document"`
+	// Signature of the policy file of the revision, when it was written with a signing key.
+	Signature string `json:"signature,omitempty"`
+}
+
+// HistoryDir is the directory that holds the revisions of the policy, one file per revision.
+func (fr *FlatFileRepository) HistoryDir() string {
+	return fr.File + ".history"
+}
+
+// History returns the revisions kept in the history directory, from the newest to the oldest.
+func (fr *FlatFileRepository) History() ([]repository.Revision, error) {
+	fr.RLock()
+	defer fr.RUnlock()
+
+	ids, err := fr.revisionIDs()
+	if err != nil {
+		return nil, err
+	}
+	revs := []repository.Revision{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		rec, err := fr.readRevision(strconv.FormatUint(ids[i], 10))
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, rec.Revision)
+	}
+	return revs, nil
+}
+
+// ShowRevision returns the policy as it was in the given revision.
+func (fr *FlatFileRepository) ShowRevision(id string) (*api.Policy, error) {
+	fr.RLock()
+	defer fr.RUnlock()
+
+	rec, err := fr.readRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Document.Policy, nil
+}
+
+// Rollback restores the policy of the given revision, and records the result as a new revision.
+// With a trust root, the revision must have been signed by a trusted key.
+func (fr *FlatFileRepository) Rollback(id string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Rollback to revision %s", id)
+	}
+
+	var changes []repository.Change
+	err := fr.update(opts.Author, message, func(p *repository.Document) error {
+		rec, err := fr.readRevision(id)
+		if err != nil {
+			return err
+		}
+		// The restored policy is signed again when it is written, so it must be trusted
+		if fr.TrustRoot != nil {
+			if err = fr.verifyRevision(rec); err != nil {
+				return err
+			}
+		}
+		changes, err = p.Apply(repository.RollbackPolicy(rec.Document.Policy), opts)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
+
+// verifyRevision checks the signature of the policy of the revision with the trust root.
+func (fr *FlatFileRepository) verifyRevision(rec *revisionRecord) error {
+	// The policy file of the revision was written from the same document
+	data, err := json.MarshalIndent(&rec.Document, "", "    ")
+	if err != nil {
+		return err
+	}
+	var sig []byte
+	if rec.Signature != "" {
+		sig = []byte(rec.Signature)
+	}
+	return fr.TrustRoot.Verify(fmt.Sprintf("%s revision %s", fr.File, rec.Revision.ID), data, sig)
+}
+
+// recordRevision writes the given policy to the history directory, along with the signature
+// of its policy file, if any, and removes the oldest revisions beyond the history limit.
+// Callers must hold the lock.
+func (fr *FlatFileRepository) recordRevision(p *repository.Document, sig []byte, author, message string) error {
+	if fr.HistoryLimit <= 0 {
+		return nil
+	}
+
+	hash, err := repository.ContentHash(p.Policy)
+	if err != nil {
+		return err
+	}
+	rec := revisionRecord{
+		Revision: repository.Revision{
+			ID:        p.Revision(),
+			Timestamp: time.Now().UTC(),
+			Hash:      hash,
+			Author:    author,
+			Message:   message,
+		},
+		Document:  *p,
+		Signature: string(sig),
+	}
+	b, err := json.MarshalIndent(rec, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	dir := fr.HistoryDir()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Error creating the role repo history directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(dir, "revision.tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary role repo revision file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error writing temporary role repo revision file: %v", err)
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fr.revisionFile(rec.Revision.ID)); err != nil {
+		return fmt.Errorf("Error writing the role repo revision file: %v", err)
+	}
+
+	ids, err := fr.revisionIDs()
+	if err != nil {
+		return err
+	}
+	for len(ids) > fr.HistoryLimit {
+		if err = os.Remove(fr.revisionFile(strconv.FormatUint(ids[0], 10))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error removing old role repo revision file: %v", err)
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// revisionIDs returns the IDs of the revisions in the history directory, from the oldest
+// to the newest.
+func (fr *FlatFileRepository) revisionIDs() ([]uint64, error) {
+	files, err := ioutil.ReadDir(fr.HistoryDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the role repo history directory: %v", err)
+	}
+	ids := []uint64{}
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (fr *FlatFileRepository) revisionFile(id string) string {
+	return filepath.Join(fr.HistoryDir(), id+".json")
+}
+
+func (fr *FlatFileRepository) readRevision(id string) (*revisionRecord, error) {
+	// Only accept numeric IDs, which also keeps the path within the history directory
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return nil, &repository.NotFoundError{Kind: repository.RevisionKind, Name: id}
+	}
+	data, err := ioutil.ReadFile(fr.revisionFile(id))
+	if os.IsNotExist(err) {
+		return nil, &repository.NotFoundError{Kind: repository.RevisionKind, Name: id}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the role repo revision file: %v", err)
+	}
+	rec := &revisionRecord{}
+	if err = json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling role repo revision %s: %v", id, err)
+	}
+	return rec, nil
+}
@@ -0,0 +1,156 @@
+package file
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestHistoryRecordsRevisions(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+
+	revs, err := fr.History()
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(revs) != 3 {
+		t.Fatalf("Expected 3 revisions, got %+v", revs)
+	}
+	if revs[0].Message != "Create role binding namespace/name" {
+		t.Errorf("Expected newest revision first, got %+v", revs[0])
+	}
+	if revs[0].Hash == "" || revs[0].Hash == revs[1].Hash || revs[0].Timestamp.IsZero() {
+		t.Errorf("Expected revisions to have distinct hashes and a timestamp, got %+v", revs)
+	}
+
+	p, err := fr.ShowRevision(revs[1].ID)
+	if err != nil {
+		t.Fatalf("Error showing revision: %v", err)
+	}
+	if len(p.Roles) != 1 || len(p.RoleBindings) != 0 {
+		t.Errorf("Expected revision %s to only have the role, got %+v", revs[1].ID, p)
+	}
+
+	if _, err = fr.ShowRevision("../policy-repo"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error for invalid revision, got %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+	fr.HistoryLimit = 2
+
+	for i := 0; i < 3; i++ {
+		r, err := repo.GetRole(testRole.Name, testRole.Namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = repo.UpdateRole(*r); err != nil {
+			t.Fatalf("Error updating role: %v", err)
+		}
+	}
+
+	revs, err := fr.History()
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(revs) != 2 {
+		t.Errorf("Expected history to be limited to 2 revisions, got %+v", revs)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryErrorsDoNotFailWrites(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+
+	// The revisions cannot be recorded once the history directory is a file
+	if err = os.RemoveAll(fr.HistoryDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fr.HistoryDir(), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Errorf("Expected the write to succeed without a history, got %v", err)
+	}
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Errorf("Expected the role binding to be written: %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+
+	revs, err := fr.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := revs[0]
+	if err = repo.DeleteRole(testRole.Name, testRole.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+
+	changes, err := fr.Rollback(target.ID, repository.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != repository.ChangeCreate {
+		t.Errorf("Expected rollback to recreate the role, got %v", changes)
+	}
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); err != nil {
+		t.Errorf("Expected role to be restored: %v", err)
+	}
+
+	revs, err = fr.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revs[0].Message != "Rollback to revision "+target.ID {
+		t.Errorf("Expected rollback to be recorded as a new revision, got %+v", revs[0])
+	}
+	if revs[0].ID == target.ID {
+		t.Errorf("Expected rollback to get a new revision ID")
+	}
+
+	if _, err = fr.Rollback("999", repository.ApplyOptions{}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error rolling back to unknown revision, got %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package file
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockfileExclusiveLock requests an exclusive lock from LockFileEx.
+const lockfileExclusiveLock = 0x2
+
+// lockFile locks the whole file with LockFileEx, waiting until it is available.
+func lockFile(f *os.File) error {
+	ol := &syscall.Overlapped{}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 0xffffffff, 0xffffffff, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	ol := &syscall.Overlapped{}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 0xffffffff, 0xffffffff, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Directories cannot be synced on Windows.
+func isSyncUnsupported(err error) bool {
+	return true
+}
@@ -2,78 +2,348 @@ package file
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
-	"github.com/kismatic/kubernetes-rbac/api"
 	"github.com/kismatic/kubernetes-rbac/repository"
 )
 
-type policy struct {
-	Roles        []api.Role
-	RoleBindings []api.RoleBinding
-	ClusterRoles []api.ClusterRole
-	ClusterRoleBindings []api.ClusterRoleBinding
-}
+// errNoChanges is returned by update functions to skip writing the policy.
+var errNoChanges = errors.New("no changes")
+
+// DefaultHistoryLimit is the default number of revisions kept in the history.
+const DefaultHistoryLimit = 10
+
+// maxReadAttempts is how many times a signed policy is read before it is reported as untrusted.
+const maxReadAttempts = 3
 
 // FlatFileRepository implements the repository interface and
 // persists objects on disk.
 type FlatFileRepository struct {
 	sync.RWMutex
 	File string
+	// HistoryLimit is the number of revisions of the policy kept in the history
+	// directory. The history is not kept if it is zero.
+	HistoryLimit int
+	// WatchInterval is how often Watch checks the policy file for changes. Defaults to
+	// repository.DefaultWatchInterval.
+	WatchInterval time.Duration
+	// TrustRoot, when set, is used to verify the signature file of the policy every time it is
+	// read. Unsigned and untrusted policies are refused.
+	TrustRoot *repository.TrustRoot
+	// Signer, when set, signs the policy every time it is written. It is required to write a
+	// policy that is verified with a trust root.
+	Signer *repository.Signer
+	// AllowDanglingReferences disables the referential integrity checks on writes, for policy
+	// files that only hold part of a policy, such as the layers of a composite repository.
+	AllowDanglingReferences bool
+
+	// revisionInfo is the state of the policy file when its revision was last read.
+	revisionMu   sync.Mutex
+	revisionInfo os.FileInfo
+	revision     string
 }
 
 // Create returns a new FlatFileRepository
 func Create(file string) (repository.PolicyRepository, error) {
+	fr := &FlatFileRepository{
+		File:         file,
+		HistoryLimit: DefaultHistoryLimit,
+	}
 
 	// Ensure file exists
 	if _, err := os.Stat(file); os.IsNotExist(err) {
-		createEmptyRepo(file)
+		if err = fr.createEmptyRepo(); err != nil {
+			return nil, fmt.Errorf("Error creating the role repo file: %v", err)
+		}
 	}
 
-	return &FlatFileRepository{
-		File: file,
-	}, nil
+	return fr, nil
+}
+
+func (fr *FlatFileRepository) createEmptyRepo() error {
+	unlock, err := fr.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process might have created the file while we were waiting for the lock
+	if _, err = os.Stat(fr.File); err == nil {
+		return nil
+	}
+	return fr.writePolicy(&repository.Document{}, "", "Create policy")
+}
+
+// view reads the current policy.
+func (fr *FlatFileRepository) view() (*repository.Document, error) {
+	fr.RLock()
+	defer fr.RUnlock()
+	return fr.readPolicy()
 }
 
-func createEmptyRepo(file string) error {
-	f, err := os.Create(file)
+// update reads the policy, modifies it with fn and writes it back while holding the lock.
+// Nothing is written if fn returns an error, or errNoChanges. The author and message are
+// recorded in the history.
+func (fr *FlatFileRepository) update(author, message string, fn func(p *repository.Document) error) error {
+	unlock, err := fr.lock()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	b, err := json.Marshal(policy{})
+	defer unlock()
+
+	p, err := fr.readPolicy()
 	if err != nil {
 		return err
 	}
-	if _, err = f.Write(b); err != nil {
+	p.AllowDanglingReferences = fr.AllowDanglingReferences
+	if err = fn(p); err == errNoChanges {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+	return fr.writePolicy(p, author, message)
+}
+
+// lock acquires exclusive access to the repository for a read-modify-write cycle.
+// The mutex serializes goroutines within this process, and the advisory lock on the
+// lock file serializes all processes that write to the same policy file.
+func (fr *FlatFileRepository) lock() (func(), error) {
+	fr.Lock()
+	f, err := os.OpenFile(fr.lockFile(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		fr.Unlock()
+		return nil, fmt.Errorf("Error opening the role repo lock file: %v", err)
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		fr.Unlock()
+		return nil, fmt.Errorf("Error locking the role repo: %v", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		fr.Unlock()
+	}, nil
+}
+
+// lockFile is the file used to coordinate writers across processes.
+func (fr *FlatFileRepository) lockFile() string {
+	return fr.File + ".lock"
+}
+
+// SignatureFile is the file that holds the detached signature of the policy.
+func (fr *FlatFileRepository) SignatureFile() string {
+	return fr.File + repository.SignatureSuffix
+}
+
+// BackupFile is the file that holds the policy as it was before the last write.
+func (fr *FlatFileRepository) BackupFile() string {
+	return fr.File + ".bak"
+}
+
+// nextSignatureFile holds the signature of a policy that is being written, until it replaces
+// the signature file. It is kept when a writer crashes after replacing the policy, so that
+// the new policy can still be verified.
+func (fr *FlatFileRepository) nextSignatureFile() string {
+	return fr.SignatureFile() + ".next"
 }
 
-func (fr *FlatFileRepository) readPolicy() (*policy, error) {
+func (fr *FlatFileRepository) readPolicy() (*repository.Document, error) {
 	data, err := ioutil.ReadFile(fr.File)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading the role repo file: %v", err)
 	}
+	if fr.TrustRoot != nil {
+		// Another process can replace the policy and its signature between the reads, so
+		// the policy is read again when it cannot be verified
+		for attempt := 1; ; attempt++ {
+			err = fr.verify(data)
+			if err == nil || !errors.Is(err, repository.ErrUntrusted) || attempt == maxReadAttempts {
+				break
+			}
+			reread, readErr := ioutil.ReadFile(fr.File)
+			if readErr != nil {
+				return nil, fmt.Errorf("Error reading the role repo file: %v", readErr)
+			}
+			data = reread
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	p := &policy{}
+	p := &repository.Document{}
 	if err = json.Unmarshal(data, p); err != nil {
 		return nil, fmt.Errorf("Error unmarshalling data from the role repo: %v", err)
 	}
 	return p, nil
 }
 
-func (fr *FlatFileRepository) writePolicy(p *policy) error {
+// verify checks the policy data with the signature file, or with the signature of a policy
+// being written.
+func (fr *FlatFileRepository) verify(data []byte) error {
+	var err error
+	for _, f := range []string{fr.SignatureFile(), fr.nextSignatureFile()} {
+		sig, readErr := ioutil.ReadFile(f)
+		if readErr != nil && !os.IsNotExist(readErr) {
+			return fmt.Errorf("Error reading the role repo signature file: %v", readErr)
+		}
+		if sig == nil && err != nil {
+			continue
+		}
+		if err = fr.TrustRoot.Verify(fr.File, data, sig); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// writePolicy replaces the policy file atomically. The new policy is written and synced
+// to a temporary file in the same directory, which is then renamed over the policy file,
+// so that readers and crashes never observe a partially written policy. Its signature is
+// written next to the signature file before the rename, and replaces it after. The previous
+// policy is kept in the backup file, and the new one is recorded in the history, whose
+// errors are only logged. Callers must hold the lock.
+func (fr *FlatFileRepository) writePolicy(p *repository.Document, author, message string) error {
+	if fr.TrustRoot != nil && fr.Signer == nil {
+		return &repository.UntrustedError{Source: fr.File, Reason: "a signing key is required to write a signed policy"}
+	}
+
 	b, err := json.MarshalIndent(p, "", "    ")
 	if err != nil {
 		return err
 	}
-	if err = ioutil.WriteFile(fr.File, b, 0644); err != nil {
+
+	dir := filepath.Dir(fr.File)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(fr.File)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary role repo file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error writing temporary role repo file: %v", err)
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
 		return err
 	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error syncing temporary role repo file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = fr.backup(); err != nil {
+		return err
+	}
+
+	// The signature of the new policy is in place before the policy, so that readers can
+	// verify the policy they read whichever of the renames they observe
+	var sig []byte
+	if fr.Signer != nil {
+		sig = fr.Signer.Sign(b)
+		if err = writeSignature(fr.nextSignatureFile(), sig); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp.Name(), fr.File); err != nil {
+		return fmt.Errorf("Error replacing the role repo file: %v", err)
+	}
+	if fr.Signer != nil {
+		if err = os.Rename(fr.nextSignatureFile(), fr.SignatureFile()); err != nil {
+			return fmt.Errorf("Error replacing the signature file: %v", err)
+		}
+	}
+
+	if err = syncDir(dir); err != nil {
+		return err
+	}
+	// The policy is written, so failing to record it in the history must not report the
+	// write as failed
+	if err = fr.recordRevision(p, sig, author, message); err != nil {
+		log.Printf("Error recording revision %s in the role repo history: %v", p.Revision(), err)
+	}
+	return nil
+}
+
+// WriteSignature atomically replaces the signature file of the given policy file.
+func WriteSignature(file string, sig []byte) error {
+	return writeSignature(file+repository.SignatureSuffix, sig)
+}
+
+// writeSignature atomically replaces the signature file at path.
+func writeSignature(path string, sig []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary signature file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(sig); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error writing temporary signature file: %v", err)
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Error syncing temporary signature file: %v", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("Error replacing the signature file: %v", err)
+	}
+	return syncDir(dir)
+}
+
+// backup preserves the current policy file in the backup file. The backup is
+// hard linked when possible, so that the rename in writePolicy leaves it pointing
+// to the previous contents without copying.
+func (fr *FlatFileRepository) backup() error {
+	bak := fr.BackupFile()
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing the role repo backup file: %v", err)
+	}
+	err := os.Link(fr.File, bak)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	// Fall back to copying on file systems that do not support hard links
+	data, err := ioutil.ReadFile(fr.File)
+	if err != nil {
+		return fmt.Errorf("Error reading the role repo file for backup: %v", err)
+	}
+	if err = ioutil.WriteFile(bak, data, 0644); err != nil {
+		return fmt.Errorf("Error writing the role repo backup file: %v", err)
+	}
+	return nil
+}
+
+// syncDir flushes the directory entry so that a rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil && !isSyncUnsupported(err) {
+		return fmt.Errorf("Error syncing the role repo directory: %v", err)
+	}
 	return nil
 }
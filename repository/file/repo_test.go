@@ -0,0 +1,110 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestWriteKeepsBackupOfPreviousPolicy(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	if err = repo.DeleteRole(testRole.Name, testRole.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(repo.(*FlatFileRepository).BackupFile())
+	if err != nil {
+		t.Fatalf("Error reading backup file: %v", err)
+	}
+	p := repository.Document{}
+	if err = json.Unmarshal(data, &p); err != nil {
+		t.Fatalf("Error unmarshalling backup file: %v", err)
+	}
+	if len(p.Roles) != 1 || p.Roles[0].Name != testRole.Name {
+		t.Errorf("Expected backup to contain the policy before the delete, got %+v", p)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteLeavesNoTemporaryFiles(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	dir := filepath.Dir(repo.(*FlatFileRepository).File)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), filepath.Base(getTestRepoFile())+".tmp") {
+			t.Errorf("Found leftover temporary file %q", f.Name())
+		}
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentWritersOnSameFile(t *testing.T) {
+	// Each repository has its own mutex, so only the file lock keeps
+	// them from clobbering each other, as it would across processes.
+	file := getTestRepoFile()
+	repos := make([]*FlatFileRepository, 4)
+	for i := range repos {
+		r, err := Create(file)
+		if err != nil {
+			t.Fatalf("Error creating repo: %v", err)
+		}
+		repos[i] = r.(*FlatFileRepository)
+	}
+
+	const rolesPerRepo = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, len(repos)*rolesPerRepo)
+	for i, r := range repos {
+		wg.Add(1)
+		go func(i int, r *FlatFileRepository) {
+			defer wg.Done()
+			for j := 0; j < rolesPerRepo; j++ {
+				role := api.Role{Name: fmt.Sprintf("role-%d-%d", i, j), Namespace: "default"}
+				if err := r.CreateRole(role); err != nil {
+					errs <- err
+				}
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Error creating role: %v", err)
+	}
+
+	p, err := repos[0].readPolicy()
+	if err != nil {
+		t.Fatalf("Error reading policy: %v", err)
+	}
+	if len(p.Roles) != len(repos)*rolesPerRepo {
+		t.Errorf("Expected %d roles, got %d", len(repos)*rolesPerRepo, len(p.Roles))
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
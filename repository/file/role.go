@@ -1,96 +1,47 @@
 package file
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
 )
 
 // GetRole with the given name and namespace.
 func (fr *FlatFileRepository) GetRole(name, namespace string) (*api.Role, error) {
-	fr.RLock()
-	defer fr.RUnlock()
-
-	p, err := fr.readPolicy()
+	p, err := fr.view()
 	if err != nil {
 		return nil, err
 	}
-
-	i := findRoleIndex(p.Roles, name, namespace)
-
-	if i >= 0 {
-		return &p.Roles[i], nil
-	}
-
-	return nil, fmt.Errorf("Role with name '%s' in namespace '%s' does not exist", name, namespace)
+	return p.GetRole(name, namespace)
 }
 
 // CreateRole the given role.
 func (fr *FlatFileRepository) CreateRole(role api.Role) error {
-	fr.Lock()
-	defer fr.Unlock()
-
-	p, err := fr.readPolicy()
-	if err != nil {
-		return err
-	}
-
-	i := findRoleIndex(p.Roles, role.Name, role.Namespace)
-	if i >= 0 {
-		return errors.New("Role already exists")
-	}
-
-	p.Roles = append(p.Roles, role)
-
-	return fr.writePolicy(p)
+	return fr.update("", fmt.Sprintf("Create role %s", repository.ObjectKey(role.Namespace, role.Name)), func(p *repository.Document) error {
+		return p.CreateRole(role)
+	})
 }
 
 // UpdateRole the given role.
 func (fr *FlatFileRepository) UpdateRole(role api.Role) error {
-	fr.Lock()
-	defer fr.Unlock()
-
-	p, err := fr.readPolicy()
-	if err != nil {
-		return err
-	}
-
-	i := findRoleIndex(p.Roles, role.Name, role.Namespace)
-	if i < 0 {
-		return fmt.Errorf("Attempting to update role that does not exist.")
-	}
-
-	p.Roles[i] = role
-
-	return fr.writePolicy(p)
+	return fr.update("", fmt.Sprintf("Update role %s", repository.ObjectKey(role.Namespace, role.Name)), func(p *repository.Document) error {
+		return p.UpdateRole(role)
+	})
 }
 
 // DeleteRole with the given name and namespace.
-func (fr *FlatFileRepository) DeleteRole(name, namespace string) error {
-	fr.Lock()
-	defer fr.Unlock()
-
-	p, err := fr.readPolicy()
-	if err != nil {
-		return err
-	}
-
-	i := findRoleIndex(p.Roles, name, namespace)
-	if i < 0 {
-		return fmt.Errorf("Attempting to delete role that does not exist.")
-	}
-
-	p.Roles = append(p.Roles[:i], p.Roles[i+1:]...)
-
-	return fr.writePolicy(p)
+func (fr *FlatFileRepository) DeleteRole(name, namespace, resourceVersion string) error {
+	return fr.update("", fmt.Sprintf("Delete role %s", repository.ObjectKey(namespace, name)), func(p *repository.Document) error {
+		return p.DeleteRole(name, namespace, resourceVersion)
+	})
 }
 
-func findRoleIndex(roles []api.Role, name, namespace string) int {
-	for i, r := range roles {
-		if r.Name == name && r.Namespace == namespace {
-			return i
-		}
+// ListRoles in the given namespace, or in all namespaces
+func (fr *FlatFileRepository) ListRoles(namespace string, opts repository.ListOptions) (*api.RoleList, error) {
+	p, err := fr.view()
+	if err != nil {
+		return nil, err
 	}
-	return -1
+	return p.ListRoles(namespace, opts)
 }
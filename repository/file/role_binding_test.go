@@ -12,12 +12,13 @@ var testRoleBinding = api.RoleBinding{
 	Name:      "name",
 	Namespace: "namespace",
 	Subjects:  []api.Subject{{Kind: "User", Name: "Bob"}},
-	RoleRef:   api.ObjectReference{Kind: "Role", Name: "admin", Namespace: "foo"},
+	RoleRef:   api.ObjectReference{Kind: "Role", Name: testRole.Name, Namespace: testRole.Namespace},
 }
 
+// createRepoWithRoleBinding creates a repository with the role binding and testRole, which
+// testRoleBinding references.
 func createRepoWithRoleBinding(r api.RoleBinding) (repository.PolicyRepository, error) {
-	file := getTestRepoFile()
-	repo, err := Create(file)
+	repo, err := createRepoWithRole(testRole)
 	if err != nil {
 		return nil, err
 	}
@@ -35,10 +36,16 @@ func TestCreateRoleBinding(t *testing.T) {
 
 	got, err := repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace)
 	if err != nil {
-		t.Errorf("Error getting role binding: %v", err)
+		t.Fatalf("Error getting role binding: %v", err)
 	}
 
-	if !reflect.DeepEqual(testRoleBinding, *got) {
+	if got.UID == "" || got.ResourceVersion == "" || got.CreationTimestamp == "" {
+		t.Errorf("Expected UID, resource version and creation timestamp to be set on created role binding, got %+v", got)
+	}
+
+	want := testRoleBinding
+	want.UID, want.ResourceVersion, want.CreationTimestamp = got.UID, got.ResourceVersion, got.CreationTimestamp
+	if !reflect.DeepEqual(want, *got) {
 		t.Errorf("Obtained role binding does not equal created role")
 	}
 
@@ -78,9 +85,10 @@ func TestUpdateRoleBinding(t *testing.T) {
 
 	got, err := repo.GetRoleBinding(rb.Name, rb.Namespace)
 	if err != nil {
-		t.Errorf("Error getting role binding: %v", err)
+		t.Fatalf("Error getting role binding: %v", err)
 	}
 
+	rb.UID, rb.ResourceVersion, rb.CreationTimestamp = got.UID, got.ResourceVersion, got.CreationTimestamp
 	if !reflect.DeepEqual(rb, *got) {
 		t.Errorf("Obtained role binding does not equal updated role binding")
 	}
@@ -118,7 +126,7 @@ func TestDeleteRoleBinding(t *testing.T) {
 	rbName := testRoleBinding.Name
 	rbNamespace := testRoleBinding.Namespace
 
-	if err = repo.DeleteRoleBinding(rbName, rbNamespace); err != nil {
+	if err = repo.DeleteRoleBinding(rbName, rbNamespace, ""); err != nil {
 		t.Errorf("Error deleting role binding: %v", err)
 	}
 
@@ -137,7 +145,7 @@ func TestDeleteNonExistentRoleBinding(t *testing.T) {
 		t.Fatalf("Error creating repo: %v", err)
 	}
 
-	if err = repo.DeleteRoleBinding("otherRoleBinding", "other"); err == nil {
+	if err = repo.DeleteRoleBinding("otherRoleBinding", "other", ""); err == nil {
 		t.Error("Expected error when deleting role binding that does not exist")
 	}
 
@@ -145,3 +153,118 @@ func TestDeleteNonExistentRoleBinding(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUpdateRoleBindingWithStaleResourceVersion(t *testing.T) {
+	repo, err := createRepoWithRoleBinding(testRoleBinding)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	first, err := repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role binding: %v", err)
+	}
+	second := *first
+
+	// Two writers read the same version. The first one to update wins.
+	first.Subjects = append(first.Subjects, api.Subject{Kind: "User", Name: "Alice"})
+	if err = repo.UpdateRoleBinding(*first); err != nil {
+		t.Fatalf("Error updating role binding: %v", err)
+	}
+
+	second.Subjects = append(second.Subjects, api.Subject{Kind: "User", Name: "Eve"})
+	err = repo.UpdateRoleBinding(second)
+	if _, ok := err.(*repository.ConflictError); !ok {
+		t.Errorf("Expected conflict error when updating role binding with stale resource version, got %v", err)
+	}
+
+	if err = repo.DeleteRoleBinding(second.Name, second.Namespace, second.ResourceVersion); err == nil {
+		t.Errorf("Expected error when deleting role binding with stale resource version")
+	}
+
+	got, err := repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role binding: %v", err)
+	}
+	if !reflect.DeepEqual(first.Subjects, got.Subjects) {
+		t.Errorf("Expected subjects %v, got %v", first.Subjects, got.Subjects)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListRoleBindings(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	bindings := []api.RoleBinding{
+		{Name: "b1", Namespace: "ns1", Labels: map[string]string{"team": "payments"}},
+		{Name: "b2", Namespace: "ns1", Labels: map[string]string{"team": "search"}},
+		{Name: "b3", Namespace: "ns2", Labels: map[string]string{"team": "payments"}},
+		{Name: "b4", Namespace: "ns2"},
+	}
+	for _, b := range bindings {
+		b.RoleRef = testRoleBinding.RoleRef
+		if err = repo.CreateRoleBinding(b); err != nil {
+			t.Fatalf("Error creating role binding: %v", err)
+		}
+	}
+
+	cases := []struct {
+		namespace string
+		selector  string
+		expected  []string
+	}{
+		{"ns1", "", []string{"b1", "b2"}},
+		{api.NamespaceAll, "", []string{"b1", "b2", "b3", "b4"}},
+		{api.NamespaceAll, "team=payments", []string{"b1", "b3"}},
+		{"ns2", "team=payments", []string{"b3"}},
+		{api.NamespaceAll, "!team", []string{"b4"}},
+		{"ns3", "", []string{}},
+	}
+	for i, c := range cases {
+		l, err := repo.ListRoleBindings(c.namespace, repository.ListOptions{LabelSelector: c.selector})
+		if err != nil {
+			t.Errorf("Case %d: error listing role bindings: %v", i, err)
+			continue
+		}
+		got := []string{}
+		for _, b := range l.Items {
+			got = append(got, b.Name)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Case %d: expected %v, got %v", i, c.expected, got)
+		}
+	}
+
+	// Page through all the bindings one at a time
+	got := []string{}
+	opts := repository.ListOptions{Limit: 1}
+	for {
+		l, err := repo.ListRoleBindings(api.NamespaceAll, opts)
+		if err != nil {
+			t.Fatalf("Error listing role bindings: %v", err)
+		}
+		if len(l.Items) > 1 {
+			t.Fatalf("Expected at most 1 item per page, got %d", len(l.Items))
+		}
+		for _, b := range l.Items {
+			got = append(got, b.Name)
+		}
+		if l.Continue == "" {
+			break
+		}
+		opts.Continue = l.Continue
+	}
+	if !reflect.DeepEqual(got, []string{"b1", "b2", "b3", "b4"}) {
+		t.Errorf("Expected to page through all bindings, got %v", got)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
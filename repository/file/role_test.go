@@ -1,6 +1,7 @@
 package file
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -29,10 +30,16 @@ func TestCreateRole(t *testing.T) {
 
 	got, err := repo.GetRole(testRole.Name, testRole.Namespace)
 	if err != nil {
-		t.Errorf("Error getting role: %v", err)
+		t.Fatalf("Error getting role: %v", err)
 	}
 
-	if !reflect.DeepEqual(testRole, *got) {
+	if got.UID == "" || got.ResourceVersion == "" || got.CreationTimestamp == "" {
+		t.Errorf("Expected UID, resource version and creation timestamp to be set on created role, got %+v", got)
+	}
+
+	want := testRole
+	want.UID, want.ResourceVersion, want.CreationTimestamp = got.UID, got.ResourceVersion, got.CreationTimestamp
+	if !reflect.DeepEqual(want, *got) {
 		t.Errorf("Obtained role does not equal created role")
 	}
 
@@ -47,8 +54,8 @@ func TestCreateExistingRole(t *testing.T) {
 		t.Fatalf("Error creating repo: %v", err)
 	}
 
-	if err = repo.CreateRole(testRole); err == nil {
-		t.Errorf("Expected error when creating role that already exists")
+	if err = repo.CreateRole(testRole); !errors.Is(err, repository.ErrAlreadyExists) {
+		t.Errorf("Expected already exists error when creating role that already exists, got %v", err)
 	}
 
 	if err = deleteRepo(); err != nil {
@@ -75,9 +82,10 @@ func TestUpdateRole(t *testing.T) {
 
 	got, err := repo.GetRole(r.Name, r.Namespace)
 	if err != nil {
-		t.Errorf("Error getting role: %v", err)
+		t.Fatalf("Error getting role: %v", err)
 	}
 
+	r.UID, r.ResourceVersion, r.CreationTimestamp = got.UID, got.ResourceVersion, got.CreationTimestamp
 	if !reflect.DeepEqual(r, *got) {
 		t.Errorf("Obtained role does not equal updated role")
 	}
@@ -97,8 +105,8 @@ func TestUpdateNonExistentRole(t *testing.T) {
 
 	r.Name = "otherRole"
 
-	if err := repo.UpdateRole(r); err == nil {
-		t.Error("Expected error when updating role that does not exist")
+	if err := repo.UpdateRole(r); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error when updating role that does not exist, got %v", err)
 	}
 
 	if err = deleteRepo(); err != nil {
@@ -112,12 +120,12 @@ func TestDeleteRole(t *testing.T) {
 		t.Fatalf("Error creating repo: %v", err)
 	}
 
-	if err = repo.DeleteRole(testRole.Name, testRole.Namespace); err != nil {
+	if err = repo.DeleteRole(testRole.Name, testRole.Namespace, ""); err != nil {
 		t.Errorf("Error deleting role: %v", err)
 	}
 
-	if _, err := repo.GetRole(testRole.Name, testRole.Namespace); err == nil {
-		t.Errorf("Did not get an error when getting a role that does not exist")
+	if _, err := repo.GetRole(testRole.Name, testRole.Namespace); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error when getting a role that does not exist, got %v", err)
 	}
 
 	if err = deleteRepo(); err != nil {
@@ -131,8 +139,92 @@ func TestDeleteNonExistentRole(t *testing.T) {
 		t.Fatalf("Error creating repo: %v", err)
 	}
 
-	if err = repo.DeleteRole("otherRole", testRole.Namespace); err == nil {
-		t.Error("Expected error when deleting role that does not exist")
+	if err = repo.DeleteRole("otherRole", testRole.Namespace, ""); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error when deleting role that does not exist, got %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateRoleWithResourceVersion(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	r, err := repo.GetRole(testRole.Name, testRole.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	created := *r
+
+	r.Rules = []api.PolicyRule{{Verbs: []string{"get"}}}
+	if err = repo.UpdateRole(*r); err != nil {
+		t.Fatalf("Error updating role: %v", err)
+	}
+
+	got, err := repo.GetRole(testRole.Name, testRole.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	if got.ResourceVersion == created.ResourceVersion {
+		t.Errorf("Expected resource version to change on update")
+	}
+	if got.UID != created.UID || got.CreationTimestamp != created.CreationTimestamp {
+		t.Errorf("Expected UID and creation timestamp to be preserved on update")
+	}
+
+	// Updating with the version that was read before the first update must fail
+	err = repo.UpdateRole(created)
+	if _, ok := err.(*repository.ConflictError); !ok {
+		t.Errorf("Expected conflict error when updating role with stale resource version, got %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteRoleWithStaleResourceVersion(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	r, err := repo.GetRole(testRole.Name, testRole.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role: %v", err)
+	}
+	if err = repo.UpdateRole(*r); err != nil {
+		t.Fatalf("Error updating role: %v", err)
+	}
+
+	err = repo.DeleteRole(r.Name, r.Namespace, r.ResourceVersion)
+	if _, ok := err.(*repository.ConflictError); !ok {
+		t.Errorf("Expected conflict error when deleting role with stale resource version, got %v", err)
+	}
+
+	if _, err = repo.GetRole(r.Name, r.Namespace); err != nil {
+		t.Errorf("Expected role to still exist after failed delete: %v", err)
+	}
+
+	if err = deleteRepo(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateInvalidRole(t *testing.T) {
+	repo, err := Create(getTestRepoFile())
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	r := testRole
+	r.Name = ""
+	if err = repo.CreateRole(r); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error when creating role without a name, got %v", err)
 	}
 
 	if err = deleteRepo(); err != nil {
@@ -161,5 +253,10 @@ func deleteRepo() error {
 	if err := os.Remove(f); err != nil {
 		return err
 	}
-	return nil
+	for _, aux := range []string{f + ".lock", f + ".bak", f + repository.SignatureSuffix, f + repository.SignatureSuffix + ".next"} {
+		if err := os.Remove(aux); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(f + ".history")
 }
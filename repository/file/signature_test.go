@@ -0,0 +1,254 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func loadTestKeys(t *testing.T) (*repository.TrustRoot, *repository.Signer, func()) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, pub, err := repository.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pem"), priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pub.pem"), pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	trustRoot, err := repository.LoadTrustRoot(filepath.Join(dir, "key.pub.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := repository.LoadSigner(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trustRoot, signer, func() { os.RemoveAll(dir) }
+}
+
+func TestSignedPolicy(t *testing.T) {
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+
+	repo, err := Create(getTestRepoFile())
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	defer deleteRepo()
+	fr := repo.(*FlatFileRepository)
+	fr.TrustRoot = trustRoot
+
+	// The policy was created unsigned
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error reading unsigned policy, got %v", err)
+	}
+	if err = repo.CreateRole(testRole); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error writing without a signing key, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteSignature(getTestRepoFile(), signer.Sign(data)); err != nil {
+		t.Fatalf("Error signing policy: %v", err)
+	}
+	fr.Signer = signer
+	if err = repo.CreateRole(testRole); err != nil {
+		t.Fatalf("Error creating role in signed policy: %v", err)
+	}
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); err != nil {
+		t.Errorf("Expected role from signed policy: %v", err)
+	}
+
+	// Tamper with the policy behind the repository's back
+	data, err = ioutil.ReadFile(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(getTestRepoFile(), append(data, ' '), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.GetRole(testRole.Name, testRole.Namespace); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error reading tampered policy, got %v", err)
+	}
+}
+
+// createSignedRepo returns a repository of a signed policy with the test role.
+func createSignedRepo(t *testing.T, trustRoot *repository.TrustRoot, signer *repository.Signer) *FlatFileRepository {
+	repo, err := Create(getTestRepoFile())
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	data, err := ioutil.ReadFile(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteSignature(getTestRepoFile(), signer.Sign(data)); err != nil {
+		t.Fatalf("Error signing policy: %v", err)
+	}
+	fr := repo.(*FlatFileRepository)
+	fr.TrustRoot, fr.Signer = trustRoot, signer
+	if err = fr.CreateRole(testRole); err != nil {
+		t.Fatalf("Error creating role: %v", err)
+	}
+	return fr
+}
+
+func TestConcurrentReadsOfSignedPolicy(t *testing.T) {
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+	writer := createSignedRepo(t, trustRoot, signer)
+	defer deleteRepo()
+	// The reader has its own mutex, as it would in another process
+	r, err := Create(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := r.(*FlatFileRepository)
+	reader.TrustRoot = trustRoot
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 50 && !t.Failed(); i++ {
+			role, err := writer.GetRole(testRole.Name, testRole.Namespace)
+			if err != nil {
+				t.Errorf("Error getting role: %v", err)
+				return
+			}
+			if err = writer.UpdateRole(*role); err != nil {
+				t.Errorf("Error updating role: %v", err)
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	for reads := 0; ; reads++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err = reader.GetRole(testRole.Name, testRole.Namespace); err != nil {
+			t.Errorf("Error reading the signed policy during a write after %d reads: %v", reads, err)
+			return
+		}
+	}
+}
+
+func TestSignedPolicyAfterInterruptedWrite(t *testing.T) {
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+	fr := createSignedRepo(t, trustRoot, signer)
+	defer deleteRepo()
+
+	// A writer that crashed between replacing the policy and its signature leaves the
+	// signature of the new policy next to the signature file
+	data, err := ioutil.ReadFile(fr.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	if err = writeSignature(fr.nextSignatureFile(), signer.Sign(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fr.File, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fr.GetRole(testRole.Name, testRole.Namespace); err != nil {
+		t.Errorf("Expected the policy to be verified with the signature of the interrupted write: %v", err)
+	}
+	if err = fr.DeleteRole(testRole.Name, testRole.Namespace, ""); err != nil {
+		t.Fatalf("Error writing after an interrupted write: %v", err)
+	}
+	if _, err = os.Stat(fr.nextSignatureFile()); !os.IsNotExist(err) {
+		t.Errorf("Expected the signature of the write to replace the signature file")
+	}
+}
+
+// snapshotFiles returns the content of the files of the repository and of its history.
+func snapshotFiles(t *testing.T, fr *FlatFileRepository) map[string]string {
+	files := map[string]string{}
+	for _, pattern := range []string{fr.File + "*", filepath.Join(fr.HistoryDir(), "*")} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err != nil || info.IsDir() {
+				continue
+			}
+			data, err := ioutil.ReadFile(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[m] = string(data)
+		}
+	}
+	return files
+}
+
+func TestRollbackOfSignedPolicy(t *testing.T) {
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+	fr := createSignedRepo(t, trustRoot, signer)
+	defer deleteRepo()
+	if err := fr.DeleteRole(testRole.Name, testRole.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+	revs, err := fr.History()
+	if err != nil || len(revs) != 3 {
+		t.Fatalf("Expected 3 revisions, got %+v, %v", revs, err)
+	}
+	unsigned, signed := revs[2], revs[1]
+
+	// A dry run writes nothing
+	before := snapshotFiles(t, fr)
+	if _, err = fr.Rollback(signed.ID, repository.ApplyOptions{DryRun: true}); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if after := snapshotFiles(t, fr); !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected a dry run to leave the files unchanged, got %v instead of %v", after, before)
+	}
+
+	if _, err = fr.Rollback(unsigned.ID, repository.ApplyOptions{}); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error rolling back to an unsigned revision, got %v", err)
+	}
+
+	// Tamper with the signed revision
+	rec, err := fr.readRevision(signed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Document.Roles[0].Rules = nil
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fr.revisionFile(signed.ID), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fr.Rollback(signed.ID, repository.ApplyOptions{}); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error rolling back to a tampered revision, got %v", err)
+	}
+	if _, err = fr.GetRole(testRole.Name, testRole.Namespace); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected the policy to be unchanged, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.WatchRepository = &FlatFileRepository{}
+var _ repository.SnapshotRepository = &FlatFileRepository{}
+var _ repository.RevisionRepository = &FlatFileRepository{}
+
+// Snapshot reads the policy file once, along with its revision.
+func (fr *FlatFileRepository) Snapshot() (*repository.Snapshot, error) {
+	p, err := fr.view()
+	if err != nil {
+		return nil, err
+	}
+	return p.Snapshot()
+}
+
+// CurrentRevision returns the revision of the policy file. The file is only read when its size,
+// modification time or identity changed since the last call, as Watch does.
+func (fr *FlatFileRepository) CurrentRevision() (string, error) {
+	info, err := os.Stat(fr.File)
+	if err != nil {
+		return "", fmt.Errorf("Error checking the role repo file: %v", err)
+	}
+
+	fr.revisionMu.Lock()
+	defer fr.revisionMu.Unlock()
+	if last := fr.revisionInfo; last != nil && os.SameFile(last, info) && last.Size() == info.Size() && last.ModTime().Equal(info.ModTime()) {
+		return fr.revision, nil
+	}
+	snap, err := fr.Snapshot()
+	if err != nil {
+		return "", err
+	}
+	fr.revisionInfo, fr.revision = info, snap.Revision
+	return fr.revision, nil
+}
+
+// Watch the policy file for changes, including those made by other processes. The file is
+// checked every WatchInterval, and only read when its size, modification time or identity
+// changed. Since writes replace the file with a rename, every write changes its identity.
+func (fr *FlatFileRepository) Watch(ctx context.Context) (<-chan repository.Event, error) {
+	interval := fr.WatchInterval
+	if interval <= 0 {
+		interval = repository.DefaultWatchInterval
+	}
+
+	var last os.FileInfo
+	return repository.PollWatch(ctx, interval, func() (*repository.Snapshot, error) {
+		info, err := os.Stat(fr.File)
+		if err != nil {
+			return nil, fmt.Errorf("Error checking the role repo file: %v", err)
+		}
+		if last != nil && os.SameFile(last, info) && last.Size() == info.Size() && last.ModTime().Equal(info.ModTime()) {
+			return nil, nil
+		}
+		snap, err := fr.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		last = info
+		return snap, nil
+	})
+}
@@ -0,0 +1,125 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestWatch(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	defer deleteRepo()
+
+	// A second repository on the same file, as another process would see it
+	other, err := Create(getTestRepoFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := other.(*FlatFileRepository)
+	fr.WatchInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := fr.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Error watching: %v", err)
+	}
+
+	e := nextEvent(t, ch)
+	if e.Type != repository.Added || e.Kind != api.RoleKind || e.Name != testRole.Name {
+		t.Errorf("Expected Added event for the existing role, got %+v", e)
+	}
+
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	e = nextEvent(t, ch)
+	if e.Type != repository.Added || e.Kind != api.RoleBindingKind {
+		t.Errorf("Expected Added event for the role binding, got %+v", e)
+	}
+	rb := e.Object.(api.RoleBinding)
+	if e.Revision != rb.ResourceVersion {
+		t.Errorf("Expected event revision %s to be the resource version of the write, got %s", rb.ResourceVersion, e.Revision)
+	}
+
+	if err = repo.DeleteRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role binding: %v", err)
+	}
+	e = nextEvent(t, ch)
+	if e.Type != repository.Deleted || e.Name != testRoleBinding.Name {
+		t.Errorf("Expected Deleted event for the role binding, got %+v", e)
+	}
+}
+
+func nextEvent(t *testing.T, ch <-chan repository.Event) repository.Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return repository.Event{}
+}
+
+func TestCurrentRevisionDoesNotReadUnchangedPolicy(t *testing.T) {
+	repo, err := createRepoWithRole(testRole)
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	defer deleteRepo()
+	fr := repo.(*FlatFileRepository)
+
+	rev, err := fr.CurrentRevision()
+	if err != nil {
+		t.Fatalf("Error reading the revision: %v", err)
+	}
+	if snap, err := fr.Snapshot(); err != nil || snap.Revision != rev {
+		t.Errorf("Expected the revision of the snapshot %s, got %+v, %v", rev, snap, err)
+	}
+
+	// Overwrite the policy in place, keeping its size and modification time, so that only a
+	// read of the policy notices
+	info, err := os.Stat(fr.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fr.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overwrite := func(b []byte) {
+		f, err := os.OpenFile(fr.File, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteAt(b, 0); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if err = os.Chtimes(fr.File, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overwrite(bytes.Repeat([]byte("x"), len(data)))
+	if got, err := fr.CurrentRevision(); err != nil || got != rev {
+		t.Errorf("Expected revision %s without reading the policy, got %s, %v", rev, got, err)
+	}
+	overwrite(data)
+
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	got, err := fr.CurrentRevision()
+	if err != nil || got == rev {
+		t.Errorf("Expected a new revision after a write, got %s, %v", got, err)
+	}
+}
@@ -0,0 +1,132 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.HistoryRepository = &Repository{}
+
+// History returns the most recent commits that changed the policy file, from the newest
+// to the oldest. The ID of each revision is the commit hash.
+func (r *Repository) History() ([]repository.Revision, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	head, err := r.resolve()
+	if err != nil || head == "" {
+		return []repository.Revision{}, err
+	}
+	args := []string{"rev-list"}
+	if r.HistoryLimit > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", r.HistoryLimit))
+	}
+	out, err := r.git(nil, nil, append(args, head, "--", r.Path)...)
+	if err != nil {
+		return nil, err
+	}
+
+	revs := []repository.Revision{}
+	for _, c := range strings.Fields(string(out)) {
+		rev, err := r.revision(c)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, *rev)
+	}
+	return revs, nil
+}
+
+// ShowRevision returns the policy as it was in the given commit.
+func (r *Repository) ShowRevision(id string) (*api.Policy, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	commit, err := r.resolveRevision(id)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := r.load(commit)
+	if err != nil {
+		return nil, err
+	}
+	return &doc.Policy, nil
+}
+
+// Rollback commits the policy of the given commit on top of the ref. With a trust root, the
+// policy of the commit must be signed by a trusted key.
+func (r *Repository) Rollback(id string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	author := opts.Author
+	if author == "" {
+		author = r.Author
+	}
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Rollback to revision %s", id)
+	}
+
+	// The target is signed again by the commit, so it must be trusted like the ref
+	r.Lock()
+	commit, err := r.resolveRevision(id)
+	var target *repository.Document
+	if err == nil {
+		target, err = r.loadTrusted(commit)
+	}
+	r.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []repository.Change
+	err = r.update(author, message, func(doc *repository.Document) error {
+		var err error
+		changes, err = doc.Apply(repository.RollbackPolicy(target.Policy), opts)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
+
+// revision describes the given commit as a revision of the policy. Callers must hold the lock.
+func (r *Repository) revision(commit string) (*repository.Revision, error) {
+	c, err := r.describe(commit)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := r.load(commit)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := repository.ContentHash(doc.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.Revision{
+		ID:        c.Hash,
+		Timestamp: c.Time,
+		Hash:      hash,
+		Author:    c.Author,
+		Message:   c.Message,
+	}, nil
+}
+
+// resolveRevision returns the commit named by the given revision ID. Callers must hold the lock.
+func (r *Repository) resolveRevision(id string) (string, error) {
+	// Refuse anything that git could take for an option
+	if id == "" || strings.HasPrefix(id, "-") {
+		return "", &repository.NotFoundError{Kind: repository.RevisionKind, Name: id}
+	}
+	out, err := r.git(nil, nil, "rev-parse", "--verify", "--quiet", id+"^{commit}")
+	if err != nil {
+		if exitErr, ok := err.(*gitError); ok && exitErr.code == 1 {
+			return "", &repository.NotFoundError{Kind: repository.RevisionKind, Name: id}
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
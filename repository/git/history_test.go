@@ -0,0 +1,62 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestHistoryAndRollback(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+
+	repo, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	createClusterRole(t, repo)
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	if err = repo.DeleteRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role binding: %v", err)
+	}
+
+	revs, err := repo.History()
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(revs) != 3 || revs[0].Message != "Delete role binding project1/admins" {
+		t.Fatalf("Expected 3 revisions with the newest first, got %+v", revs)
+	}
+	if revs[0].Hash == revs[1].Hash {
+		t.Errorf("Expected revisions to have different content hashes")
+	}
+
+	p, err := repo.ShowRevision(revs[1].ID)
+	if err != nil {
+		t.Fatalf("Error showing revision: %v", err)
+	}
+	if len(p.RoleBindings) != 1 {
+		t.Errorf("Expected revision to contain the role binding, got %+v", p)
+	}
+
+	if _, err = repo.Rollback(revs[1].ID, repository.ApplyOptions{}); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Errorf("Expected role binding to be restored: %v", err)
+	}
+	log := runGit(t, dir, "log", "-1", "--format=%s", DefaultRef)
+	if log != "Rollback to revision "+revs[1].ID+"\n" {
+		t.Errorf("Expected rollback to be committed, got %q", log)
+	}
+
+	for _, id := range []string{"0000000000000000000000000000000000000000", "--all"} {
+		if _, err = repo.ShowRevision(id); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Expected not found error for revision %q, got %v", id, err)
+		}
+	}
+}
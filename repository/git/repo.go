@@ -0,0 +1,630 @@
+// Package git implements a policy repository that is versioned in a git repository.
+//
+// The policy is stored as a policy file at a path within the tree of a ref. Every write made
+// through the repository becomes a commit on that ref, and changes pushed to the ref by other
+// means are picked up when it moves. The git command line tool must be installed.
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+const (
+	// DefaultRef is the ref used when none is given.
+	DefaultRef = "refs/heads/master"
+	// DefaultAuthor is the author of the commits made when no author is given.
+	DefaultAuthor = "kubernetes-rbac <kubernetes-rbac@localhost>"
+	// DefaultPollInterval is the default for how often the ref is checked for new commits.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultHistoryLimit is the default number of commits returned by History.
+	DefaultHistoryLimit = 10
+	// maxWriteAttempts is how many times a write is retried when the ref moves under it.
+	maxWriteAttempts = 5
+)
+
+// errNoChanges is returned by update functions to skip committing.
+var errNoChanges = errors.New("no changes")
+
+// Repository implements the repository interface on top of a git repository.
+type Repository struct {
+	sync.Mutex
+	// Dir is the path of the git working tree or bare repository.
+	Dir string
+	// Ref that holds the policy, e.g. "refs/heads/master".
+	Ref string
+	// Path of the policy file within the tree.
+	Path string
+	// Author of the commits made for writes that do not specify one, in the "Name <email>" format.
+	Author string
+	// PollInterval is how often reads check whether the ref has moved.
+	PollInterval time.Duration
+	// HistoryLimit is the maximum number of commits returned by History.
+	HistoryLimit int
+	// TrustRoot, when set, is used to verify the signature file that is committed next to the
+	// policy file whenever the policy of the ref is loaded. Unsigned and untrusted policies are
+	// refused. The policy of past commits, as shown by the history, is not verified.
+	TrustRoot *repository.TrustRoot
+	// Signer, when set, signs the policy file of every commit. It is required to write a policy
+	// that is verified with a trust root.
+	Signer *repository.Signer
+
+	// commit and doc cache the policy of the ref when it was last checked.
+	commit    string
+	doc       *repository.Document
+	lastCheck time.Time
+}
+
+// Commit describes a commit of the policy.
+type Commit struct {
+	// Hash of the commit.
+	Hash string `json:"hash"`
+	// Author of the commit, in the "Name <email>" format.
+	Author string `json:"author"`
+	// Time at which the commit was authored.
+	Time time.Time `json:"time"`
+	// Message of the commit.
+	Message string `json:"message"`
+}
+
+// Create returns a new Repository for the policy file at path in the given ref of the git
+// repository in dir. If the ref does not exist, the policy is empty until the first write.
+func Create(dir, ref, path string) (*Repository, error) {
+	if ref == "" {
+		ref = DefaultRef
+	}
+	r := &Repository{
+		Dir:          dir,
+		Ref:          ref,
+		Path:         path,
+		Author:       DefaultAuthor,
+		PollInterval: DefaultPollInterval,
+		HistoryLimit: DefaultHistoryLimit,
+	}
+	if _, err := r.git(nil, nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("Error opening git repository '%s': %v", dir, err)
+	}
+	return r, nil
+}
+
+// GetRole with the given name and namespace.
+func (r *Repository) GetRole(name, namespace string) (*api.Role, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.GetRole(name, namespace)
+}
+
+// CreateRole commits the given role.
+func (r *Repository) CreateRole(role api.Role) error {
+	return r.update(r.Author, fmt.Sprintf("Create role %s", repository.ObjectKey(role.Namespace, role.Name)), func(doc *repository.Document) error {
+		return doc.CreateRole(role)
+	})
+}
+
+// UpdateRole commits the given role.
+func (r *Repository) UpdateRole(role api.Role) error {
+	return r.update(r.Author, fmt.Sprintf("Update role %s", repository.ObjectKey(role.Namespace, role.Name)), func(doc *repository.Document) error {
+		return doc.UpdateRole(role)
+	})
+}
+
+// DeleteRole commits the removal of the role with the given name and namespace.
+func (r *Repository) DeleteRole(name, namespace, resourceVersion string) error {
+	return r.update(r.Author, fmt.Sprintf("Delete role %s", repository.ObjectKey(namespace, name)), func(doc *repository.Document) error {
+		return doc.DeleteRole(name, namespace, resourceVersion)
+	})
+}
+
+// ListRoles in the given namespace, or in all namespaces.
+func (r *Repository) ListRoles(namespace string, opts repository.ListOptions) (*api.RoleList, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.ListRoles(namespace, opts)
+}
+
+// GetRoleBinding with the given name and namespace.
+func (r *Repository) GetRoleBinding(name, namespace string) (*api.RoleBinding, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.GetRoleBinding(name, namespace)
+}
+
+// CreateRoleBinding commits the given role binding.
+func (r *Repository) CreateRoleBinding(rb api.RoleBinding) error {
+	return r.update(r.Author, fmt.Sprintf("Create role binding %s", repository.ObjectKey(rb.Namespace, rb.Name)), func(doc *repository.Document) error {
+		return doc.CreateRoleBinding(rb)
+	})
+}
+
+// UpdateRoleBinding commits the given role binding.
+func (r *Repository) UpdateRoleBinding(rb api.RoleBinding) error {
+	return r.update(r.Author, fmt.Sprintf("Update role binding %s", repository.ObjectKey(rb.Namespace, rb.Name)), func(doc *repository.Document) error {
+		return doc.UpdateRoleBinding(rb)
+	})
+}
+
+// DeleteRoleBinding commits the removal of the role binding with the given name and namespace.
+func (r *Repository) DeleteRoleBinding(name, namespace, resourceVersion string) error {
+	return r.update(r.Author, fmt.Sprintf("Delete role binding %s", repository.ObjectKey(namespace, name)), func(doc *repository.Document) error {
+		return doc.DeleteRoleBinding(name, namespace, resourceVersion)
+	})
+}
+
+// ListRoleBindings in the given namespace, or in all namespaces.
+func (r *Repository) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.ListRoleBindings(namespace, opts)
+}
+
+// GetClusterRole with the given name.
+func (r *Repository) GetClusterRole(name string) (*api.ClusterRole, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.GetClusterRole(name)
+}
+
+// ListClusterRoles that match the given options.
+func (r *Repository) ListClusterRoles(opts repository.ListOptions) (*api.ClusterRoleList, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.ListClusterRoles(opts)
+}
+
+// ListClusterRoleBindings that match the given options.
+func (r *Repository) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	r.Lock()
+	defer r.Unlock()
+	doc, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return doc.ListClusterRoleBindings(opts)
+}
+
+// Apply commits the given policy. The commit author and message are taken from the options
+// when they are set.
+func (r *Repository) Apply(desired api.Policy, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = "Apply policy"
+	}
+	return r.applyChange(message, opts, func(doc *repository.Document) ([]repository.Change, error) {
+		return doc.Apply(desired, opts)
+	})
+}
+
+var _ repository.CascadeRepository = &Repository{}
+
+// DeleteRoleCascade commits the removal of the role along with the role bindings that
+// reference it. The commit author and message are taken from the options when they are set.
+func (r *Repository) DeleteRoleCascade(name, namespace, resourceVersion string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Delete role %s and its role bindings", repository.ObjectKey(namespace, name))
+	}
+	return r.applyChange(message, opts, func(doc *repository.Document) ([]repository.Change, error) {
+		return doc.DeleteRoleCascade(name, namespace, resourceVersion, opts)
+	})
+}
+
+// PurgeNamespace commits the removal of all the roles and role bindings of the namespace. The
+// commit author and message are taken from the options when they are set.
+func (r *Repository) PurgeNamespace(namespace string, opts repository.ApplyOptions) ([]repository.Change, error) {
+	message := opts.Message
+	if message == "" {
+		message = fmt.Sprintf("Purge namespace %s", namespace)
+	}
+	return r.applyChange(message, opts, func(doc *repository.Document) ([]repository.Change, error) {
+		return doc.PurgeNamespace(namespace, opts)
+	})
+}
+
+// applyChange commits the result of fn, which applies a policy to the document, unless it
+// is a dry run or there are no changes.
+func (r *Repository) applyChange(message string, opts repository.ApplyOptions, fn func(doc *repository.Document) ([]repository.Change, error)) ([]repository.Change, error) {
+	author := opts.Author
+	if author == "" {
+		author = r.Author
+	}
+
+	var changes []repository.Change
+	err := r.update(author, message, func(doc *repository.Document) error {
+		var err error
+		changes, err = fn(doc)
+		if err == nil && (opts.DryRun || len(changes) == 0) {
+			return errNoChanges
+		}
+		return err
+	})
+	return changes, err
+}
+
+// IntroducedBy returns the commit that introduced the object of the given kind, name and
+// namespace in its current incarnation, that is, the oldest commit since which it has
+// existed without interruption.
+func (r *Repository) IntroducedBy(kind, name, namespace string) (*Commit, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	head, err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if head == "" {
+		return nil, &repository.NotFoundError{Kind: kind, Name: name, Namespace: namespace}
+	}
+
+	out, err := r.git(nil, nil, "rev-list", head, "--", r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	introduced := ""
+	for _, c := range strings.Fields(string(out)) {
+		doc, err := r.load(c)
+		if err != nil {
+			return nil, err
+		}
+		exists, err := contains(doc, kind, name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			break
+		}
+		introduced = c
+	}
+	if introduced == "" {
+		return nil, &repository.NotFoundError{Kind: kind, Name: name, Namespace: namespace}
+	}
+	return r.describe(introduced)
+}
+
+func contains(doc *repository.Document, kind, name, namespace string) (bool, error) {
+	switch kind {
+	case api.RoleKind:
+		_, err := doc.GetRole(name, namespace)
+		return err == nil, nil
+	case api.RoleBindingKind:
+		_, err := doc.GetRoleBinding(name, namespace)
+		return err == nil, nil
+	case api.ClusterRoleKind:
+		_, err := doc.GetClusterRole(name)
+		return err == nil, nil
+	case api.ClusterRoleBindingKind:
+		for _, crb := range doc.ClusterRoleBindings {
+			if crb.Name == name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, &repository.InvalidError{Kind: kind, Name: name, Namespace: namespace, Reason: "unknown kind"}
+}
+
+// current returns the policy of the ref, reloading it if the ref has moved since it
+// was last checked. Callers must hold the lock.
+func (r *Repository) current() (*repository.Document, error) {
+	if r.doc != nil && time.Since(r.lastCheck) < r.PollInterval {
+		return r.doc, nil
+	}
+
+	commit, err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if r.doc == nil || commit != r.commit {
+		doc, err := r.loadTrusted(commit)
+		if err != nil {
+			return nil, err
+		}
+		r.commit, r.doc = commit, doc
+	}
+	r.lastCheck = time.Now()
+	return r.doc, nil
+}
+
+// update commits the result of modifying the policy at the tip of the ref with fn. If
+// another writer moves the ref in the meantime, the update is retried on top of it.
+func (r *Repository) update(author, message string, fn func(doc *repository.Document) error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.checkNotCheckedOut(); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxWriteAttempts; attempt++ {
+		parent, err := r.resolve()
+		if err != nil {
+			return err
+		}
+		doc, err := r.loadTrusted(parent)
+		if err != nil {
+			return err
+		}
+		if err = fn(doc); err == errNoChanges {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		commit, err := r.commitDocument(doc, parent, author, message)
+		if err != nil {
+			return err
+		}
+
+		// update-ref only moves the ref if it still points to the parent
+		if _, err = r.git(nil, nil, "update-ref", "-m", message, r.Ref, commit, parent); err != nil {
+			if head, rerr := r.resolve(); rerr == nil && head != parent {
+				continue
+			}
+			return err
+		}
+		r.commit, r.doc, r.lastCheck = commit, doc, time.Now()
+		return nil
+	}
+	return fmt.Errorf("Error updating ref '%s': it was modified concurrently %d times", r.Ref, maxWriteAttempts)
+}
+
+// checkNotCheckedOut returns an error if the ref is the branch checked out in the working tree
+// of the repository. Commits move the ref without updating the index and the working tree, so
+// the next commit made in the working tree would revert them.
+func (r *Repository) checkNotCheckedOut() error {
+	bare, err := r.gitString(nil, nil, "rev-parse", "--is-bare-repository")
+	if err != nil || bare == "true" {
+		return err
+	}
+	head, err := r.gitString(nil, nil, "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		// A detached HEAD is not a branch
+		if exitErr, ok := err.(*gitError); ok && exitErr.code == 1 {
+			return nil
+		}
+		return err
+	}
+	if head == r.Ref {
+		return fmt.Errorf("Refusing to write to '%s', which is checked out in the working tree of '%s': use a bare repository or another ref", r.Ref, r.Dir)
+	}
+	return nil
+}
+
+// commitDocument creates a commit with the given document as its policy file, without
+// touching the working tree or the index of the repository.
+func (r *Repository) commitDocument(doc *repository.Document, parent, author, message string) (string, error) {
+	if r.TrustRoot != nil && r.Signer == nil {
+		return "", &repository.UntrustedError{Source: r.Path, Reason: "a signing key is required to write a signed policy"}
+	}
+
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	blob, err := r.gitString(nil, data, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+
+	// Build the tree in a temporary index, so that concurrent users of the repository's
+	// own index are not disturbed.
+	index, err := ioutil.TempFile("", "kubernetes-rbac-index")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	if parent == "" {
+		_, err = r.git(env, nil, "read-tree", "--empty")
+	} else {
+		_, err = r.git(env, nil, "read-tree", parent)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err = r.git(env, nil, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+r.Path); err != nil {
+		return "", err
+	}
+	if r.Signer != nil {
+		sigBlob, err := r.gitString(nil, r.Signer.Sign(data), "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		if _, err = r.git(env, nil, "update-index", "--add", "--cacheinfo", "100644,"+sigBlob+","+r.Path+repository.SignatureSuffix); err != nil {
+			return "", err
+		}
+	} else {
+		// The signature of the parent does not match the new policy. A zero mode removes the
+		// entry, which unlike --force-remove also works in bare repositories.
+		info := "0 0000000000000000000000000000000000000000\t" + r.Path + repository.SignatureSuffix + "\n"
+		if _, err = r.git(env, []byte(info), "update-index", "--index-info"); err != nil {
+			return "", err
+		}
+	}
+	tree, err := r.gitString(env, nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	name, email := parseAuthor(author)
+	env = []string{
+		"GIT_AUTHOR_NAME=" + name,
+		"GIT_AUTHOR_EMAIL=" + email,
+		"GIT_COMMITTER_NAME=" + name,
+		"GIT_COMMITTER_EMAIL=" + email,
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	return r.gitString(env, nil, args...)
+}
+
+// resolve returns the commit the ref points to, or the empty string if it does not exist.
+func (r *Repository) resolve() (string, error) {
+	out, err := r.git(nil, nil, "rev-parse", "--verify", "--quiet", r.Ref+"^{commit}")
+	if err != nil {
+		if exitErr, ok := err.(*gitError); ok && exitErr.code == 1 {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// load reads the policy file of the given commit. The policy is empty if the commit is
+// empty or does not contain the policy file.
+func (r *Repository) load(commit string) (*repository.Document, error) {
+	return r.loadFile(commit, false)
+}
+
+// loadTrusted reads the policy file of the given commit like load, and verifies its
+// signature if the repository has a trust root.
+func (r *Repository) loadTrusted(commit string) (*repository.Document, error) {
+	return r.loadFile(commit, r.TrustRoot != nil)
+}
+
+func (r *Repository) loadFile(commit string, verify bool) (*repository.Document, error) {
+	doc := &repository.Document{}
+	if commit == "" {
+		return doc, nil
+	}
+	data, err := r.readBlob(commit, r.Path)
+	if err != nil || data == nil {
+		return doc, err
+	}
+	if verify {
+		sig, err := r.readBlob(commit, r.Path+repository.SignatureSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.TrustRoot.Verify(r.Path+" in commit "+commit, data, sig); err != nil {
+			return nil, err
+		}
+	}
+	if err = json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling policy file '%s' of commit %s: %v", r.Path, commit, err)
+	}
+	return doc, nil
+}
+
+// readBlob returns the content of the file at path in the given commit, or nil if the
+// commit does not contain it.
+func (r *Repository) readBlob(commit, path string) ([]byte, error) {
+	files, err := r.git(nil, nil, "ls-tree", "--name-only", commit, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(files)) == 0 {
+		return nil, nil
+	}
+	return r.git(nil, nil, "cat-file", "blob", commit+":"+path)
+}
+
+// describe returns the details of the given commit.
+func (r *Repository) describe(commit string) (*Commit, error) {
+	out, err := r.git(nil, nil, "show", "-s", "--format=%H%x00%an <%ae>%x00%aI%x00%B", commit)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(string(out), "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("Unexpected output describing commit %s: %q", commit, out)
+	}
+	t, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return nil, err
+	}
+	return &Commit{
+		Hash:    fields[0],
+		Author:  fields[1],
+		Time:    t,
+		Message: strings.TrimSpace(fields[3]),
+	}, nil
+}
+
+// gitError is returned when a git command fails.
+type gitError struct {
+	args   []string
+	code   int
+	stderr string
+}
+
+func (e *gitError) Error() string {
+	return fmt.Sprintf("git %s failed with exit code %d: %s", strings.Join(e.args, " "), e.code, e.stderr)
+}
+
+// git runs a git command in the repository directory and returns its output.
+func (r *Repository) git(env []string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, &gitError{args: args, code: exitErr.ExitCode(), stderr: strings.TrimSpace(stderr.String())}
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitString runs a git command and returns its output without surrounding whitespace.
+func (r *Repository) gitString(env []string, stdin []byte, args ...string) (string, error) {
+	out, err := r.git(env, stdin, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// parseAuthor splits an author in the "Name <email>" format.
+func parseAuthor(author string) (name, email string) {
+	i := strings.Index(author, "<")
+	j := strings.LastIndex(author, ">")
+	if i < 0 || j < i {
+		return strings.TrimSpace(author), ""
+	}
+	return strings.TrimSpace(author[:i]), strings.TrimSpace(author[i+1 : j])
+}
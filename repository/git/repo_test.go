@@ -0,0 +1,216 @@
+package git
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var testRoleBinding = api.RoleBinding{
+	Name:      "admins",
+	Namespace: "project1",
+	Subjects:  []api.Subject{{Kind: api.UserKind, Name: "alice"}},
+	RoleRef:   api.ObjectReference{Kind: api.ClusterRoleKind, Name: "admin"},
+}
+
+// testClusterRole is referenced by testRoleBinding.
+var testClusterRole = api.ClusterRole{Name: "admin", Rules: []api.PolicyRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}}}
+
+// createClusterRole commits testClusterRole, so that testRoleBinding can be created.
+func createClusterRole(t *testing.T, repo *Repository) {
+	if _, err := repo.Apply(api.Policy{ClusterRoles: []api.ClusterRole{testClusterRole}}, repository.ApplyOptions{Message: "Add admin cluster role"}); err != nil {
+		t.Fatalf("Error creating cluster role: %v", err)
+	}
+}
+
+// createTestRepo initializes a git repository in a temporary directory.
+func createTestRepo(t *testing.T, bare bool) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"init", "-q"}
+	if bare {
+		args = append(args, "--bare")
+	}
+	runGit(t, dir, append(args, dir)...)
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %v: %s", args, err, out)
+	}
+	return string(out)
+}
+
+func TestWritesBecomeCommits(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+
+	repo, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	if _, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error on empty repository, got %v", err)
+	}
+
+	createClusterRole(t, repo)
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	rb, err := repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting role binding: %v", err)
+	}
+
+	rb.Subjects = append(rb.Subjects, api.Subject{Kind: api.UserKind, Name: "bob"})
+	if err = repo.UpdateRoleBinding(*rb); err != nil {
+		t.Fatalf("Error updating role binding: %v", err)
+	}
+
+	if rb, err = repo.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Fatalf("Error getting role binding: %v", err)
+	}
+	rb.Subjects = append(rb.Subjects, api.Subject{Kind: api.UserKind, Name: "carol"})
+	_, err = repo.Apply(api.Policy{RoleBindings: []api.RoleBinding{*rb}, ClusterRoles: []api.ClusterRole{testClusterRole}}, repository.ApplyOptions{Author: "Jane Doe <jane@example.com>", Message: "Add carol to the admins"})
+	if err != nil {
+		t.Fatalf("Error applying policy: %v", err)
+	}
+
+	log := runGit(t, dir, "log", "--format=%an|%s", DefaultRef)
+	expected := "Jane Doe|Add carol to the admins\nkubernetes-rbac|Update role binding project1/admins\nkubernetes-rbac|Create role binding project1/admins\nkubernetes-rbac|Add admin cluster role\n"
+	if log != expected {
+		t.Errorf("Expected log:\n%s\ngot:\n%s", expected, log)
+	}
+
+	c, err := repo.IntroducedBy(api.RoleBindingKind, testRoleBinding.Name, testRoleBinding.Namespace)
+	if err != nil {
+		t.Fatalf("Error finding commit that introduced the role binding: %v", err)
+	}
+	if c.Message != "Create role binding project1/admins" || c.Author != "kubernetes-rbac <kubernetes-rbac@localhost>" {
+		t.Errorf("Unexpected commit introducing the role binding: %+v", c)
+	}
+
+	if _, err = repo.IntroducedBy(api.RoleBindingKind, "other", "project1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected not found error for binding that does not exist, got %v", err)
+	}
+}
+
+func TestReloadWhenRefMoves(t *testing.T) {
+	dir := createTestRepo(t, false)
+	defer os.RemoveAll(dir)
+
+	// Commit a policy file in the working tree
+	policy := `{"RoleBindings": [{"name": "admins", "namespace": "project1", "subjects": [{"kind": "User", "name": "alice"}], "roleRef": {"kind": "ClusterRole", "name": "admin"}}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "policy.json"), []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "policy.json")
+	runGit(t, dir, "commit", "-q", "-m", "Initial policy")
+	runGit(t, dir, "branch", "-M", "master")
+
+	repo, err := Create(dir, DefaultRef, "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	repo.PollInterval = 0
+
+	l, err := repo.ListRoleBindings("project1", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing role bindings: %v", err)
+	}
+	if len(l.Items) != 1 {
+		t.Fatalf("Expected 1 role binding, got %d", len(l.Items))
+	}
+
+	// Move the ref by committing outside of the repository
+	runGit(t, dir, "rm", "-q", "policy.json")
+	runGit(t, dir, "commit", "-q", "-m", "Remove policy")
+
+	l, err = repo.ListRoleBindings("project1", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing role bindings: %v", err)
+	}
+	if len(l.Items) != 0 {
+		t.Errorf("Expected the policy to be reloaded when the ref moved, got %d role bindings", len(l.Items))
+	}
+}
+
+func TestWritesToCheckedOutRef(t *testing.T) {
+	dir := createTestRepo(t, false)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "policy.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", "policy.json")
+	runGit(t, dir, "commit", "-q", "-m", "Initial policy")
+	runGit(t, dir, "branch", "-M", "master")
+
+	repo, err := Create(dir, DefaultRef, "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	if err = repo.CreateRole(api.Role{Name: "admin", Namespace: "project1"}); err == nil || !strings.Contains(err.Error(), "checked out") {
+		t.Errorf("Expected error writing to the checked out branch, got %v", err)
+	}
+
+	// Another ref of the working tree can be written
+	other, err := Create(dir, "refs/heads/policy", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	if err = other.CreateRole(api.Role{Name: "admin", Namespace: "project1"}); err != nil {
+		t.Errorf("Error writing to a ref that is not checked out: %v", err)
+	}
+	if status := runGit(t, dir, "status", "--porcelain"); status != "" {
+		t.Errorf("Expected the index and the working tree to be unchanged, got %q", status)
+	}
+}
+
+func TestConcurrentWritersRetry(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+
+	first, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	second, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+
+	if err = first.CreateRole(api.Role{Name: "one", Namespace: "ns"}); err != nil {
+		t.Fatalf("Error creating role: %v", err)
+	}
+	// The second repository has a stale view of the ref, but its write must not clobber the first one
+	if err = second.CreateRole(api.Role{Name: "two", Namespace: "ns"}); err != nil {
+		t.Fatalf("Error creating role: %v", err)
+	}
+
+	first.PollInterval = 0
+	l, err := first.ListRoles("ns", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing roles: %v", err)
+	}
+	if len(l.Items) != 2 {
+		t.Errorf("Expected 2 roles, got %v", l.Items)
+	}
+}
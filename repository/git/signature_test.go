@@ -0,0 +1,120 @@
+package git
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func loadTestKeys(t *testing.T) (*repository.TrustRoot, *repository.Signer, func()) {
+	priv, pub, err := repository.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ioutil.TempDir("", "kubernetes-rbac-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(keys, "key.pem"), priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(keys, "key.pub.pem"), pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := repository.LoadSigner(filepath.Join(keys, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustRoot, err := repository.LoadTrustRoot(filepath.Join(keys, "key.pub.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trustRoot, signer, func() { os.RemoveAll(keys) }
+}
+
+func TestSignedCommits(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+
+	writer, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	writer.TrustRoot, writer.Signer = trustRoot, signer
+	createClusterRole(t, writer)
+	if err = writer.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	if files := runGit(t, dir, "ls-tree", "--name-only", DefaultRef); files != "policy.json\npolicy.json.sig\n" {
+		t.Errorf("Expected the signature to be committed with the policy, got %q", files)
+	}
+
+	reader, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	reader.TrustRoot = trustRoot
+	if _, err = reader.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Errorf("Expected role binding from signed commit: %v", err)
+	}
+	if err = reader.DeleteRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace, ""); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error writing without a signing key, got %v", err)
+	}
+
+	// An unsigned commit, as made by a writer without the key
+	unsigned, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	if err = unsigned.DeleteRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace, ""); err != nil {
+		t.Fatalf("Error deleting role binding: %v", err)
+	}
+	reader.PollInterval = 0
+	if _, err = reader.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error reading unsigned commit, got %v", err)
+	}
+}
+
+func TestRollbackToUntrustedCommit(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+	trustRoot, signer, cleanup := loadTestKeys(t)
+	defer cleanup()
+
+	// The policy starts unsigned, and is signed from the second commit
+	unsigned, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	createClusterRole(t, unsigned)
+	history, err := unsigned.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	signing.Signer = signer
+	if err = signing.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+
+	writer, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	writer.TrustRoot, writer.Signer = trustRoot, signer
+	if _, err = writer.Rollback(history[0].ID, repository.ApplyOptions{}); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error rolling back to an unsigned commit, got %v", err)
+	}
+	if _, err = writer.GetRoleBinding(testRoleBinding.Name, testRoleBinding.Namespace); err != nil {
+		t.Errorf("Expected the policy to be unchanged: %v", err)
+	}
+}
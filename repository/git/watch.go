@@ -0,0 +1,69 @@
+package git
+
+import (
+	"context"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var _ repository.WatchRepository = &Repository{}
+var _ repository.SnapshotRepository = &Repository{}
+var _ repository.RevisionRepository = &Repository{}
+
+// Snapshot returns the policy at the tip of the ref. Its revision is the commit.
+func (r *Repository) Snapshot() (*repository.Snapshot, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	commit, err := r.resolve()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := r.loadTrusted(commit)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.Snapshot{Policy: doc.Policy, Revision: commit}, nil
+}
+
+// CurrentRevision returns the commit of the policy being served. Like the other reads, it only
+// checks whether the ref moved every PollInterval.
+func (r *Repository) CurrentRevision() (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, err := r.current(); err != nil {
+		return "", err
+	}
+	return r.commit, nil
+}
+
+// Watch the ref for new commits every PollInterval. The revision of the events is the
+// commit in which the change was observed. When the ref moves by several commits at once,
+// the events describe the difference between the old and the new tip.
+func (r *Repository) Watch(ctx context.Context) (<-chan repository.Event, error) {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	first, last := true, ""
+	return repository.PollWatch(ctx, interval, func() (*repository.Snapshot, error) {
+		r.Lock()
+		defer r.Unlock()
+
+		commit, err := r.resolve()
+		if err != nil {
+			return nil, err
+		}
+		if !first && commit == last {
+			return nil, nil
+		}
+		doc, err := r.loadTrusted(commit)
+		if err != nil {
+			return nil, err
+		}
+		first, last = false, commit
+		return &repository.Snapshot{Policy: doc.Policy, Revision: commit}, nil
+	})
+}
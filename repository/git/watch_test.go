@@ -0,0 +1,50 @@
+package git
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+func TestWatch(t *testing.T) {
+	dir := createTestRepo(t, true)
+	defer os.RemoveAll(dir)
+
+	repo, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	createClusterRole(t, repo)
+	watcher, err := Create(dir, "", "policy.json")
+	if err != nil {
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	watcher.PollInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Error watching: %v", err)
+	}
+	if e := <-ch; e.Type != repository.Added || e.Kind != api.ClusterRoleKind {
+		t.Errorf("Expected Added event for the existing cluster role, got %+v", e)
+	}
+
+	if err = repo.CreateRoleBinding(testRoleBinding); err != nil {
+		t.Fatalf("Error creating role binding: %v", err)
+	}
+	select {
+	case e := <-ch:
+		head := runGit(t, dir, "rev-parse", DefaultRef)
+		if e.Type != repository.Added || e.Name != testRoleBinding.Name || e.Revision+"\n" != head {
+			t.Errorf("Expected Added event for the role binding at commit %s, got %+v", head, e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// RevisionKind is the kind reported in errors about policy revisions.
+const RevisionKind = "Revision"
+
+// Revision describes a revision of the policy kept by a repository.
+type Revision struct {
+	// ID of the revision, as accepted by ShowRevision and Rollback.
+	ID string `json:"revision"`
+	// Timestamp at which the revision was written.
+	Timestamp time.Time `json:"timestamp"`
+	// Hash of the policy content of the revision. See ContentHash.
+	Hash string `json:"hash"`
+	// Author of the revision, in the "Name <email>" format, if known.
+	Author string `json:"author,omitempty"`
+	// Message describing the revision, if any.
+	Message string `json:"message,omitempty"`
+}
+
+// HistoryRepository is implemented by repositories that keep past revisions of the policy.
+type HistoryRepository interface {
+	// History returns the revisions that are kept, from the newest to the oldest.
+	History() ([]Revision, error)
+	// ShowRevision returns the policy as it was in the given revision.
+	ShowRevision(id string) (*api.Policy, error)
+	// Rollback restores the policy of the given revision. The rollback is recorded
+	// as a new revision, so it can itself be rolled back.
+	Rollback(id string, opts ApplyOptions) ([]Change, error)
+}
+
+// ContentHash returns the hex encoded SHA-256 hash of the policy content. Policies that
+// are equal, including their metadata, have the same hash regardless of how they are stored.
+func ContentHash(p api.Policy) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// RollbackPolicy returns the policy to apply in order to roll back to the given one. The
+// resource versions of its objects are cleared, as they are expected to be out of date.
+func RollbackPolicy(p api.Policy) api.Policy {
+	result := api.Policy{
+		Roles:               make([]api.Role, len(p.Roles)),
+		RoleBindings:        make([]api.RoleBinding, len(p.RoleBindings)),
+		ClusterRoles:        make([]api.ClusterRole, len(p.ClusterRoles)),
+		ClusterRoleBindings: make([]api.ClusterRoleBinding, len(p.ClusterRoleBindings)),
+	}
+	for i, r := range p.Roles {
+		r.ResourceVersion = ""
+		result.Roles[i] = r
+	}
+	for i, rb := range p.RoleBindings {
+		rb.ResourceVersion = ""
+		result.RoleBindings[i] = rb
+	}
+	for i, cr := range p.ClusterRoles {
+		cr.ResourceVersion = ""
+		result.ClusterRoles[i] = cr
+	}
+	for i, crb := range p.ClusterRoleBindings {
+		crb.ResourceVersion = ""
+		result.ClusterRoleBindings[i] = crb
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// CascadeRepository is implemented by repositories that can delete roles along with the
+// objects that depend on them.
+type CascadeRepository interface {
+	// DeleteRoleCascade deletes the role with the given name and namespace, along with the role
+	// bindings that reference it, in a single write. If resourceVersion is not empty and does
+	// not match the stored one, a ConflictError is returned.
+	DeleteRoleCascade(name, namespace, resourceVersion string, opts ApplyOptions) ([]Change, error)
+	// PurgeNamespace deletes all the roles and role bindings of the namespace in a single
+	// write, e.g. once the namespace is deleted from the cluster.
+	PurgeNamespace(namespace string, opts ApplyOptions) ([]Change, error)
+}
+
+// ValidateRoleRef returns an InvalidError if the role referenced by the binding of the given
+// kind, name and namespace does not exist in the repository.
+func ValidateRoleRef(repo PolicyRepository, kind, name, namespace string, ref api.ObjectReference) error {
+	var err error
+	switch ref.Kind {
+	case api.RoleKind:
+		_, err = repo.GetRole(ref.Name, ref.Namespace)
+	case api.ClusterRoleKind:
+		_, err = repo.GetClusterRole(ref.Name)
+	default:
+		return &InvalidError{Kind: kind, Name: name, Namespace: namespace, Reason: fmt.Sprintf("unknown role reference kind '%s'", ref.Kind)}
+	}
+	if errors.Is(err, ErrNotFound) {
+		return &InvalidError{Kind: kind, Name: name, Namespace: namespace, Reason: fmt.Sprintf("the referenced %s does not exist", describe(ref.Kind, ref.Name, ref.Namespace))}
+	}
+	return err
+}
+
+// ValidateReferences returns an InvalidError for the first binding of the policy that
+// references a role or cluster role that the policy does not define.
+func ValidateReferences(p api.Policy) error {
+	d := &Document{Policy: p}
+	for _, rb := range p.RoleBindings {
+		if err := ValidateRoleRef(d, api.RoleBindingKind, rb.Name, rb.Namespace, rb.RoleRef); err != nil {
+			return err
+		}
+	}
+	for _, crb := range p.ClusterRoleBindings {
+		if err := ValidateRoleRef(d, api.ClusterRoleBindingKind, crb.Name, "", crb.RoleRef); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckUnreferenced returns a ReferencedError if bindings of the policy reference the role
+// or cluster role.
+func CheckUnreferenced(p api.Policy, ref api.ObjectReference) error {
+	referencedBy := []string{}
+	for _, rb := range p.RoleBindings {
+		if references(rb.RoleRef, ref) {
+			referencedBy = append(referencedBy, describe(api.RoleBindingKind, rb.Name, rb.Namespace))
+		}
+	}
+	for _, crb := range p.ClusterRoleBindings {
+		if references(crb.RoleRef, ref) {
+			referencedBy = append(referencedBy, describe(api.ClusterRoleBindingKind, crb.Name, ""))
+		}
+	}
+	if len(referencedBy) > 0 {
+		return &ReferencedError{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace, ReferencedBy: referencedBy}
+	}
+	return nil
+}
+
+// CascadePolicy returns the policy without the given role and the role bindings that
+// reference it. Cluster role bindings, which cannot reference roles, are kept.
+func CascadePolicy(p api.Policy, name, namespace string) api.Policy {
+	ref := api.ObjectReference{Kind: api.RoleKind, Name: name, Namespace: namespace}
+	result := api.Policy{ClusterRoles: p.ClusterRoles, ClusterRoleBindings: p.ClusterRoleBindings}
+	for _, r := range p.Roles {
+		if r.Name != name || r.Namespace != namespace {
+			result.Roles = append(result.Roles, r)
+		}
+	}
+	for _, rb := range p.RoleBindings {
+		if !references(rb.RoleRef, ref) {
+			result.RoleBindings = append(result.RoleBindings, rb)
+		}
+	}
+	return result
+}
+
+// PurgeNamespacePolicy returns the policy without the roles and role bindings of the namespace.
+func PurgeNamespacePolicy(p api.Policy, namespace string) api.Policy {
+	result := api.Policy{ClusterRoles: p.ClusterRoles, ClusterRoleBindings: p.ClusterRoleBindings}
+	for _, r := range p.Roles {
+		if r.Namespace != namespace {
+			result.Roles = append(result.Roles, r)
+		}
+	}
+	for _, rb := range p.RoleBindings {
+		if rb.Namespace != namespace {
+			result.RoleBindings = append(result.RoleBindings, rb)
+		}
+	}
+	return result
+}
+
+// references reports whether the role reference of a binding points to the given role.
+// References to cluster roles ignore the namespace, which has no meaning for them.
+func references(roleRef, ref api.ObjectReference) bool {
+	if roleRef.Kind != ref.Kind || roleRef.Name != ref.Name {
+		return false
+	}
+	return ref.Kind == api.ClusterRoleKind || roleRef.Namespace == ref.Namespace
+}
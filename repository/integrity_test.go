@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+func integrityDocument(t *testing.T) *Document {
+	d := &Document{}
+	_, err := d.Apply(api.Policy{
+		Roles: []api.Role{
+			{Name: "admin", Namespace: "ns1"},
+			{Name: "view", Namespace: "ns1"},
+			{Name: "admin", Namespace: "ns2"},
+		},
+		RoleBindings: []api.RoleBinding{
+			{Name: "admins", Namespace: "ns1", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin", Namespace: "ns1"}},
+			{Name: "cross", Namespace: "ns2", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "admin", Namespace: "ns1"}},
+			{Name: "viewers", Namespace: "ns1", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "view", Namespace: "ns1"}},
+			{Name: "cluster-viewers", Namespace: "ns2", RoleRef: api.ObjectReference{Kind: api.ClusterRoleKind, Name: "view"}},
+		},
+		ClusterRoles: []api.ClusterRole{{Name: "view"}},
+	}, ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error creating document: %v", err)
+	}
+	return d
+}
+
+func TestDanglingReferencesAreRejected(t *testing.T) {
+	d := integrityDocument(t)
+
+	missingRole := api.RoleBinding{Name: "b", Namespace: "ns1", RoleRef: api.ObjectReference{Kind: api.RoleKind, Name: "edit", Namespace: "ns1"}}
+	if err := d.CreateRoleBinding(missingRole); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected invalid error creating binding to a missing role, got %v", err)
+	}
+	missingClusterRole := api.RoleBinding{Name: "admins", Namespace: "ns1", RoleRef: api.ObjectReference{Kind: api.ClusterRoleKind, Name: "edit"}}
+	if err := d.UpdateRoleBinding(missingClusterRole); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected invalid error updating binding to a missing cluster role, got %v", err)
+	}
+
+	err := d.DeleteRole("admin", "ns1", "")
+	var refErr *ReferencedError
+	if !errors.As(err, &refErr) {
+		t.Fatalf("Expected referenced error deleting a role in use, got %v", err)
+	}
+	expected := []string{"RoleBinding 'admins' in namespace 'ns1'", "RoleBinding 'cross' in namespace 'ns2'"}
+	if !reflect.DeepEqual(refErr.ReferencedBy, expected) {
+		t.Errorf("Expected role to be referenced by %v, got %v", expected, refErr.ReferencedBy)
+	}
+	if HTTPStatusCode(err) != 409 {
+		t.Errorf("Expected referenced error to be a conflict, got %d", HTTPStatusCode(err))
+	}
+
+	p := d.Policy
+	p.ClusterRoles = nil
+	if _, err = d.Apply(p, ApplyOptions{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected invalid error applying a policy with dangling references, got %v", err)
+	}
+
+	d.AllowDanglingReferences = true
+	if err = d.CreateRoleBinding(missingRole); err != nil {
+		t.Errorf("Expected dangling reference to be allowed: %v", err)
+	}
+	if err = d.DeleteRole("admin", "ns1", ""); err != nil {
+		t.Errorf("Expected deleting a role in use to be allowed: %v", err)
+	}
+}
+
+func TestDeleteRoleCascade(t *testing.T) {
+	d := integrityDocument(t)
+	rv := d.ResourceVersion
+
+	changes, err := d.DeleteRoleCascade("admin", "ns1", "", ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+	expected := []Change{
+		{Type: ChangeDelete, Kind: api.RoleKind, Name: "admin", Namespace: "ns1"},
+		{Type: ChangeDelete, Kind: api.RoleBindingKind, Name: "admins", Namespace: "ns1"},
+		{Type: ChangeDelete, Kind: api.RoleBindingKind, Name: "cross", Namespace: "ns2"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+	if d.ResourceVersion != rv || len(d.Roles) != 3 {
+		t.Errorf("Expected dry run to leave the document untouched")
+	}
+
+	if _, err = d.DeleteRoleCascade("admin", "ns1", "1000", ApplyOptions{}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected conflict error with a stale resource version, got %v", err)
+	}
+	if _, err = d.DeleteRoleCascade("edit", "ns1", "", ApplyOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected not found error deleting a missing role, got %v", err)
+	}
+
+	if _, err = d.DeleteRoleCascade("admin", "ns1", "", ApplyOptions{}); err != nil {
+		t.Fatalf("Error deleting role: %v", err)
+	}
+	if d.ResourceVersion != rv+1 {
+		t.Errorf("Expected a single revision, resource version went from %d to %d", rv, d.ResourceVersion)
+	}
+	if _, err = d.GetRoleBinding("viewers", "ns1"); err != nil {
+		t.Errorf("Expected unrelated binding to be kept: %v", err)
+	}
+}
+
+func TestPurgeNamespace(t *testing.T) {
+	d := integrityDocument(t)
+
+	changes, err := d.PurgeNamespace("ns2", ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error purging namespace: %v", err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("Expected the role and both bindings of ns2 to be deleted, got %v", changes)
+	}
+	roles, _ := d.ListRoles("ns2", ListOptions{})
+	bindings, _ := d.ListRoleBindings("ns2", ListOptions{})
+	if len(roles.Items) != 0 || len(bindings.Items) != 0 {
+		t.Errorf("Expected namespace to be empty, got %v and %v", roles.Items, bindings.Items)
+	}
+	if len(d.ClusterRoles) != 1 {
+		t.Errorf("Expected cluster roles to be kept")
+	}
+
+	// The binding in ns2 referenced a role in ns1, which can now be purged
+	if _, err = d.PurgeNamespace("ns1", ApplyOptions{}); err != nil {
+		t.Fatalf("Error purging namespace: %v", err)
+	}
+	if changes, err = d.PurgeNamespace("ns1", ApplyOptions{}); err != nil || len(changes) != 0 {
+		t.Errorf("Expected purging an empty namespace to change nothing, got %v, %v", changes, err)
+	}
+}
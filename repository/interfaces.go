@@ -2,12 +2,29 @@ package repository
 
 import "github.com/kismatic/kubernetes-rbac/api"
 
-// PolicyRepository provides access to the persisted policy objects
+// PolicyRepository provides access to the persisted policy objects.
+//
+// Implementations assign a UID, CreationTimestamp and ResourceVersion to objects when they
+// are created, and a new ResourceVersion every time they are updated.
+//
+// Writes keep the policy referentially intact: creating or updating a binding that references
+// a role that does not exist returns an InvalidError, and deleting a role that bindings still
+// reference returns a ReferencedError.
+//
+// Errors that relate to the objects themselves match ErrNotFound, ErrAlreadyExists,
+// ErrConflict, ErrInvalid or ErrReferenced when tested with errors.Is. Any other error indicates that
+// the repository could not be accessed.
 type PolicyRepository interface {
 	RoleBindingRepository
 	RoleRepository
 	ClusterRoleRepository
 	ClusterRoleBindingRepository
+
+	// Apply replaces the whole policy with the given one, creating, updating and deleting
+	// objects as needed. Either all the changes are persisted or none of them are. The
+	// changes are returned in both cases, and are not persisted if the options specify a
+	// dry run.
+	Apply(p api.Policy, opts ApplyOptions) ([]Change, error)
 }
 
 // RoleRepository provides access to persisted roles.
@@ -16,10 +33,15 @@ type RoleRepository interface {
 	GetRole(name, namespace string) (*api.Role, error)
 	// Create the given role.
 	CreateRole(api.Role) error
-	// Update the given role.
+	// Update the given role. If the role specifies a ResourceVersion that does not match
+	// the stored one, a ConflictError is returned.
 	UpdateRole(api.Role) error
-	// Delete the role with the given name and namespace.
-	DeleteRole(name, namespace string) error
+	// Delete the role with the given name and namespace. If resourceVersion is not empty
+	// and does not match the stored one, a ConflictError is returned.
+	DeleteRole(name, namespace, resourceVersion string) error
+
+	// ListRoles in the given namespace, or in all namespaces if namespace is api.NamespaceAll.
+	ListRoles(namespace string, opts ListOptions) (*api.RoleList, error)
 }
 
 // RoleBindingRepository provides access to persisted role bindings.
@@ -28,22 +50,28 @@ type RoleBindingRepository interface {
 	GetRoleBinding(name, namespace string) (*api.RoleBinding, error)
 	// Create the given role binding.
 	CreateRoleBinding(api.RoleBinding) error
-	// Update the given role binding.
+	// Update the given role binding. If the role binding specifies a ResourceVersion that
+	// does not match the stored one, a ConflictError is returned.
 	UpdateRoleBinding(api.RoleBinding) error
-	// Delete the role binding with the given name and namespace.
-	DeleteRoleBinding(name, namespace string) error
+	// Delete the role binding with the given name and namespace. If resourceVersion is not
+	// empty and does not match the stored one, a ConflictError is returned.
+	DeleteRoleBinding(name, namespace, resourceVersion string) error
 
-	// ListRoleBindings in the given namespace
-	ListRoleBindings(namespace string) ([]api.RoleBinding, error)
+	// ListRoleBindings in the given namespace, or in all namespaces if namespace is api.NamespaceAll.
+	ListRoleBindings(namespace string, opts ListOptions) (*api.RoleBindingList, error)
 }
 
 // ClusterRoleRepository provides access to persisted cluster roles.
 type ClusterRoleRepository interface {
-	// Get the role with the given name in the given namespace.
+	// Get the cluster role with the given name.
 	GetClusterRole(name string) (*api.ClusterRole, error)
+
+	// ListClusterRoles that match the given options.
+	ListClusterRoles(opts ListOptions) (*api.ClusterRoleList, error)
 }
 
 // ClusterRoleBindingRepository provides access to persisted cluster role bindings.
 type ClusterRoleBindingRepository interface {
-	ListClusterRoleBindings() ([]api.ClusterRoleBinding, error)
+	// ListClusterRoleBindings that match the given options.
+	ListClusterRoleBindings(opts ListOptions) (*api.ClusterRoleBindingList, error)
 }
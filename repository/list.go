@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"encoding/base64"
+	"sort"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// ListOptions narrows down and pages the objects returned by list calls.
+type ListOptions struct {
+	// LabelSelector restricts the list to the objects whose labels match it,
+	// e.g. "team=payments,env!=dev". See ParseSelector for the syntax.
+	LabelSelector string
+	// Limit is the maximum number of objects to return. Zero means no limit.
+	Limit int
+	// Continue is the token returned by a previous list call, used to get the next page.
+	Continue string
+}
+
+// ObjectKey returns the key that identifies an object, and by which list results are sorted.
+// Cluster-scoped objects have an empty namespace.
+func ObjectKey(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// Page returns the bounds of the page described by the options over a list of n objects,
+// sorted by key, along with the continue token for the next page. The token is empty when
+// the page reaches the end of the list. An InvalidError is returned if the continue token
+// is malformed.
+func Page(n int, key func(i int) string, opts ListOptions) (start, end int, next string, err error) {
+	if opts.Continue != "" {
+		last, err := base64.RawURLEncoding.DecodeString(opts.Continue)
+		if err != nil {
+			return 0, 0, "", &InvalidError{Kind: "ListOptions", Name: opts.Continue, Reason: "malformed continue token"}
+		}
+		// Resume after the last object of the previous page. Keys are used rather than
+		// offsets so that writes between calls do not skip or repeat objects.
+		start = sort.Search(n, func(i int) bool { return key(i) > string(last) })
+	}
+	end = n
+	if opts.Limit > 0 && start+opts.Limit < n {
+		end = start + opts.Limit
+		next = base64.RawURLEncoding.EncodeToString([]byte(key(end - 1)))
+	}
+	return start, end, next, nil
+}
+
+// ReadPolicy returns the whole policy of a repository, by listing the objects of every kind.
+func ReadPolicy(repo PolicyRepository) (*api.Policy, error) {
+	roles, err := repo.ListRoles(api.NamespaceAll, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := repo.ListRoleBindings(api.NamespaceAll, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	clusterRoles, err := repo.ListClusterRoles(ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	clusterBindings, err := repo.ListClusterRoleBindings(ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &api.Policy{
+		Roles:               roles.Items,
+		RoleBindings:        bindings.Items,
+		ClusterRoles:        clusterRoles.Items,
+		ClusterRoleBindings: clusterBindings.Items,
+	}, nil
+}
@@ -0,0 +1,386 @@
+// Package remote implements a read-only policy repository that is synced from a policy bundle
+// published at an HTTPS URL.
+//
+// The bundle is a policy in the format of the RBAC policy file. It is polled with conditional
+// requests, so that it is only downloaded when its ETag changes, and it is validated before it
+// replaces the policy being served. When the bundle cannot be fetched or is invalid, the last
+// good bundle keeps being served.
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/metrics"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+const (
+	// DefaultPollInterval is the default for how often the bundle is polled.
+	DefaultPollInterval = 30 * time.Second
+	// DefaultTimeout is the default for how long fetching the bundle or its signature may take.
+	DefaultTimeout = 30 * time.Second
+	// MaxBundleSize is the size above which bundles are rejected.
+	MaxBundleSize = 32 << 20
+	// readOnlyReason is reported when writing to the repository.
+	readOnlyReason = "the policy is synced from a remote bundle and is read-only"
+)
+
+// Repository serves the policy of the last good bundle fetched from URL.
+type Repository struct {
+	// URL of the bundle. It must use the https scheme.
+	URL string
+	// Client used to fetch the bundle.
+	Client *http.Client
+	// PollInterval is how often Run fetches the bundle.
+	PollInterval time.Duration
+	// TrustRoot, when set, is used to verify the detached signature of every bundle before it is
+	// swapped in. Unsigned and untrusted bundles are refused.
+	TrustRoot *repository.TrustRoot
+	// SignatureURL is the URL of the signature of the bundle. Defaults to the URL of the bundle
+	// followed by repository.SignatureSuffix.
+	SignatureURL string
+
+	mu       sync.RWMutex
+	doc      *repository.Document
+	etag     string
+	revision string
+	lastSync time.Time
+	lastErr  error
+	// syncs and failures count the syncs that succeeded and failed.
+	syncs    uint64
+	failures uint64
+}
+
+var _ repository.PolicyRepository = &Repository{}
+var _ repository.WatchRepository = &Repository{}
+var _ repository.SnapshotRepository = &Repository{}
+var _ repository.RevisionRepository = &Repository{}
+
+// Status describes the synchronization of the bundle.
+type Status struct {
+	// Revision of the bundle being served, which is its content hash. Empty until the
+	// first successful sync.
+	Revision string `json:"revision"`
+	// ETag of the bundle being served, if the server provided one.
+	ETag string `json:"etag,omitempty"`
+	// LastSync is the time of the last successful sync, including syncs that found the
+	// bundle unchanged.
+	LastSync time.Time `json:"lastSync"`
+	// Age is the time elapsed since the last successful sync.
+	Age time.Duration `json:"-"`
+	// LastError is the error of the last sync, if it failed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// NewClient returns a client for fetching bundles, which trusts the given CAs, or the CAs of
+// the system when nil. Requests that take longer than the timeout fail, so that a server that
+// stops responding cannot stall the syncs.
+func NewClient(rootCAs *x509.CertPool, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			TLSClientConfig:       &tls.Config{RootCAs: rootCAs},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// Create returns a Repository for the bundle at the given URL. The policy is empty until
+// the first successful Sync. If client is nil, a client from NewClient with the system CAs and
+// DefaultTimeout is used.
+func Create(bundleURL string, client *http.Client) (*Repository, error) {
+	u, err := url.Parse(bundleURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid policy bundle URL '%s': %v", bundleURL, err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid policy bundle URL '%s': the https scheme is required", bundleURL)
+	}
+	if client == nil {
+		client = NewClient(nil, DefaultTimeout)
+	}
+	return &Repository{
+		URL:          bundleURL,
+		Client:       client,
+		PollInterval: DefaultPollInterval,
+	}, nil
+}
+
+// Run syncs the bundle every PollInterval until the context is done. Errors are logged,
+// and the last good bundle keeps being served.
+func (r *Repository) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := r.Sync(); err != nil {
+			log.Printf("Error syncing policy bundle, serving bundle synced %v ago: %v", r.Status().Age, err)
+		}
+	}
+}
+
+// Sync fetches the bundle if it changed, and swaps it in once it is validated.
+func (r *Repository) Sync() error {
+	err := r.fetch()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+	if err == nil {
+		r.lastSync = time.Now()
+		r.syncs++
+	} else {
+		r.failures++
+	}
+	return err
+}
+
+// RegisterMetrics registers the metrics of the synchronization of the bundle with the registry.
+func (r *Repository) RegisterMetrics(reg *metrics.Registry) {
+	reg.Register(
+		metrics.NewCounterFunc("kubernetes_rbac_policy_syncs_total", "Number of syncs of the policy bundle.", []string{"result"}, func() []metrics.Sample {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return []metrics.Sample{{LabelValues: []string{"success"}, Value: float64(r.syncs)}, {LabelValues: []string{"failure"}, Value: float64(r.failures)}}
+		}),
+		metrics.NewGaugeFunc("kubernetes_rbac_policy_last_sync_timestamp_seconds", "Time of the last successful sync of the policy bundle.", nil, func() []metrics.Sample {
+			if s := r.Status(); !s.LastSync.IsZero() {
+				return metrics.Value(float64(s.LastSync.Unix()))
+			}
+			return nil
+		}),
+	)
+}
+
+// Status returns the synchronization status of the bundle.
+func (r *Repository) Status() Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := Status{
+		Revision: r.revision,
+		ETag:     r.etag,
+		LastSync: r.lastSync,
+	}
+	if !r.lastSync.IsZero() {
+		s.Age = time.Since(r.lastSync)
+	}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	return s
+}
+
+func (r *Repository) fetch() error {
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	etag := r.etag
+	r.mu.RUnlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error fetching policy bundle: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("Error fetching policy bundle: unexpected status %s", resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxBundleSize+1))
+	if err != nil {
+		return fmt.Errorf("Error reading policy bundle: %v", err)
+	}
+	if len(data) > MaxBundleSize {
+		return fmt.Errorf("Policy bundle is larger than %d bytes", MaxBundleSize)
+	}
+	if r.TrustRoot != nil {
+		if err = r.verify(data); err != nil {
+			return err
+		}
+	}
+	doc := &repository.Document{}
+	if err = json.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("Error unmarshalling policy bundle: %v", err)
+	}
+	if err = repository.ValidatePolicy(doc.Policy); err != nil {
+		return fmt.Errorf("Policy bundle is invalid: %w", err)
+	}
+	hash, err := repository.ContentHash(doc.Policy)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.doc, r.etag, r.revision = doc, resp.Header.Get("ETag"), hash
+	return nil
+}
+
+// verify fetches the signature of the bundle and checks it with the trust root.
+func (r *Repository) verify(data []byte) error {
+	sigURL := r.SignatureURL
+	if sigURL == "" {
+		sigURL = r.URL + repository.SignatureSuffix
+	}
+	resp, err := r.Client.Get(sigURL)
+	if err != nil {
+		return fmt.Errorf("Error fetching policy bundle signature: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var sig []byte
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Signatures are a single line of base64
+		if sig, err = ioutil.ReadAll(io.LimitReader(resp.Body, 1024)); err != nil {
+			return fmt.Errorf("Error reading policy bundle signature: %v", err)
+		}
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("Error fetching policy bundle signature: unexpected status %s", resp.Status)
+	}
+	return r.TrustRoot.Verify(r.URL, data, sig)
+}
+
+// current returns the policy being served. Swapped documents are never modified,
+// so the result can be used without holding the lock.
+func (r *Repository) current() *repository.Document {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.doc == nil {
+		return &repository.Document{}
+	}
+	return r.doc
+}
+
+// GetRole with the given name and namespace.
+func (r *Repository) GetRole(name, namespace string) (*api.Role, error) {
+	return r.current().GetRole(name, namespace)
+}
+
+// CreateRole is not supported, the policy is read-only.
+func (r *Repository) CreateRole(role api.Role) error {
+	return &repository.InvalidError{Kind: api.RoleKind, Name: role.Name, Namespace: role.Namespace, Reason: readOnlyReason}
+}
+
+// UpdateRole is not supported, the policy is read-only.
+func (r *Repository) UpdateRole(role api.Role) error {
+	return &repository.InvalidError{Kind: api.RoleKind, Name: role.Name, Namespace: role.Namespace, Reason: readOnlyReason}
+}
+
+// DeleteRole is not supported, the policy is read-only.
+func (r *Repository) DeleteRole(name, namespace, resourceVersion string) error {
+	return &repository.InvalidError{Kind: api.RoleKind, Name: name, Namespace: namespace, Reason: readOnlyReason}
+}
+
+// ListRoles in the given namespace, or in all namespaces.
+func (r *Repository) ListRoles(namespace string, opts repository.ListOptions) (*api.RoleList, error) {
+	return r.current().ListRoles(namespace, opts)
+}
+
+// GetRoleBinding with the given name and namespace.
+func (r *Repository) GetRoleBinding(name, namespace string) (*api.RoleBinding, error) {
+	return r.current().GetRoleBinding(name, namespace)
+}
+
+// CreateRoleBinding is not supported, the policy is read-only.
+func (r *Repository) CreateRoleBinding(rb api.RoleBinding) error {
+	return &repository.InvalidError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace, Reason: readOnlyReason}
+}
+
+// UpdateRoleBinding is not supported, the policy is read-only.
+func (r *Repository) UpdateRoleBinding(rb api.RoleBinding) error {
+	return &repository.InvalidError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace, Reason: readOnlyReason}
+}
+
+// DeleteRoleBinding is not supported, the policy is read-only.
+func (r *Repository) DeleteRoleBinding(name, namespace, resourceVersion string) error {
+	return &repository.InvalidError{Kind: api.RoleBindingKind, Name: name, Namespace: namespace, Reason: readOnlyReason}
+}
+
+// ListRoleBindings in the given namespace, or in all namespaces.
+func (r *Repository) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
+	return r.current().ListRoleBindings(namespace, opts)
+}
+
+// GetClusterRole with the given name.
+func (r *Repository) GetClusterRole(name string) (*api.ClusterRole, error) {
+	return r.current().GetClusterRole(name)
+}
+
+// ListClusterRoles that match the given options.
+func (r *Repository) ListClusterRoles(opts repository.ListOptions) (*api.ClusterRoleList, error) {
+	return r.current().ListClusterRoles(opts)
+}
+
+// ListClusterRoleBindings that match the given options.
+func (r *Repository) ListClusterRoleBindings(opts repository.ListOptions) (*api.ClusterRoleBindingList, error) {
+	return r.current().ListClusterRoleBindings(opts)
+}
+
+// Apply is not supported, the policy is read-only.
+func (r *Repository) Apply(desired api.Policy, opts repository.ApplyOptions) ([]repository.Change, error) {
+	return nil, &repository.InvalidError{Kind: "Policy", Name: r.URL, Reason: readOnlyReason}
+}
+
+// Snapshot returns the policy being served. Its revision is the content hash of the bundle,
+// and is empty until the first successful sync.
+func (r *Repository) Snapshot() (*repository.Snapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.doc == nil {
+		return &repository.Snapshot{}, nil
+	}
+	return &repository.Snapshot{Policy: r.doc.Policy, Revision: r.revision}, nil
+}
+
+// CurrentRevision returns the content hash of the bundle being served.
+func (r *Repository) CurrentRevision() (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.revision, nil
+}
+
+// Watch the policy being served for changes, which happen when a new bundle is synced.
+// The revision of the events is the content hash of the bundle.
+func (r *Repository) Watch(ctx context.Context) (<-chan repository.Event, error) {
+	last := ""
+	return repository.PollWatch(ctx, repository.DefaultWatchInterval, func() (*repository.Snapshot, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if r.revision == last {
+			return nil, nil
+		}
+		last = r.revision
+		return &repository.Snapshot{Policy: r.doc.Policy, Revision: r.revision}, nil
+	})
+}
@@ -0,0 +1,252 @@
+package remote
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// bundleServer serves a policy bundle with an ETag.
+type bundleServer struct {
+	sync.Mutex
+	bundle   string
+	etag     string
+	down     bool
+	requests int
+	notMod   int
+}
+
+func (s *bundleServer) set(bundle, etag string) {
+	s.Lock()
+	defer s.Unlock()
+	s.bundle, s.etag = bundle, etag
+}
+
+func (s *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	w.Write([]byte(s.bundle))
+}
+
+const bundleV1 = `{"ClusterRoles": [{"name": "view", "rules": [{"verbs": ["get"]}]}]}`
+const bundleV2 = `{"ClusterRoles": [{"name": "edit", "rules": [{"verbs": ["get", "update"]}]}]}`
+
+func newTestRepo(t *testing.T, bs *bundleServer) (*Repository, func()) {
+	server := httptest.NewTLSServer(bs)
+	repo, err := Create(server.URL+"/policy.json", server.Client())
+	if err != nil {
+		server.Close()
+		t.Fatalf("Error creating repo: %v", err)
+	}
+	return repo, server.Close
+}
+
+func TestSyncWithETag(t *testing.T) {
+	bs := &bundleServer{bundle: bundleV1, etag: `"v1"`}
+	repo, done := newTestRepo(t, bs)
+	defer done()
+
+	if _, err := repo.GetClusterRole("view"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Expected empty policy before the first sync, got %v", err)
+	}
+
+	if err := repo.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if _, err := repo.GetClusterRole("view"); err != nil {
+		t.Errorf("Expected cluster role from the bundle: %v", err)
+	}
+	status := repo.Status()
+	if status.ETag != `"v1"` || status.Revision == "" || status.LastSync.IsZero() || status.LastError != "" {
+		t.Errorf("Unexpected status after sync: %+v", status)
+	}
+
+	if err := repo.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if bs.notMod != 1 {
+		t.Errorf("Expected unchanged bundle to be answered with 304, got %d of %d requests", bs.notMod, bs.requests)
+	}
+
+	bs.set(bundleV2, `"v2"`)
+	if err := repo.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if _, err := repo.GetClusterRole("edit"); err != nil {
+		t.Errorf("Expected cluster role from the new bundle: %v", err)
+	}
+	if repo.Status().Revision == status.Revision {
+		t.Errorf("Expected revision to change with the bundle")
+	}
+}
+
+func TestKeepsLastGoodBundle(t *testing.T) {
+	bs := &bundleServer{bundle: bundleV1, etag: `"v1"`}
+	repo, done := newTestRepo(t, bs)
+	defer done()
+
+	if err := repo.Sync(); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	synced := repo.Status().LastSync
+
+	tests := []struct {
+		name   string
+		bundle string
+		down   bool
+	}{
+		{name: "unreachable", down: true},
+		{name: "malformed", bundle: `{"ClusterRoles": [`},
+		{name: "invalid", bundle: `{"ClusterRoles": [{"name": ""}]}`},
+	}
+	for i, test := range tests {
+		bs.Lock()
+		bs.down, bs.bundle, bs.etag = test.down, test.bundle, string(rune('a'+i))
+		bs.Unlock()
+
+		if err := repo.Sync(); err == nil {
+			t.Errorf("%s: expected sync error", test.name)
+		}
+		if _, err := repo.GetClusterRole("view"); err != nil {
+			t.Errorf("%s: expected last good bundle to be served: %v", test.name, err)
+		}
+		status := repo.Status()
+		if status.LastError == "" || !status.LastSync.Equal(synced) || status.ETag != `"v1"` {
+			t.Errorf("%s: unexpected status: %+v", test.name, status)
+		}
+	}
+}
+
+func TestSyncTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	roots := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	repo, err := Create(server.URL+"/policy.json", NewClient(roots, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	synced := make(chan error, 1)
+	go func() { synced <- repo.Sync() }()
+	select {
+	case err = <-synced:
+		if err == nil {
+			t.Errorf("Expected the sync of an unresponsive server to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the sync of an unresponsive server to time out")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	repo, err := Create("https://example.com/policy.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.CreateRole(api.Role{Name: "r", Namespace: "ns"}); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error writing to remote bundle, got %v", err)
+	}
+	if _, err = repo.Apply(api.Policy{}, repository.ApplyOptions{}); !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("Expected invalid error applying to remote bundle, got %v", err)
+	}
+}
+
+func TestRequiresHTTPS(t *testing.T) {
+	if _, err := Create("http://example.com/policy.json", nil); err == nil {
+		t.Errorf("Expected error for bundle URL without https")
+	}
+}
+
+func TestSignedBundle(t *testing.T) {
+	priv, pub, err := repository.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pem"), priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pub.pem"), pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := repository.LoadSigner(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustRoot, err := repository.LoadTrustRoot(filepath.Join(dir, "key.pub.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	bundle, sig := bundleV1, signer.Sign([]byte(bundleV1))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/policy.json":
+			w.Write([]byte(bundle))
+		case "/policy.json" + repository.SignatureSuffix:
+			if sig == nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(sig)
+		}
+	}))
+	defer server.Close()
+
+	repo, err := Create(server.URL+"/policy.json", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.TrustRoot = trustRoot
+	if err = repo.Sync(); err != nil {
+		t.Fatalf("Error syncing signed bundle: %v", err)
+	}
+
+	mu.Lock()
+	bundle = bundleV2
+	mu.Unlock()
+	if err = repo.Sync(); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error for bundle that does not match its signature, got %v", err)
+	}
+
+	mu.Lock()
+	sig = nil
+	mu.Unlock()
+	if err = repo.Sync(); !errors.Is(err, repository.ErrUntrusted) {
+		t.Errorf("Expected untrusted error for unsigned bundle, got %v", err)
+	}
+	if _, err = repo.GetClusterRole("view"); err != nil {
+		t.Errorf("Expected last trusted bundle to be served: %v", err)
+	}
+}
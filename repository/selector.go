@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type selectorOperator int
+
+const (
+	opEquals selectorOperator = iota
+	opNotEquals
+	opIn
+	opNotIn
+	opExists
+	opDoesNotExist
+)
+
+type requirement struct {
+	key      string
+	operator selectorOperator
+	values   []string
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	switch r.operator {
+	case opEquals:
+		return ok && v == r.values[0]
+	case opNotEquals:
+		return !ok || v != r.values[0]
+	case opIn:
+		return ok && containsString(r.values, v)
+	case opNotIn:
+		return !ok || !containsString(r.values, v)
+	case opExists:
+		return ok
+	case opDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// Selector selects objects based on their labels. The empty selector matches all objects.
+type Selector []requirement
+
+// Matches returns true if the labels satisfy all the requirements of the selector.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+var setRequirementRegexp = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// ParseSelector parses a label selector using the same syntax as Kubernetes. Requirements are
+// separated by commas, and all of them must be satisfied:
+//
+//	key=value, key==value, key!=value
+//	key in (value1,value2), key notin (value1,value2)
+//	key, !key
+//
+// An InvalidError is returned if the selector cannot be parsed.
+func ParseSelector(selector string) (Selector, error) {
+	s := Selector{}
+	for _, term := range splitSelector(selector) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, &InvalidError{Kind: "LabelSelector", Name: selector, Reason: err.Error()}
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+func parseRequirement(term string) (requirement, error) {
+	if m := setRequirementRegexp.FindStringSubmatch(term); m != nil {
+		r := requirement{key: m[1], operator: opIn}
+		if m[2] == "notin" {
+			r.operator = opNotIn
+		}
+		for _, v := range strings.Split(m[3], ",") {
+			r.values = append(r.values, strings.TrimSpace(v))
+		}
+		return r, validateLabelKey(r.key)
+	}
+
+	var r requirement
+	switch {
+	case strings.HasPrefix(term, "!"):
+		r = requirement{key: strings.TrimSpace(term[1:]), operator: opDoesNotExist}
+	case strings.Contains(term, "!="):
+		r = splitRequirement(term, "!=", opNotEquals)
+	case strings.Contains(term, "=="):
+		r = splitRequirement(term, "==", opEquals)
+	case strings.Contains(term, "="):
+		r = splitRequirement(term, "=", opEquals)
+	default:
+		r = requirement{key: term, operator: opExists}
+	}
+	if err := validateLabelKey(r.key); err != nil {
+		return r, err
+	}
+	for _, v := range r.values {
+		if strings.ContainsAny(v, " =!()") {
+			return r, fmt.Errorf("invalid label value '%s'", v)
+		}
+	}
+	return r, nil
+}
+
+func splitRequirement(term, sep string, op selectorOperator) requirement {
+	parts := strings.SplitN(term, sep, 2)
+	return requirement{
+		key:      strings.TrimSpace(parts[0]),
+		operator: op,
+		values:   []string{strings.TrimSpace(parts[1])},
+	}
+}
+
+func validateLabelKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " =!(),") {
+		return fmt.Errorf("invalid label key '%s'", key)
+	}
+	return nil
+}
+
+// splitSelector splits the selector on the commas that are not inside a set of values.
+func splitSelector(selector string) []string {
+	terms := []string{}
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, selector[start:])
+}
+
+func containsString(set []string, value string) bool {
+	for _, e := range set {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "payments", "env": "prod"}
+	cases := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"team=payments", true},
+		{"team==payments", true},
+		{"team=search", false},
+		{"team!=search", true},
+		{"team!=payments", false},
+		{"owner!=bob", true},
+		{"team in (payments, search)", true},
+		{"team in (search)", false},
+		{"team notin (search,billing)", true},
+		{"env notin (prod)", false},
+		{"team", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!team", false},
+		{"team=payments,env=prod", true},
+		{"team=payments, env in (dev,staging)", false},
+	}
+	for _, c := range cases {
+		s, err := ParseSelector(c.selector)
+		if err != nil {
+			t.Errorf("Error parsing selector %q: %v", c.selector, err)
+			continue
+		}
+		if got := s.Matches(labels); got != c.matches {
+			t.Errorf("Selector %q: expected match %v, got %v", c.selector, c.matches, got)
+		}
+	}
+}
+
+func TestParseInvalidSelector(t *testing.T) {
+	for _, sel := range []string{"=payments", "team=pay ments", "!", "team in (a,b"} {
+		if _, err := ParseSelector(sel); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Expected invalid error parsing selector %q, got %v", sel, err)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	key := func(i int) string { return keys[i] }
+
+	got := []string{}
+	opts := ListOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > len(keys) {
+			t.Fatal("Pagination did not terminate")
+		}
+		start, end, next, err := Page(len(keys), key, opts)
+		if err != nil {
+			t.Fatalf("Error paging: %v", err)
+		}
+		got = append(got, keys[start:end]...)
+		if next == "" {
+			break
+		}
+		opts.Continue = next
+	}
+	if len(got) != len(keys) {
+		t.Errorf("Expected all %d keys across pages, got %v", len(keys), got)
+	}
+
+	if _, _, _, err := Page(len(keys), key, ListOptions{Continue: "not base64!"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected invalid error with malformed continue token, got %v", err)
+	}
+}
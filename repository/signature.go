@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// SignatureSuffix is appended to the name of a policy file, or to the URL of a policy bundle,
+// to locate its detached signature.
+const SignatureSuffix = ".sig"
+
+// ErrUntrusted is matched by errors returned when a policy is not signed by a trusted key.
+var ErrUntrusted = errors.New("untrusted")
+
+// UntrustedError is returned when a policy is unsigned, or its signature does not verify
+// with any of the keys of the trust root. It matches ErrUntrusted with errors.Is.
+type UntrustedError struct {
+	// Source of the policy, e.g. a file name or a URL.
+	Source string
+	// Reason describes why the policy is not trusted.
+	Reason string
+}
+
+func (e *UntrustedError) Error() string {
+	return fmt.Sprintf("policy '%s' is not trusted: %s", e.Source, e.Reason)
+}
+
+// Is reports whether target is ErrUntrusted.
+func (e *UntrustedError) Is(target error) bool {
+	return target == ErrUntrusted
+}
+
+// Signer signs policies with an ed25519 private key.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// LoadSigner reads a PEM encoded PKCS #8 ed25519 private key.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("No PEM encoded private key found in '%s'", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing private key '%s': %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Private key '%s' is not an ed25519 key", path)
+	}
+	return &Signer{key: edKey}, nil
+}
+
+// Sign returns the detached signature of the policy data, in the format of signature files:
+// the base64 encoded ed25519 signature followed by a newline.
+func (s *Signer) Sign(data []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)) + "\n")
+}
+
+// TrustRoot is the set of public keys whose signatures are trusted.
+type TrustRoot struct {
+	keys []ed25519.PublicKey
+}
+
+// LoadTrustRoot reads one or more PEM encoded PKIX ed25519 public keys.
+func LoadTrustRoot(path string) (*TrustRoot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &TrustRoot{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing public key in '%s': %v", path, err)
+		}
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("Public key in '%s' is not an ed25519 key", path)
+		}
+		t.keys = append(t.keys, edKey)
+	}
+	if len(t.keys) == 0 {
+		return nil, fmt.Errorf("No PEM encoded public keys found in '%s'", path)
+	}
+	return t, nil
+}
+
+// Verify checks that sig is a signature of data by one of the trusted keys. A nil sig
+// means that the policy is unsigned. The source is only used in errors.
+func (t *TrustRoot) Verify(source string, data, sig []byte) error {
+	if sig == nil {
+		return &UntrustedError{Source: source, Reason: "it is not signed"}
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return &UntrustedError{Source: source, Reason: "its signature is malformed"}
+	}
+	for _, key := range t.keys {
+		if ed25519.Verify(key, data, raw) {
+			return nil
+		}
+	}
+	return &UntrustedError{Source: source, Reason: "it is not signed by a trusted key"}
+}
+
+// GenerateSigningKey returns a new PEM encoded ed25519 private key and its public key.
+func GenerateSigningKey() (privateKey, publicKey []byte, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return privateKey, publicKey, nil
+}
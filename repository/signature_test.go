@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestKeys generates a signing key and writes it with its public key to dir.
+func writeTestKeys(t *testing.T, dir, name string) (privateKey, publicKey string) {
+	priv, pub, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey = filepath.Join(dir, name+".pem")
+	publicKey = filepath.Join(dir, name+".pub.pem")
+	if err = ioutil.WriteFile(privateKey, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(publicKey, pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, publicKey
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trustedKey, trustedPub := writeTestKeys(t, dir, "trusted")
+	otherKey, _ := writeTestKeys(t, dir, "other")
+
+	trustRoot, err := LoadTrustRoot(trustedPub)
+	if err != nil {
+		t.Fatalf("Error loading trust root: %v", err)
+	}
+	trusted, err := LoadSigner(trustedKey)
+	if err != nil {
+		t.Fatalf("Error loading signer: %v", err)
+	}
+	other, err := LoadSigner(otherKey)
+	if err != nil {
+		t.Fatalf("Error loading signer: %v", err)
+	}
+
+	policy := []byte(`{"Roles": []}`)
+	if err = trustRoot.Verify("policy", policy, trusted.Sign(policy)); err != nil {
+		t.Errorf("Expected policy signed by trusted key to verify: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		sig  []byte
+	}{
+		{"unsigned", policy, nil},
+		{"untrusted key", policy, other.Sign(policy)},
+		{"tampered", []byte(`{"Roles": [{}]}`), trusted.Sign(policy)},
+		{"malformed", policy, []byte("not a signature")},
+	}
+	for _, test := range tests {
+		if err = trustRoot.Verify("policy", test.data, test.sig); !errors.Is(err, ErrUntrusted) {
+			t.Errorf("%s: expected untrusted error, got %v", test.name, err)
+		}
+	}
+
+	if _, err = LoadTrustRoot(trustedKey); err == nil {
+		t.Errorf("Expected error loading trust root without public keys")
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// ValidateRole returns an InvalidError if the role cannot be persisted.
+func ValidateRole(r api.Role) error {
+	if r.Name == "" {
+		return &InvalidError{Kind: api.RoleKind, Name: r.Name, Namespace: r.Namespace, Reason: "name is required"}
+	}
+	if r.Namespace == "" {
+		return &InvalidError{Kind: api.RoleKind, Name: r.Name, Namespace: r.Namespace, Reason: "namespace is required"}
+	}
+	return nil
+}
+
+// ValidateRoleBinding returns an InvalidError if the role binding cannot be persisted.
+func ValidateRoleBinding(rb api.RoleBinding) error {
+	invalid := func(reason string) error {
+		return &InvalidError{Kind: api.RoleBindingKind, Name: rb.Name, Namespace: rb.Namespace, Reason: reason}
+	}
+	if rb.Name == "" {
+		return invalid("name is required")
+	}
+	if rb.Namespace == "" {
+		return invalid("namespace is required")
+	}
+	if rb.RoleRef.Kind != api.RoleKind && rb.RoleRef.Kind != api.ClusterRoleKind {
+		return invalid(fmt.Sprintf("unknown role reference kind '%s'", rb.RoleRef.Kind))
+	}
+	if rb.RoleRef.Name == "" {
+		return invalid("role reference name is required")
+	}
+	for _, s := range rb.Subjects {
+		switch s.Kind {
+		case api.UserKind, api.GroupKind, api.ServiceAccountKind:
+		default:
+			return invalid(fmt.Sprintf("unknown subject kind '%s'", s.Kind))
+		}
+	}
+	return nil
+}
+
+// ValidateClusterRole returns an InvalidError if the cluster role cannot be persisted.
+func ValidateClusterRole(cr api.ClusterRole) error {
+	if cr.Name == "" {
+		return &InvalidError{Kind: api.ClusterRoleKind, Name: cr.Name, Reason: "name is required"}
+	}
+	return nil
+}
+
+// ValidateClusterRoleBinding returns an InvalidError if the cluster role binding cannot be persisted.
+func ValidateClusterRoleBinding(crb api.ClusterRoleBinding) error {
+	invalid := func(reason string) error {
+		return &InvalidError{Kind: api.ClusterRoleBindingKind, Name: crb.Name, Reason: reason}
+	}
+	if crb.Name == "" {
+		return invalid("name is required")
+	}
+	if crb.RoleRef.Kind != api.ClusterRoleKind {
+		return invalid(fmt.Sprintf("role reference kind must be '%s'", api.ClusterRoleKind))
+	}
+	if crb.RoleRef.Name == "" {
+		return invalid("role reference name is required")
+	}
+	for _, s := range crb.Subjects {
+		switch s.Kind {
+		case api.UserKind, api.GroupKind, api.ServiceAccountKind:
+		default:
+			return invalid(fmt.Sprintf("unknown subject kind '%s'", s.Kind))
+		}
+	}
+	return nil
+}
+
+// ValidatePolicy validates all the objects of the policy, and ensures that no object is defined twice.
+func ValidatePolicy(p api.Policy) error {
+	seen := map[string]bool{}
+	unique := func(kind, name, namespace string) error {
+		k := kind + ":" + ObjectKey(namespace, name)
+		if seen[k] {
+			return &InvalidError{Kind: kind, Name: name, Namespace: namespace, Reason: "defined more than once"}
+		}
+		seen[k] = true
+		return nil
+	}
+	for _, r := range p.Roles {
+		if err := ValidateRole(r); err != nil {
+			return err
+		}
+		if err := unique(api.RoleKind, r.Name, r.Namespace); err != nil {
+			return err
+		}
+	}
+	for _, rb := range p.RoleBindings {
+		if err := ValidateRoleBinding(rb); err != nil {
+			return err
+		}
+		if err := unique(api.RoleBindingKind, rb.Name, rb.Namespace); err != nil {
+			return err
+		}
+	}
+	for _, cr := range p.ClusterRoles {
+		if err := ValidateClusterRole(cr); err != nil {
+			return err
+		}
+		if err := unique(api.ClusterRoleKind, cr.Name, ""); err != nil {
+			return err
+		}
+	}
+	for _, crb := range p.ClusterRoleBindings {
+		if err := ValidateClusterRoleBinding(crb); err != nil {
+			return err
+		}
+		if err := unique(api.ClusterRoleBindingKind, crb.Name, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
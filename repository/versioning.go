@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// NewUID returns a random (version 4) UUID that can be assigned to a newly created object.
+func NewUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(fmt.Sprintf("Error generating UID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// CreationTimestamp returns the current time formatted for use as an object's creation timestamp.
+func CreationTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// CheckResourceVersion verifies that a write requesting the given resource version may be applied
+// to an object that is currently stored with the current resource version. An empty requested
+// version means the caller does not care about concurrent modifications. A ConflictError is
+// returned when the versions do not match.
+func CheckResourceVersion(kind, name, namespace, current, requested string) error {
+	if requested == "" || requested == current {
+		return nil
+	}
+	return &ConflictError{
+		Kind:                   kind,
+		Name:                   name,
+		Namespace:              namespace,
+		ResourceVersion:        requested,
+		CurrentResourceVersion: current,
+	}
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+// DefaultWatchInterval is the default for how often backends that poll check for changes.
+const DefaultWatchInterval = time.Second
+
+// EventType describes what happened to an object.
+type EventType string
+
+const (
+	// Added means that the object was created.
+	Added EventType = "ADDED"
+	// Modified means that the object was updated.
+	Modified EventType = "MODIFIED"
+	// Deleted means that the object was removed. The event carries the last known state of the object.
+	Deleted EventType = "DELETED"
+)
+
+// Event is a change to a policy object.
+type Event struct {
+	// Type of change.
+	Type EventType `json:"type"`
+	// Kind of the object, e.g. "Role".
+	Kind string `json:"kind"`
+	// Name of the object.
+	Name string `json:"name"`
+	// Namespace of the object. Empty for cluster-scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	// Object is the api.Role, api.RoleBinding, api.ClusterRole or api.ClusterRoleBinding.
+	Object interface{} `json:"object"`
+	// Revision of the policy in which the change was observed.
+	Revision string `json:"revision"`
+}
+
+// WatchRepository is implemented by repositories that notify about changes to the policy.
+type WatchRepository interface {
+	// Watch returns a channel of changes to the policy. It starts with an Added event for every
+	// existing object. Events are computed against the last state delivered to the receiver,
+	// so a receiver that falls behind, or changes made while the policy could not be read, are
+	// resynchronized by the next events rather than lost. The channel is closed when the
+	// context is done.
+	Watch(ctx context.Context) (<-chan Event, error)
+}
+
+// Snapshot is the policy of a repository at a revision.
+type Snapshot struct {
+	Policy   api.Policy
+	Revision string
+}
+
+// SnapshotRepository is implemented by repositories that can read the whole policy at a
+// single revision. Reading the policy with the list calls may mix revisions when the policy
+// changes in between.
+type SnapshotRepository interface {
+	// Snapshot returns the current policy and its revision.
+	Snapshot() (*Snapshot, error)
+}
+
+// RevisionRepository is implemented by repositories that can tell whether their policy
+// changed without reading it, so that the revision can be checked on every request.
+type RevisionRepository interface {
+	// CurrentRevision returns an identifier of the current policy, which changes whenever the
+	// policy changes. It is not necessarily the revision of a Snapshot.
+	CurrentRevision() (string, error)
+}
+
+// ReadRevision returns an identifier of the current policy of a repository, which changes
+// whenever the policy changes. Repositories that do not implement RevisionRepository are read
+// with ReadSnapshot.
+func ReadRevision(repo PolicyRepository) (string, error) {
+	if rr, ok := repo.(RevisionRepository); ok {
+		return rr.CurrentRevision()
+	}
+	snap, err := ReadSnapshot(repo)
+	if err != nil {
+		return "", err
+	}
+	return snap.Revision, nil
+}
+
+// ReadSnapshot returns the whole policy of a repository and its revision. Repositories that do
+// not implement SnapshotRepository are read with ReadPolicy, and their revision is the content
+// hash of the policy.
+func ReadSnapshot(repo PolicyRepository) (*Snapshot, error) {
+	if sr, ok := repo.(SnapshotRepository); ok {
+		return sr.Snapshot()
+	}
+	p, err := ReadPolicy(repo)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := ContentHash(*p)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Policy: *p, Revision: hash}, nil
+}
+
+// PollWatch implements Watch for backends that poll for changes. Every interval, load returns
+// the current snapshot, or nil if the policy did not change since its last call. The first
+// load happens before PollWatch returns, and its error is returned. Later errors are logged,
+// and the policy is resynchronized once it can be loaded again.
+func PollWatch(ctx context.Context, interval time.Duration, load func() (*Snapshot, error)) (<-chan Event, error) {
+	first, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if first == nil {
+		first = &Snapshot{}
+	}
+
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := &Snapshot{}
+		snap := first
+		for {
+			if snap != nil {
+				for _, e := range DiffPolicies(last.Policy, snap.Policy, snap.Revision) {
+					select {
+					case ch <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+				last = snap
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			var err error
+			if snap, err = load(); err != nil {
+				log.Printf("Error watching policy: %v", err)
+				snap = nil
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// DiffPolicies returns the events that turn the old policy into the new one, ordered by kind,
+// namespace and name.
+func DiffPolicies(old, new api.Policy, revision string) []Event {
+	before := policyObjects(old)
+	after := policyObjects(new)
+
+	refs := []objectRef{}
+	for ref := range before {
+		refs = append(refs, ref)
+	}
+	for ref := range after {
+		if _, ok := before[ref]; !ok {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		return a.name < b.name
+	})
+
+	events := []Event{}
+	for _, ref := range refs {
+		b, existed := before[ref]
+		a, exists := after[ref]
+		e := Event{Kind: ref.kind, Name: ref.name, Namespace: ref.namespace, Object: a, Revision: revision}
+		switch {
+		case !existed:
+			e.Type = Added
+		case !exists:
+			e.Type, e.Object = Deleted, b
+		case !objectsEqual(b, a):
+			e.Type = Modified
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+type objectRef struct {
+	kind, namespace, name string
+}
+
+// objectsEqual compares two objects of the same kind, including their metadata.
+func objectsEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case api.Role:
+		b := b.(api.Role)
+		return a.UID == b.UID && a.ResourceVersion == b.ResourceVersion && a.CreationTimestamp == b.CreationTimestamp && sameRole(a, b)
+	case api.RoleBinding:
+		b := b.(api.RoleBinding)
+		return a.UID == b.UID && a.ResourceVersion == b.ResourceVersion && a.CreationTimestamp == b.CreationTimestamp && sameRoleBinding(a, b)
+	case api.ClusterRole:
+		b := b.(api.ClusterRole)
+		return a.UID == b.UID && a.ResourceVersion == b.ResourceVersion && a.CreationTimestamp == b.CreationTimestamp && sameClusterRole(a, b)
+	case api.ClusterRoleBinding:
+		b := b.(api.ClusterRoleBinding)
+		return a.UID == b.UID && a.ResourceVersion == b.ResourceVersion && a.CreationTimestamp == b.CreationTimestamp && sameClusterRoleBinding(a, b)
+	}
+	return false
+}
+
+func policyObjects(p api.Policy) map[objectRef]interface{} {
+	objects := map[objectRef]interface{}{}
+	for _, r := range p.Roles {
+		objects[objectRef{api.RoleKind, r.Namespace, r.Name}] = r
+	}
+	for _, rb := range p.RoleBindings {
+		objects[objectRef{api.RoleBindingKind, rb.Namespace, rb.Name}] = rb
+	}
+	for _, cr := range p.ClusterRoles {
+		objects[objectRef{api.ClusterRoleKind, "", cr.Name}] = cr
+	}
+	for _, crb := range p.ClusterRoleBindings {
+		objects[objectRef{api.ClusterRoleBindingKind, "", crb.Name}] = crb
+	}
+	return objects
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	view := api.ClusterRole{Name: "view", Rules: []api.PolicyRule{{Verbs: []string{"get"}}}}
+	edit := api.ClusterRole{Name: "edit", Rules: []api.PolicyRule{{Verbs: []string{"get", "update"}}}}
+	admins := api.RoleBinding{Name: "admins", Namespace: "project1", RoleRef: api.ObjectReference{Kind: api.ClusterRoleKind, Name: "edit"}}
+
+	old := api.Policy{ClusterRoles: []api.ClusterRole{view, edit}}
+	updatedView := view
+	updatedView.Rules = []api.PolicyRule{{Verbs: []string{"get", "list"}}}
+	new := api.Policy{ClusterRoles: []api.ClusterRole{updatedView}, RoleBindings: []api.RoleBinding{admins}}
+
+	events := DiffPolicies(old, new, "7")
+	expected := []Event{
+		{Type: Deleted, Kind: api.ClusterRoleKind, Name: "edit", Object: edit, Revision: "7"},
+		{Type: Modified, Kind: api.ClusterRoleKind, Name: "view", Object: updatedView, Revision: "7"},
+		{Type: Added, Kind: api.RoleBindingKind, Name: "admins", Namespace: "project1", Object: admins, Revision: "7"},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events:\n%+v\ngot:\n%+v", expected, events)
+	}
+
+	if events = DiffPolicies(new, new, "8"); len(events) != 0 {
+		t.Errorf("Expected no events for identical policies, got %+v", events)
+	}
+}
+
+// fakeSource is a policy that changes under a watch.
+type fakeSource struct {
+	sync.Mutex
+	snap *Snapshot
+	err  error
+}
+
+func (s *fakeSource) set(snap *Snapshot, err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.snap, s.err = snap, err
+}
+
+func (s *fakeSource) load() (*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.snap, s.err
+}
+
+func TestPollWatchResyncsAfterMissedChanges(t *testing.T) {
+	role := func(name string) api.Role { return api.Role{Name: name, Namespace: "default"} }
+	src := &fakeSource{snap: &Snapshot{Policy: api.Policy{Roles: []api.Role{role("a")}}, Revision: "1"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := PollWatch(ctx, time.Millisecond, src.load)
+	if err != nil {
+		t.Fatalf("Error watching: %v", err)
+	}
+
+	e := <-ch
+	if e.Type != Added || e.Name != "a" || e.Revision != "1" {
+		t.Errorf("Expected initial Added event for role a, got %+v", e)
+	}
+
+	// The receiver is not reading, and the source fails for a while and changes
+	// several times. Only the difference with the last delivered state is sent.
+	src.set(nil, errors.New("unavailable"))
+	time.Sleep(5 * time.Millisecond)
+	src.set(&Snapshot{Policy: api.Policy{Roles: []api.Role{role("b")}}, Revision: "2"}, nil)
+	time.Sleep(5 * time.Millisecond)
+	src.set(&Snapshot{Policy: api.Policy{Roles: []api.Role{role("b"), role("c")}}, Revision: "3"}, nil)
+
+	got := map[string]EventType{}
+	for len(got) < 3 {
+		select {
+		case e = <-ch:
+			got[e.Name] = e.Type
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for events, got %v", got)
+		}
+	}
+	expected := map[string]EventType{"a": Deleted, "b": Added, "c": Added}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected events %v, got %v", expected, got)
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestPollWatchReturnsInitialError(t *testing.T) {
+	src := &fakeSource{err: errors.New("unavailable")}
+	if _, err := PollWatch(context.Background(), time.Millisecond, src.load); err == nil {
+		t.Errorf("Expected error when the policy cannot be loaded initially")
+	}
+}
+
+// listOnly hides the SnapshotRepository implementation of a Document.
+type listOnly struct {
+	PolicyRepository
+}
+
+func TestReadSnapshot(t *testing.T) {
+	doc := &Document{}
+	if err := doc.CreateRole(api.Role{Name: "a", Namespace: "default"}); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ReadSnapshot(doc)
+	if err != nil || snap.Revision != doc.Revision() || len(snap.Policy.Roles) != 1 {
+		t.Errorf("Expected the snapshot of the document at revision %s, got %+v, %v", doc.Revision(), snap, err)
+	}
+
+	p, err := ReadPolicy(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := ContentHash(*p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err = ReadSnapshot(listOnly{doc})
+	if err != nil || snap.Revision != hash || len(snap.Policy.Roles) != 1 {
+		t.Errorf("Expected the listed policy at revision %s, got %+v, %v", hash, snap, err)
+	}
+}
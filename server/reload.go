@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/metrics"
+)
+
+const (
+	// DefaultReloadInterval is the default for how often the certificate files are checked.
+	DefaultReloadInterval = 10 * time.Second
+	// CertificatePath is the path under which the CertificateStatusHandler is served.
+	CertificatePath = "/tls/certificate"
+)
+
+// CertificateReloader serves the certificate, private key and client CAs of the TLSOptions,
+// and swaps them in when their files change, so that rotated certificates are served without
+// a restart. Connections that are already established keep the certificate they were made with.
+type CertificateReloader struct {
+	// Options of the server, whose files are reloaded.
+	Options TLSOptions
+	// ReloadInterval is how often Run checks the files for changes.
+	ReloadInterval time.Duration
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	leaf       *x509.Certificate
+	clientCAs  *x509.CertPool
+	contents   [][]byte
+	lastReload time.Time
+	lastErr    error
+}
+
+// CertificateStatus describes the certificate being served.
+type CertificateStatus struct {
+	// Subject is the common name of the certificate.
+	Subject string `json:"subject"`
+	// SerialNumber of the certificate, in hexadecimal.
+	SerialNumber string `json:"serialNumber"`
+	// NotAfter is the expiry of the certificate.
+	NotAfter time.Time `json:"notAfter"`
+	// ExpiresIn is the time left until the certificate expires, negative once it has expired.
+	ExpiresIn time.Duration `json:"-"`
+	// LastReload is the time at which the files were last loaded.
+	LastReload time.Time `json:"lastReload"`
+	// LastError is the error of the last reload, if it failed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// NewCertificateReloader returns a CertificateReloader for the options, with the files loaded.
+func NewCertificateReloader(o TLSOptions) (*CertificateReloader, error) {
+	if _, err := o.config(); err != nil {
+		return nil, err
+	}
+	r := &CertificateReloader{Options: o, ReloadInterval: DefaultReloadInterval}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns the TLS configuration of the server, which serves the current certificate
+// and verifies clients with the current client CAs.
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	// The options were validated by NewCertificateReloader
+	config, _ := r.Options.config()
+	config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.cert, nil
+	}
+	if r.Options.ClientCAFile != "" {
+		base := config.Clone()
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			r.mu.RLock()
+			c.ClientCAs = r.clientCAs
+			r.mu.RUnlock()
+			return c, nil
+		}
+	}
+	return config
+}
+
+// Run reloads the files every ReloadInterval until the context is done. Errors are logged,
+// and the last good certificate keeps being served.
+func (r *CertificateReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := r.Reload(); err != nil {
+			log.Printf("Error reloading the serving certificate, serving the certificate that expires %v: %v", r.Status().NotAfter, err)
+		}
+	}
+}
+
+// Reload reads the files, and swaps them in if they changed and are valid. A certificate and
+// key that do not match, e.g. because only one of them was rotated yet, are not swapped in.
+func (r *CertificateReloader) Reload() error {
+	err := r.load()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+	return err
+}
+
+func (r *CertificateReloader) load() error {
+	files := []string{r.Options.CertFile, r.Options.KeyFile}
+	if r.Options.ClientCAFile != "" {
+		files = append(files, r.Options.ClientCAFile)
+	}
+	contents := [][]byte{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, data)
+	}
+
+	r.mu.RLock()
+	unchanged := len(r.contents) == len(contents)
+	for i := 0; unchanged && i < len(contents); i++ {
+		unchanged = bytes.Equal(r.contents[i], contents[i])
+	}
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return fmt.Errorf("Error loading the server certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("Error parsing the server certificate: %v", err)
+	}
+	var clientCAs *x509.CertPool
+	if len(contents) > 2 {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents[2]) {
+			return fmt.Errorf("No certificates found in '%s'", r.Options.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.leaf != nil {
+		log.Printf("Reloaded the serving certificate, which expires %v", leaf.NotAfter)
+	}
+	r.cert = &cert
+	r.leaf = leaf
+	r.clientCAs = clientCAs
+	r.contents = contents
+	r.lastReload = time.Now()
+	return nil
+}
+
+// Status returns the status of the certificate being served.
+func (r *CertificateReloader) Status() CertificateStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := CertificateStatus{
+		Subject:      r.leaf.Subject.CommonName,
+		SerialNumber: fmt.Sprintf("%x", r.leaf.SerialNumber),
+		NotAfter:     r.leaf.NotAfter,
+		ExpiresIn:    time.Until(r.leaf.NotAfter),
+		LastReload:   r.lastReload,
+	}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	return s
+}
+
+// CertificateStatusHandler serves the status of the serving certificate:
+//
+//	GET /tls/certificate
+type CertificateStatusHandler struct {
+	Reloader *CertificateReloader
+}
+
+// certificateStatus is the body of the response, with the time left until the certificate
+// expires in seconds.
+type certificateStatus struct {
+	CertificateStatus
+	ExpiresInSeconds float64 `json:"expiresInSeconds"`
+}
+
+func (ch *CertificateStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := ch.Reloader.Status()
+	payload, err := json.Marshal(certificateStatus{CertificateStatus: s, ExpiresInSeconds: s.ExpiresIn.Seconds()})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// RegisterMetrics registers the metrics of the serving certificate with the registry.
+func (r *CertificateReloader) RegisterMetrics(reg *metrics.Registry) {
+	reg.Register(
+		metrics.NewGaugeFunc("kubernetes_rbac_certificate_expiry_timestamp_seconds", "Time at which the serving certificate expires.", nil, func() []metrics.Sample {
+			return metrics.Value(float64(r.Status().NotAfter.Unix()))
+		}),
+		metrics.NewGaugeFunc("kubernetes_rbac_certificate_reload_failing", "Whether the last reload of the certificate files failed, in which case the previous certificate is served.", nil, func() []metrics.Sample {
+			if r.Status().LastError != "" {
+				return metrics.Value(1)
+			}
+			return metrics.Value(0)
+		}),
+	)
+}
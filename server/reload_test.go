@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// servedCertificate returns the expiry of the certificate served at the URL.
+func servedCertificate(t *testing.T, client *http.Client, url string) time.Time {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Error connecting to the server: %v", err)
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].NotAfter
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	certPEM, keyPEM := ca.serverCert(t, firstExpiry)
+	opts := TLSOptions{
+		CertFile: writeFile(t, dir, "server.pem", certPEM),
+		KeyFile:  writeFile(t, dir, "server-key.pem", keyPEM),
+	}
+	reloader, err := NewCertificateReloader(opts)
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	s := serve(t, reloader.TLSConfig())
+	defer s.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	established := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if got := servedCertificate(t, established, s.URL); !got.Equal(firstExpiry) {
+		t.Errorf("Expected certificate expiring %v, got %v", firstExpiry, got)
+	}
+
+	// Rotating the certificate before the key keeps the previous certificate
+	secondExpiry := firstExpiry.Add(time.Hour)
+	certPEM, keyPEM = ca.serverCert(t, secondExpiry)
+	writeFile(t, dir, "server.pem", certPEM)
+	if err = reloader.Reload(); err == nil {
+		t.Errorf("Expected error reloading a certificate that does not match the key")
+	}
+	if status := reloader.Status(); !status.NotAfter.Equal(firstExpiry) || status.LastError == "" {
+		t.Errorf("Expected previous certificate to be served with the reload error, got %+v", status)
+	}
+
+	writeFile(t, dir, "server-key.pem", keyPEM)
+	if err = reloader.Reload(); err != nil {
+		t.Fatalf("Error reloading certificates: %v", err)
+	}
+	fresh := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if got := servedCertificate(t, fresh, s.URL); !got.Equal(secondExpiry) {
+		t.Errorf("Expected new connections to get the certificate expiring %v, got %v", secondExpiry, got)
+	}
+	// The established connection is kept alive, with the certificate it was made with
+	if got := servedCertificate(t, established, s.URL); !got.Equal(firstExpiry) {
+		t.Errorf("Expected the established connection to keep the certificate expiring %v, got %v", firstExpiry, got)
+	}
+
+	w := httptest.NewRecorder()
+	(&CertificateStatusHandler{Reloader: reloader}).ServeHTTP(w, httptest.NewRequest("GET", CertificatePath, nil))
+	status := certificateStatus{}
+	if err = json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Error decoding status: %v", err)
+	}
+	if !status.NotAfter.Equal(secondExpiry) || status.ExpiresInSeconds < 3600 || status.ExpiresInSeconds > 7200 {
+		t.Errorf("Expected certificate to expire at %v, in about two hours, got %+v", secondExpiry, status)
+	}
+	if status.LastError != "" {
+		t.Errorf("Expected the last error to be cleared, got %v", status.LastError)
+	}
+}
+
+func TestClientCAReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.serverCert(t, time.Now().Add(time.Hour))
+	oldCA, newCA := newTestCA(t), newTestCA(t)
+	opts := TLSOptions{
+		CertFile:          writeFile(t, dir, "server.pem", certPEM),
+		KeyFile:           writeFile(t, dir, "server-key.pem", keyPEM),
+		ClientCAFile:      writeFile(t, dir, "client-ca.pem", oldCA.pem),
+		RequireClientCert: true,
+	}
+	reloader, err := NewCertificateReloader(opts)
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	s := serve(t, reloader.TLSConfig())
+	defer s.Close()
+
+	client := newCA.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "kube-apiserver"}})
+	if err = get(ca, s.URL, client); err == nil {
+		t.Errorf("Expected client of the new CA to be refused before the reload")
+	}
+	writeFile(t, dir, "client-ca.pem", newCA.pem)
+	if err = reloader.Reload(); err != nil {
+		t.Fatalf("Error reloading certificates: %v", err)
+	}
+	if err = get(ca, s.URL, client); err != nil {
+		t.Errorf("Expected client of the new CA to be accepted after the reload: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultShutdownDelay is the default for how long the server keeps serving once it started
+	// failing readiness.
+	DefaultShutdownDelay = 5 * time.Second
+	// DefaultShutdownTimeout is the default for how long in-flight requests are waited for.
+	DefaultShutdownTimeout = 20 * time.Second
+)
+
+// ShutdownOptions configure how Serve shuts the server down.
+type ShutdownOptions struct {
+	// Drain, when set, is called as soon as the shutdown starts, e.g. to fail readiness.
+	Drain func()
+	// Delay is how long new requests keep being served after Drain, so that load balancers
+	// observe the failing readiness and stop sending requests.
+	Delay time.Duration
+	// Timeout is how long in-flight requests are waited for once the listener is closed.
+	// Requests still in flight after it are aborted.
+	Timeout time.Duration
+}
+
+// Serve accepts connections on the listener until the context is done, then shuts the server
+// down gracefully: it calls Drain, keeps serving for Delay, closes the listener and waits up to
+// Timeout for the in-flight requests to complete. Connections are served over TLS when the
+// server has a TLSConfig. Serve returns nil once the server is shut down, or the error that
+// stopped it from serving.
+func Serve(ctx context.Context, srv *http.Server, l net.Listener, opts ShutdownOptions) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ServeTLS(l, "", "")
+		} else {
+			errs <- srv.Serve(l)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, serving requests for another %v", opts.Delay)
+	if opts.Drain != nil {
+		opts.Drain()
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(opts.Delay):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
+}
+
+// Endpoint is a server and the listener on which it accepts connections.
+type Endpoint struct {
+	Server   *http.Server
+	Listener net.Listener
+}
+
+// ServeAll serves every endpoint like Serve, and shuts them down together: when the context is
+// done, or as soon as one of them stops serving. Drain is called once. ServeAll returns nil
+// once all the endpoints are shut down, or the first error that stopped one of them.
+func ServeAll(ctx context.Context, endpoints []Endpoint, opts ShutdownOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if drain := opts.Drain; drain != nil {
+		var once sync.Once
+		opts.Drain = func() { once.Do(drain) }
+	}
+
+	errs := make(chan error, len(endpoints))
+	for _, e := range endpoints {
+		go func(e Endpoint) {
+			err := Serve(ctx, e.Server, e.Listener, opts)
+			cancel()
+			errs <- err
+		}(e)
+	}
+	var first error
+	for range endpoints {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
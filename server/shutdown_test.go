@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowServer serves requests that wait for release, and reports each request on started.
+func slowServer(t *testing.T) (*http.Server, net.Listener, chan struct{}, chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("done"))
+	})}
+	return srv, l, started, release
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	srv, l, started, release := slowServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	drained := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, srv, l, ShutdownOptions{Drain: func() { close(drained) }, Delay: 50 * time.Millisecond, Timeout: 5 * time.Second})
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-started
+	cancel()
+	<-drained
+	// The request completes after the listener is closed
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	if body := <-responses; body != "done" {
+		t.Errorf("Expected the in-flight request to complete, got %s", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected the server to shut down cleanly, got %v", err)
+	}
+	if _, err := net.Dial("tcp", l.Addr().String()); err == nil {
+		t.Errorf("Expected the listener to be closed")
+	}
+}
+
+func TestServeAbortsRequestsAfterTimeout(t *testing.T) {
+	srv, l, started, release := slowServer(t)
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, srv, l, ShutdownOptions{Timeout: 50 * time.Millisecond})
+	}()
+	go http.Get("http://" + l.Addr().String())
+	<-started
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Expected the shutdown to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the shutdown to time out")
+	}
+}
+
+func TestServeAllShutsDownTogether(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	drains := 0
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeAll(ctx, []Endpoint{{&http.Server{}, first}, {&http.Server{}, second}}, ShutdownOptions{Drain: func() { drains++ }, Timeout: time.Second})
+	}()
+	cancel()
+	if err = <-served; err != nil {
+		t.Errorf("Expected the servers to shut down cleanly, got %v", err)
+	}
+	if drains != 1 {
+		t.Errorf("Expected a single drain, got %d", drains)
+	}
+	for _, l := range []net.Listener{first, second} {
+		if _, err = net.Dial("tcp", l.Addr().String()); err == nil {
+			t.Errorf("Expected the listener on %s to be closed", l.Addr())
+		}
+	}
+
+	// A server that stops serving shuts the others down
+	third, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	go func() {
+		served <- ServeAll(context.Background(), []Endpoint{{&http.Server{}, third}, {&http.Server{}, closed}}, ShutdownOptions{Timeout: time.Second})
+	}()
+	select {
+	case err = <-served:
+		if err == nil {
+			t.Errorf("Expected the error of the closed listener")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the servers to shut down when one of them fails")
+	}
+}
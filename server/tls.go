@@ -0,0 +1,154 @@
+// Package server configures the HTTPS server of the authorization webhook.
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultMinTLSVersion is the minimum TLS version accepted by default.
+const DefaultMinTLSVersion = "1.2"
+
+// tlsVersions maps the TLS versions accepted by TLSOptions to their tls package constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSOptions configure the TLS of the webhook server, and how it authenticates its clients.
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key of the server.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile holds the PEM encoded CA certificates that sign client certificates. When
+	// empty, client certificates are not verified.
+	ClientCAFile string
+	// RequireClientCert refuses clients that do not present a certificate signed by the
+	// client CAs. Otherwise, client certificates are only verified when they are presented.
+	RequireClientCert bool
+	// AllowedClientNames restricts the clients to the certificates with one of these names as
+	// common name or subject alternative name. Requires a client certificate when set.
+	AllowedClientNames []string
+	// MinVersion is the minimum TLS version, one of "1.0", "1.1", "1.2" or "1.3". Defaults to
+	// DefaultMinTLSVersion.
+	MinVersion string
+	// CipherSuites are the names of the cipher suites enabled for TLS 1.2 and earlier, such
+	// as "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". The TLS 1.3 cipher suites are not
+	// configurable. Defaults to the secure cipher suites of the Go standard library.
+	CipherSuites []string
+}
+
+// config returns the TLS configuration of the server, without its certificates and client CAs,
+// which are loaded by a CertificateReloader.
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{}
+	minVersion := o.MinVersion
+	if minVersion == "" {
+		minVersion = DefaultMinTLSVersion
+	}
+	var ok bool
+	if config.MinVersion, ok = tlsVersions[minVersion]; !ok {
+		return nil, fmt.Errorf("Invalid minimum TLS version '%s': expected one of 1.0, 1.1, 1.2 or 1.3", minVersion)
+	}
+	var err error
+	if config.CipherSuites, err = cipherSuites(o.CipherSuites); err != nil {
+		return nil, err
+	}
+
+	if o.ClientCAFile == "" {
+		if o.RequireClientCert || len(o.AllowedClientNames) > 0 {
+			return nil, errors.New("Verifying client certificates requires a client CA file")
+		}
+		return config, nil
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if o.RequireClientCert || len(o.AllowedClientNames) > 0 {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(o.AllowedClientNames) > 0 {
+		config.VerifyPeerCertificate = allowClientNames(o.AllowedClientNames)
+	}
+	return config, nil
+}
+
+// cipherSuites returns the IDs of the named cipher suites, or nil for the defaults.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		ids[s.Name] = s.ID
+	}
+	suites := []uint16{}
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			known := []string{}
+			for n := range ids {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("Unknown or insecure cipher suite '%s': expected one of %s", name, strings.Join(known, ", "))
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
+
+// allowClientNames returns a function that refuses client certificates without one of the
+// names as common name or subject alternative name.
+func allowClientNames(names []string) func([][]byte, [][]*x509.Certificate) error {
+	allowed := map[string]bool{}
+	for _, n := range names {
+		allowed[n] = true
+	}
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		for _, n := range certificateNames(verifiedChains[0][0]) {
+			if allowed[n] {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate '%s' is not allowed", verifiedChains[0][0].Subject.CommonName)
+	}
+}
+
+// certificateNames returns the common name and the subject alternative names of the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// RequireClientCertificate serves the requests of clients that presented a verified certificate
+// with the handler, and refuses the others with 403 Forbidden. It protects the endpoints of a
+// listener that verifies client certificates only when they are presented.
+func RequireClientCertificate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "A verified client certificate is required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and private key for the template.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = notAfter
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) serverCert(t *testing.T, notAfter time.Time) ([]byte, []byte) {
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "webhook"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, notAfter)
+}
+
+func (ca *testCA) clientCert(t *testing.T, template *x509.Certificate) tls.Certificate {
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certPEM, keyPEM := ca.issue(t, template, time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testServer is a server started with the TLS configuration of the webhook. Unlike
+// httptest.Server, it does not add a certificate of its own.
+type testServer struct {
+	*http.Server
+	URL string
+}
+
+func serve(t *testing.T, config *tls.Config) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), TLSConfig: config}
+	go s.ServeTLS(ln, "", "")
+	return &testServer{Server: s, URL: "https://" + ln.Addr().String()}
+}
+
+func get(ca *testCA, url string, certs ...tls.Certificate) error {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestClientVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.serverCert(t, time.Now().Add(time.Hour))
+	opts := TLSOptions{
+		CertFile:     writeFile(t, dir, "server.pem", certPEM),
+		KeyFile:      writeFile(t, dir, "server-key.pem", keyPEM),
+		ClientCAFile: writeFile(t, dir, "ca.pem", ca.pem),
+	}
+	apiserver := ca.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "kube-apiserver"}})
+	bySAN := ca.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"apiserver.example.com"}})
+	other := ca.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "prober"}})
+	untrusted := newTestCA(t).clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "kube-apiserver"}})
+
+	cases := []struct {
+		name    string
+		require bool
+		allowed []string
+		certs   []tls.Certificate
+		ok      bool
+	}{
+		{"optional without certificate", false, nil, nil, true},
+		{"optional with untrusted certificate", false, nil, []tls.Certificate{untrusted}, false},
+		{"required without certificate", true, nil, nil, false},
+		{"required with certificate", true, nil, []tls.Certificate{other}, true},
+		{"allowed common name", false, []string{"kube-apiserver"}, []tls.Certificate{apiserver}, true},
+		{"allowed subject alternative name", false, []string{"apiserver.example.com"}, []tls.Certificate{bySAN}, true},
+		{"name not allowed", false, []string{"kube-apiserver"}, []tls.Certificate{other}, false},
+		{"allowlist without certificate", false, []string{"kube-apiserver"}, nil, false},
+	}
+	for _, c := range cases {
+		opts.RequireClientCert = c.require
+		opts.AllowedClientNames = c.allowed
+		reloader, err := NewCertificateReloader(opts)
+		if err != nil {
+			t.Fatalf("%s: error loading certificates: %v", c.name, err)
+		}
+		s := serve(t, reloader.TLSConfig())
+		err = get(ca, s.URL, c.certs...)
+		s.Close()
+		if c.ok && err != nil {
+			t.Errorf("%s: expected request to succeed, got %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected request to be refused", c.name)
+		}
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes-rbac-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.serverCert(t, time.Now().Add(time.Hour))
+	opts := TLSOptions{
+		CertFile: writeFile(t, dir, "server.pem", certPEM),
+		KeyFile:  writeFile(t, dir, "server-key.pem", keyPEM),
+	}
+
+	reloader, err := NewCertificateReloader(opts)
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	config := reloader.TLSConfig()
+	if config.MinVersion != tls.VersionTLS12 || config.CipherSuites != nil || config.ClientAuth != tls.NoClientCert {
+		t.Errorf("Expected TLS 1.2, the default cipher suites and no client verification, got %+v", config)
+	}
+
+	opts.MinVersion = "1.3"
+	opts.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	if reloader, err = NewCertificateReloader(opts); err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	config = reloader.TLSConfig()
+	if config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != 1 || config.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Expected TLS 1.3 and the configured cipher suite, got %+v", config)
+	}
+
+	invalid := []TLSOptions{
+		{CertFile: opts.CertFile, KeyFile: opts.KeyFile, MinVersion: "1.4"},
+		{CertFile: opts.CertFile, KeyFile: opts.KeyFile, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CertFile: opts.CertFile, KeyFile: opts.KeyFile, RequireClientCert: true},
+		{CertFile: opts.CertFile, KeyFile: opts.KeyFile, AllowedClientNames: []string{"kube-apiserver"}},
+		{CertFile: opts.CertFile, KeyFile: opts.KeyFile, ClientCAFile: opts.CertFile + ".missing"},
+		{CertFile: opts.CertFile, KeyFile: opts.CertFile},
+	}
+	for _, o := range invalid {
+		if _, err = NewCertificateReloader(o); err == nil {
+			t.Errorf("Expected error for options %+v", o)
+		}
+	}
+}
+
+func TestRequireClientCertificate(t *testing.T) {
+	h := RequireClientCertificate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "prometheus"}}
+	cases := []struct {
+		name   string
+		state  *tls.ConnectionState
+		status int
+	}{
+		{"without TLS", nil, http.StatusForbidden},
+		{"without certificate", &tls.ConnectionState{}, http.StatusForbidden},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, http.StatusForbidden},
+		{"verified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.TLS = c.state
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, w.Code)
+		}
+	}
+}
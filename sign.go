@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+	"github.com/kismatic/kubernetes-rbac/repository/file"
+	flag "github.com/spf13/pflag"
+)
+
+// runSign writes the detached signature of each of the given policy files, or generates
+// a new signing key.
+func runSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	key := fs.String("key", "", "PEM encoded ed25519 private key to sign with")
+	generate := fs.Bool("generate-key", false, "Generate a new private key in --key and its public key in --public-key, instead of signing")
+	publicKey := fs.String("public-key", "", "File to write the public key to, with --generate-key")
+	fs.Parse(args)
+
+	if *key == "" {
+		return errors.New("--key is required")
+	}
+
+	if *generate {
+		if *publicKey == "" {
+			return errors.New("--public-key is required with --generate-key")
+		}
+		priv, pub, err := repository.GenerateSigningKey()
+		if err != nil {
+			return err
+		}
+		if err = writeNewFile(*key, priv, 0600); err != nil {
+			return err
+		}
+		if err = writeNewFile(*publicKey, pub, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Generated private key %s and public key %s\n", *key, *publicKey)
+		return nil
+	}
+
+	if fs.NArg() == 0 {
+		return errors.New("at least one policy file is required")
+	}
+	signer, err := repository.LoadSigner(*key)
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = file.WriteSignature(path, signer.Sign(data)); err != nil {
+			return err
+		}
+		fmt.Printf("Signed %s in %s%s\n", path, path, repository.SignatureSuffix)
+	}
+	return nil
+}
+
+// writeNewFile writes data to a file that must not exist, so that keys are never overwritten.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
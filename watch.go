@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/signal"
+
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// runWatch prints the changes to the policy as JSON lines until interrupted.
+func runWatch(args []string) error {
+	fs, repoFlags := newCommandFlagSet("watch")
+	fs.Parse(args)
+
+	repo, err := repoFlags.open()
+	if err != nil {
+		return err
+	}
+	watcher, ok := repo.(repository.WatchRepository)
+	if !ok {
+		return errors.New("the policy repository does not support watching")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for e := range events {
+		if err = enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/audit"
+	"github.com/kismatic/kubernetes-rbac/authorization"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// BatchPath is the path under which the BatchHandler is served.
+const BatchPath = "/authorize/batch"
+
+// MaxBatchSize is the maximum number of reviews in a batch.
+const MaxBatchSize = 1000
+
+// MaxBatchBodySize is the size above which BatchReviews are rejected.
+const MaxBatchBodySize = 8 << 20
+
+// BatchReview is the body of a batch request. It holds either independent SubjectAccessReviews,
+// or several actions of a single user.
+type BatchReview struct {
+	// Items are authorization.k8s.io/v1 or v1beta1 SubjectAccessReviews.
+	Items []SubjectAccessReview `json:"items,omitempty"`
+
+	// User performing the actions.
+	User string `json:"user,omitempty"`
+	// Groups of the user.
+	Groups []string `json:"groups,omitempty"`
+	// Actions of the user.
+	Actions []BatchAction `json:"actions,omitempty"`
+}
+
+// BatchAction is an action of a BatchReview. Exactly one of its attributes must be set.
+type BatchAction struct {
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+}
+
+// BatchResult is the response to a BatchReview.
+type BatchResult struct {
+	// Revision of the policy that every review was evaluated against. Empty if the policy
+	// could not be read.
+	Revision string `json:"revision,omitempty"`
+	// Results of the reviews, in the order of the request.
+	Results []SubjectAccessReviewStatus `json:"results"`
+}
+
+// BatchHandler evaluates a batch of reviews against a single snapshot of the policy, so that
+// the results are consistent even if the policy changes while they are evaluated:
+//
+//	POST /authorize/batch
+//
+// The decision cache is not used, as it may hold decisions of another revision. Like the
+// AuthorizationHandler, the results are subject to the enforcement mode of every review.
+type BatchHandler struct {
+	Repo repository.PolicyRepository
+	// FailurePolicy applies when the policy cannot be evaluated. Defaults to FailClosed.
+	FailurePolicy FailurePolicy
+	// Metrics, when set, record the decisions.
+	Metrics *Metrics
+	// Audit, when set, records the decisions.
+	Audit *audit.Logger
+	// Enforcement selects the enforcement mode of the reviews. Defaults to Enforce.
+	Enforcement *Enforcement
+}
+
+func (bh *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeStatus(w, newStatus(http.StatusMethodNotAllowed, StatusReasonMethodNotAllowed, fmt.Sprintf("method %s is not allowed, expected POST", r.Method)))
+		return
+	}
+
+	batch := &BatchReview{}
+	if s := decodeBody(w, r, MaxBatchBodySize, "BatchReview", batch); s != nil {
+		writeStatus(w, *s)
+		return
+	}
+	specs, causes := batch.specs()
+	if len(causes) > 0 {
+		s := newStatus(http.StatusBadRequest, StatusReasonInvalid, "invalid BatchReview")
+		s.Details = &StatusDetails{Causes: causes}
+		writeStatus(w, s)
+		return
+	}
+
+	start := time.Now()
+	result := BatchResult{Results: make([]SubjectAccessReviewStatus, len(specs))}
+	decisions := make([]authorization.Decision, len(specs))
+	modes := make([]EnforcementMode, len(specs))
+	for i := range specs {
+		modes[i] = bh.Enforcement.ModeOf(specs[i])
+	}
+	snap, err := repository.ReadSnapshot(bh.Repo)
+	if err != nil {
+		log.Printf("Error reading the policy: %v", err)
+		for i := range result.Results {
+			result.Results[i] = bh.FailurePolicy.status(err)
+		}
+	} else {
+		result.Revision = snap.Revision
+		rg := &authorization.RepoRuleGetter{Repo: &repository.Document{Policy: snap.Policy, AllowDanglingReferences: true}}
+		for i := range specs {
+			if modes[i] == EnforcementOff {
+				continue
+			}
+			ar := subjectAccessReviewToAuthRequest(&SubjectAccessReview{Spec: specs[i]})
+			decisions[i], err = authorization.Authorize(rg, &ar)
+			if err != nil {
+				log.Printf("Error authorizing request: %v", err)
+				result.Results[i] = bh.FailurePolicy.status(err)
+				continue
+			}
+			result.Results[i] = SubjectAccessReviewStatus{Allowed: decisions[i].Allowed}
+		}
+	}
+	bh.Metrics.since("batch", start)
+	for i := range specs {
+		if modes[i] == EnforcementOff {
+			result.Results[i] = notEnforced()
+			continue
+		}
+		bh.Metrics.observe(specs[i], result.Results[i])
+		if modes[i] == AuditOnly && !result.Results[i].Allowed {
+			bh.Metrics.observeAuditOnly(specs[i])
+		}
+		r := auditRecord("batch", specs[i], result.Results[i], decisions[i], result.Revision, start)
+		if modes[i] != Enforce {
+			r.Enforcement = string(modes[i])
+		}
+		bh.Audit.Log(r)
+		result.Results[i] = bh.Enforcement.response(modes[i], result.Results[i])
+	}
+	log.Printf("Evaluated a batch of %d reviews at revision %s", len(specs), result.Revision)
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// specs returns the specs of the reviews of the batch, or the reasons why it cannot be evaluated.
+func (b *BatchReview) specs() ([]SubjectAccessReviewSpec, []StatusCause) {
+	specs := []SubjectAccessReviewSpec{}
+	causes := []StatusCause{}
+	switch {
+	case len(b.Items) > 0 && (len(b.Actions) > 0 || b.User != "" || len(b.Groups) > 0):
+		return nil, append(causes, StatusCause{Type: CauseTypeFieldValueInvalid, Message: "items are mutually exclusive with user, groups and actions", Field: "items"})
+	case len(b.Items) > 0:
+		for i, sar := range b.Items {
+			specs = append(specs, sar.Spec)
+			for _, c := range validateSpec(sar.Spec) {
+				c.Field = fmt.Sprintf("items[%d].%s", i, c.Field)
+				causes = append(causes, c)
+			}
+		}
+	case len(b.Actions) > 0:
+		if b.User == "" && len(b.Groups) == 0 {
+			causes = append(causes, StatusCause{Type: CauseTypeFieldValueRequired, Message: "at least one of user and groups is required", Field: "user"})
+		}
+		for i, a := range b.Actions {
+			specs = append(specs, SubjectAccessReviewSpec{ResourceAttributes: a.ResourceAttributes, NonResourceAttributes: a.NonResourceAttributes, User: b.User, Groups: b.Groups})
+			causes = append(causes, validateAttributes(a.ResourceAttributes, a.NonResourceAttributes, fmt.Sprintf("actions[%d].", i))...)
+		}
+	default:
+		return nil, append(causes, StatusCause{Type: CauseTypeFieldValueRequired, Message: "either items or actions are required", Field: "items"})
+	}
+	if len(specs) > MaxBatchSize {
+		return nil, []StatusCause{{Type: CauseTypeFieldValueInvalid, Message: fmt.Sprintf("a batch holds at most %d reviews, got %d", MaxBatchSize, len(specs)), Field: "items"}}
+	}
+	return specs, causes
+}
@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/audit"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+// snapshotOnlyRepo fails the test if the policy is read with anything but Snapshot.
+type snapshotOnlyRepo struct {
+	*repository.Document
+	t         *testing.T
+	snapshots int
+	err       error
+}
+
+func (r *snapshotOnlyRepo) Snapshot() (*repository.Snapshot, error) {
+	r.snapshots++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.Document.Snapshot()
+}
+
+func (r *snapshotOnlyRepo) ListRoleBindings(namespace string, opts repository.ListOptions) (*api.RoleBindingList, error) {
+	r.t.Errorf("Expected the policy to be read from a snapshot")
+	return r.Document.ListRoleBindings(namespace, opts)
+}
+
+func batch(t *testing.T, h *BatchHandler, payload string) BatchResult {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", BatchPath, strings.NewReader(payload)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	result := BatchResult{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Error decoding result: %v", err)
+	}
+	return result
+}
+
+func TestBatchReview(t *testing.T) {
+	policy := testPolicy(t)
+	repo := &snapshotOnlyRepo{Document: policy, t: t}
+	h := &BatchHandler{Repo: repo}
+
+	items := `{"items":[
+		{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"namespace":"payments","verb":"get","resource":"pods"},"user":"alice","groups":["developers"]}},
+		{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1beta1","spec":{"resourceAttributes":{"namespace":"payments","verb":"delete","resource":"pods"},"user":"alice","group":["developers"]}},
+		{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1beta1","spec":{"nonResourceAttributes":{"path":"/metrics","verb":"get"},"user":"prometheus","group":["system:serviceaccounts:monitoring"]}}
+	]}`
+	result := batch(t, h, items)
+	if fmt.Sprint(allowed(result)) != "[true false true]" {
+		t.Errorf("Expected results [true false true], got %+v", result.Results)
+	}
+	if result.Revision != policy.Revision() || repo.snapshots != 1 {
+		t.Errorf("Expected every review to be evaluated at revision %s of a single snapshot, got revision %s of %d snapshots", policy.Revision(), result.Revision, repo.snapshots)
+	}
+
+	actions := `{"user":"alice","groups":["developers"],"actions":[
+		{"resourceAttributes":{"namespace":"payments","verb":"create","resource":"deployments","group":"apps"}},
+		{"resourceAttributes":{"namespace":"payments","verb":"list","resource":"deployments","group":"apps"}},
+		{"resourceAttributes":{"namespace":"default","verb":"list","resource":"pods"}},
+		{"nonResourceAttributes":{"path":"/metrics","verb":"get"}}
+	]}`
+	if got := fmt.Sprint(allowed(batch(t, h, actions))); got != "[false true false false]" {
+		t.Errorf("Expected results [false true false false], got %s", got)
+	}
+
+	// Every review is subject to the failure policy when the policy cannot be read
+	repo.err = errors.New("the policy is not trusted")
+	result = batch(t, h, actions)
+	for i, s := range result.Results {
+		if s.Allowed || !s.Denied || s.EvaluationError != "the policy is not trusted" {
+			t.Errorf("Expected result %d to be denied with the evaluation error, got %+v", i, s)
+		}
+	}
+	if len(result.Results) != 4 || result.Revision != "" {
+		t.Errorf("Expected 4 results without a revision, got %+v", result)
+	}
+}
+
+func TestBatchEnforcementModes(t *testing.T) {
+	enforcement, err := ParseEnforcement("enforce", []string{"payments=audit-only", "monitoring=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	// The audit-only denials are recorded even though denials are not sampled
+	auditLog, err := audit.NewLogger(audit.Options{AllowedSampleRate: 1, DeniedSampleRate: 0}, &audit.WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &BatchHandler{Repo: testPolicy(t), Audit: auditLog, Enforcement: enforcement}
+
+	result := batch(t, h, `{"user":"alice","groups":["developers"],"actions":[
+		{"resourceAttributes":{"namespace":"default","verb":"delete","resource":"pods"}},
+		{"resourceAttributes":{"namespace":"payments","verb":"delete","resource":"pods"}},
+		{"resourceAttributes":{"namespace":"monitoring","verb":"delete","resource":"pods"}}
+	]}`)
+	auditLog.Close()
+	if s := result.Results[0]; s.Allowed {
+		t.Errorf("Expected the denial to be enforced in the default namespace, got %+v", s)
+	}
+	if s := result.Results[1]; !s.Allowed || !strings.Contains(s.Reason, "only audited") {
+		t.Errorf("Expected the denial to be allowed in audit-only mode, got %+v", s)
+	}
+	if s := result.Results[2]; s.Allowed || s.Denied {
+		t.Errorf("Expected no opinion when enforcement is off, got %+v", s)
+	}
+
+	r := audit.Record{}
+	if err = json.Unmarshal(out.Bytes(), &r); err != nil || r.Endpoint != "batch" || r.Decision != audit.Denied || r.Enforcement != audit.AuditOnly || r.Request.Namespace != "payments" {
+		t.Errorf("Expected only the audit-only denial to be recorded, got %s", out.String())
+	}
+}
+
+func allowed(result BatchResult) []bool {
+	allowed := []bool{}
+	for _, s := range result.Results {
+		allowed = append(allowed, s.Allowed)
+	}
+	return allowed
+}
+
+func TestInvalidBatchesAreRejected(t *testing.T) {
+	h := &BatchHandler{Repo: testPolicy(t)}
+	tooMany := `{"user":"alice","actions":[` + strings.Repeat(`{"nonResourceAttributes":{"path":"/","verb":"get"}},`, MaxBatchSize) + `{"nonResourceAttributes":{"path":"/","verb":"get"}}]}`
+	cases := []struct {
+		payload string
+		fields  []string
+	}{
+		{`{}`, []string{"items"}},
+		{`{"user":"alice"}`, []string{"items"}},
+		{`{"user":"alice","items":[{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"nonResourceAttributes":{"path":"/","verb":"get"},"user":"alice"}}]}`, []string{"items"}},
+		{`{"items":[{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"nonResourceAttributes":{"path":"/","verb":"get"},"user":"alice"}},{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"nonResourceAttributes":{"verb":"get"}}}]}`, []string{"items[1].spec.nonResourceAttributes.path", "items[1].spec.user"}},
+		{`{"actions":[{"nonResourceAttributes":{"path":"/","verb":"get"}},{}]}`, []string{"user", "actions[1].resourceAttributes"}},
+		{tooMany, []string{"items"}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", BatchPath, strings.NewReader(c.payload)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %.200s, got %d", c.payload, w.Code)
+			continue
+		}
+		s := Status{}
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatalf("Error decoding status: %v", err)
+		}
+		if s.Reason != StatusReasonInvalid || s.Details == nil || len(s.Details.Causes) != len(c.fields) {
+			t.Errorf("Expected invalid status with causes %v for %.200s, got %+v", c.fields, c.payload, s)
+			continue
+		}
+		for i, f := range c.fields {
+			if s.Details.Causes[i].Field != f {
+				t.Errorf("Expected cause for field %s, got %+v", f, s.Details.Causes[i])
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", BatchPath, strings.NewReader(`{"items":[{"kind":"SubjectAccessReview","apiVersion":"v1"}]}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"reason":"BadRequest"`) {
+		t.Errorf("Expected bad request status for an unsupported review, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", BatchPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+}
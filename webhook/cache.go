@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kismatic/kubernetes-rbac/authorization"
+)
+
+// CacheStatsPath is the path under which the CacheStatsHandler is served.
+const CacheStatsPath = "/authorize/cache"
+
+// CacheStatsHandler serves the statistics of the decision cache:
+//
+//	GET /authorize/cache
+type CacheStatsHandler struct {
+	Cache *authorization.DecisionCache
+}
+
+// cacheStats is the body of the response, with the ratio of decisions served from the cache.
+type cacheStats struct {
+	authorization.CacheStats
+	HitRatio float64 `json:"hitRatio"`
+}
+
+func (ch *CacheStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, newStatus(http.StatusMethodNotAllowed, StatusReasonMethodNotAllowed, "method "+r.Method+" is not allowed, expected GET"))
+		return
+	}
+	s := cacheStats{CacheStats: ch.Cache.Stats()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+	payload, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EnforcementMode decides whether the decisions of the policy are enforced.
+type EnforcementMode string
+
+const (
+	// Enforce responds with the decision of the policy. It is the default.
+	Enforce EnforcementMode = "enforce"
+	// AuditOnly evaluates the policy and records its decisions, but does not deny requests, so
+	// that the policy can be rolled out to an existing cluster without breaking workloads.
+	AuditOnly EnforcementMode = "audit-only"
+	// EnforcementOff does not evaluate the policy, and responds with no opinion.
+	EnforcementOff EnforcementMode = "off"
+)
+
+// ParseEnforcementMode returns the enforcement mode with the given name.
+func ParseEnforcementMode(name string) (EnforcementMode, error) {
+	switch m := EnforcementMode(name); m {
+	case Enforce, AuditOnly, EnforcementOff:
+		return m, nil
+	default:
+		return "", fmt.Errorf("Invalid enforcement mode '%s': expected '%s', '%s' or '%s'", name, Enforce, AuditOnly, EnforcementOff)
+	}
+}
+
+// Enforcement selects the enforcement mode of the reviews, globally or by namespace. A nil
+// Enforcement enforces all the decisions.
+type Enforcement struct {
+	// Mode applies to the namespaces without a mode of their own, to cluster-scoped resources
+	// and to non-resource URLs. Defaults to Enforce.
+	Mode EnforcementMode
+	// Namespaces are the modes of the namespaces that do not use Mode.
+	Namespaces map[string]EnforcementMode
+	// NoOpinion responds with no opinion to the reviews that the policy denies in audit-only
+	// mode, leaving the decision to the other authorizers of the API server. Otherwise they
+	// are allowed.
+	NoOpinion bool
+}
+
+// ParseEnforcement returns the Enforcement with the global mode, and the modes of the
+// namespaces given as namespace=mode.
+func ParseEnforcement(mode string, namespaces []string) (*Enforcement, error) {
+	m, err := ParseEnforcementMode(mode)
+	if err != nil {
+		return nil, err
+	}
+	e := &Enforcement{Mode: m, Namespaces: map[string]EnforcementMode{}}
+	for _, ns := range namespaces {
+		parts := strings.SplitN(ns, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid namespace enforcement mode '%s': expected namespace=mode", ns)
+		}
+		if e.Namespaces[parts[0]], err = ParseEnforcementMode(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// ModeOf returns the enforcement mode of the review.
+func (e *Enforcement) ModeOf(spec SubjectAccessReviewSpec) EnforcementMode {
+	if e == nil {
+		return Enforce
+	}
+	if ra := spec.ResourceAttributes; ra != nil && ra.Namespace != "" {
+		if m, ok := e.Namespaces[ra.Namespace]; ok {
+			return m
+		}
+	}
+	if e.Mode == "" {
+		return Enforce
+	}
+	return e.Mode
+}
+
+// HasAuditOnly returns whether the reviews of any namespace are in the AuditOnly mode.
+func (e *Enforcement) HasAuditOnly() bool {
+	if e == nil {
+		return false
+	}
+	if e.Mode == AuditOnly {
+		return true
+	}
+	for _, m := range e.Namespaces {
+		if m == AuditOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// notEnforced is the status of the response to the reviews in the EnforcementOff mode.
+func notEnforced() SubjectAccessReviewStatus {
+	return SubjectAccessReviewStatus{Reason: "the RBAC policy is not enforced"}
+}
+
+// response returns the status of the response to a review that the policy decided with the
+// status, in the enforcement mode.
+func (e *Enforcement) response(mode EnforcementMode, status SubjectAccessReviewStatus) SubjectAccessReviewStatus {
+	if mode != AuditOnly || status.Allowed {
+		return status
+	}
+	response := SubjectAccessReviewStatus{
+		Allowed:         !e.NoOpinion,
+		EvaluationError: status.EvaluationError,
+		Reason:          "the RBAC policy would deny the request, but is only audited",
+	}
+	if status.Reason != "" {
+		response.Reason += ": " + status.Reason
+	}
+	return response
+}
@@ -2,21 +2,74 @@ package webhook
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/kismatic/kubernetes-rbac/audit"
 	"github.com/kismatic/kubernetes-rbac/authorization"
 )
 
+// MaxReviewBodySize is the size above which SubjectAccessReviews are rejected.
+const MaxReviewBodySize = 1 << 20
+
+// FailurePolicy decides the response to a SubjectAccessReview when the policy cannot be
+// evaluated, e.g. because the policy repository is unavailable.
+type FailurePolicy string
+
+const (
+	// FailClosed denies the request, so that no other authorizer can allow it. It is the default.
+	FailClosed FailurePolicy = "closed"
+	// FailOpen allows the request.
+	FailOpen FailurePolicy = "open"
+)
+
+// ParseFailurePolicy returns the failure policy with the given name.
+func ParseFailurePolicy(name string) (FailurePolicy, error) {
+	switch fp := FailurePolicy(name); fp {
+	case FailClosed, FailOpen:
+		return fp, nil
+	default:
+		return "", fmt.Errorf("Invalid failure policy '%s': expected '%s' or '%s'", name, FailClosed, FailOpen)
+	}
+}
+
 // AuthorizationHandler is the HTTP handler for the authorization webhook
 type AuthorizationHandler struct {
 	RuleGetter authorization.PolicyRuleGetter
+	// FailurePolicy applies when the policy cannot be evaluated. Defaults to FailClosed.
+	FailurePolicy FailurePolicy
+	// Cache, when set, caches the decisions. Its Revision must be set, or it must be invalidated
+	// when the policy changes.
+	Cache *authorization.DecisionCache
+	// Metrics, when set, record the decisions.
+	Metrics *Metrics
+	// Audit, when set, records the decisions.
+	Audit *audit.Logger
+	// Revision, when set, returns the revision of the policy being served, for the audit records.
+	Revision func() string
+	// Enforcement, when set, selects the namespaces whose decisions are only audited, or not
+	// made at all. Otherwise all the decisions are enforced.
+	Enforcement *Enforcement
 }
 
 func (ah *AuthorizationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeStatus(w, newStatus(http.StatusMethodNotAllowed, StatusReasonMethodNotAllowed, fmt.Sprintf("method %s is not allowed, expected POST", r.Method)))
+		return
+	}
+
 	sar := &SubjectAccessReview{}
-	if err := json.NewDecoder(r.Body).Decode(sar); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if s := decodeBody(w, r, MaxReviewBodySize, "SubjectAccessReview", sar); s != nil {
+		writeStatus(w, *s)
+		return
+	}
+	if causes := validateSpec(sar.Spec); len(causes) > 0 {
+		s := newStatus(http.StatusBadRequest, StatusReasonInvalid, "invalid SubjectAccessReview")
+		s.Details = &StatusDetails{Causes: causes}
+		writeStatus(w, s)
 		return
 	}
 
@@ -29,24 +82,124 @@ func (ah *AuthorizationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 		log.Printf("Resource Attributes: %+v\n", *sar.Spec.ResourceAttributes)
 	}
 
-	ar := subjectAccessReviewToAuthRequest(sar)
-
-	auth, err := authorization.IsAuthorized(ah.RuleGetter, &ar)
-	if err != nil {
-		sar.Status.Allowed = false
-		sar.Status.Reason = "Error authorizing request"
-		log.Printf("Error authorizing request: %v", err)
+	mode := ah.Enforcement.ModeOf(sar.Spec)
+	if mode == EnforcementOff {
+		sar.Status = notEnforced()
+	} else {
+		sar.Status = ah.authorize(sar.Spec, mode)
 	}
-	sar.Status.Allowed = auth
 
 	log.Printf("Responding with status: %+v\n", sar.Status)
 
+	// Respond in the API version of the request
 	payload, err := json.Marshal(sar)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// authorize evaluates the policy for the review, and returns the status of the response in the
+// enforcement mode.
+func (ah *AuthorizationHandler) authorize(spec SubjectAccessReviewSpec, mode EnforcementMode) SubjectAccessReviewStatus {
+	ar := subjectAccessReviewToAuthRequest(&SubjectAccessReview{Spec: spec})
+
+	authorize := authorization.Authorize
+	if ah.Cache != nil {
+		authorize = ah.Cache.Authorize
+	}
+	start := time.Now()
+	decision, err := authorize(ah.RuleGetter, &ar)
+	ah.Metrics.since("review", start)
+	status := SubjectAccessReviewStatus{Allowed: decision.Allowed}
+	if err != nil {
+		log.Printf("Error authorizing request: %v", err)
+		status = ah.FailurePolicy.status(err)
+	}
+	ah.Metrics.observe(spec, status)
+	if mode == AuditOnly && !status.Allowed {
+		log.Printf("Audit-only: the RBAC policy would deny the request")
+		ah.Metrics.observeAuditOnly(spec)
+	}
+
+	if ah.Audit != nil {
+		revision := ""
+		if ah.Revision != nil {
+			revision = ah.Revision()
+		}
+		r := auditRecord("review", spec, status, decision, revision, start)
+		if mode != Enforce {
+			r.Enforcement = string(mode)
+		}
+		ah.Audit.Log(r)
+	}
+	return ah.Enforcement.response(mode, status)
+}
+
+// status returns the status of a review whose policy could not be evaluated.
+func (fp FailurePolicy) status(err error) SubjectAccessReviewStatus {
+	status := SubjectAccessReviewStatus{EvaluationError: err.Error()}
+	if fp == FailOpen {
+		status.Allowed = true
+		status.Reason = "the RBAC policy could not be evaluated; allowed by the fail-open policy"
+	} else {
+		status.Denied = true
+		status.Reason = "the RBAC policy could not be evaluated; denied by the fail-closed policy"
+	}
+	return status
+}
+
+// validateSpec returns the reasons why the spec cannot be evaluated, if any.
+func validateSpec(spec SubjectAccessReviewSpec) []StatusCause {
+	causes := validateAttributes(spec.ResourceAttributes, spec.NonResourceAttributes, "spec.")
+	if spec.User == "" && len(spec.Groups) == 0 {
+		causes = append(causes, StatusCause{Type: CauseTypeFieldValueRequired, Message: "at least one of user and groups is required", Field: "spec.user"})
+	}
+	return causes
+}
+
+// validateAttributes returns the reasons why the attributes of an action cannot be evaluated,
+// if any. The fields of the causes start with the prefix.
+func validateAttributes(ra *ResourceAttributes, nra *NonResourceAttributes, prefix string) []StatusCause {
+	switch {
+	case ra != nil && nra != nil:
+		return []StatusCause{{Type: CauseTypeFieldValueInvalid, Message: "resourceAttributes and nonResourceAttributes are mutually exclusive", Field: prefix + "nonResourceAttributes"}}
+	case ra == nil && nra == nil:
+		return []StatusCause{{Type: CauseTypeFieldValueRequired, Message: "exactly one of resourceAttributes and nonResourceAttributes is required", Field: prefix + "resourceAttributes"}}
+	case nra != nil && nra.Path == "":
+		return []StatusCause{{Type: CauseTypeFieldValueRequired, Message: "the path is required", Field: prefix + "nonResourceAttributes.path"}}
+	}
+	return []StatusCause{}
+}
+
+// decodeBody decodes the JSON body of the request into v, reading at most limit bytes. It
+// returns the Status of the error response if the body is too large or malformed.
+func decodeBody(w http.ResponseWriter, r *http.Request, limit int64, kind string, v interface{}) *Status {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v)
+	if err == nil {
+		return nil
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s := newStatus(http.StatusRequestEntityTooLarge, StatusReasonRequestEntityTooLarge, fmt.Sprintf("%s is larger than %d bytes", kind, limit))
+		return &s
+	}
+	s := newStatus(http.StatusBadRequest, StatusReasonBadRequest, fmt.Sprintf("malformed %s: %v", kind, err))
+	return &s
+}
+
+// writeStatus writes the status as the body of an error response.
+func writeStatus(w http.ResponseWriter, s Status) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.Code)
 	w.Write(payload)
 }
 
@@ -64,7 +217,7 @@ func subjectAccessReviewToAuthRequest(sar *SubjectAccessReview) authorization.Re
 			Name:        sar.Spec.ResourceAttributes.Name,
 			Namespace:   sar.Spec.ResourceAttributes.Namespace,
 		}
-	} else {
+	} else if sar.Spec.NonResourceAttributes != nil {
 		ar.Action = authorization.APIAction{
 			Verb:           sar.Spec.NonResourceAttributes.Verb,
 			NonResourceURL: sar.Spec.NonResourceAttributes.Path,
@@ -72,3 +225,35 @@ func subjectAccessReviewToAuthRequest(sar *SubjectAccessReview) authorization.Re
 	}
 	return ar
 }
+
+// auditRecord returns the audit record of a review evaluated since the start time.
+func auditRecord(endpoint string, spec SubjectAccessReviewSpec, status SubjectAccessReviewStatus, decision authorization.Decision, revision string, start time.Time) audit.Record {
+	r := audit.Record{
+		Time:     start,
+		Endpoint: endpoint,
+		Request: audit.Request{
+			User:   spec.User,
+			Groups: spec.Groups,
+			UID:    spec.UID,
+		},
+		Reason:         decision.Reason,
+		Binding:        decision.Binding,
+		Revision:       revision,
+		LatencySeconds: time.Since(start).Seconds(),
+	}
+	if ra := spec.ResourceAttributes; ra != nil {
+		r.Request.Verb, r.Request.APIGroup, r.Request.Resource, r.Request.Subresource = ra.Verb, ra.Group, ra.Resource, ra.Subresource
+		r.Request.Name, r.Request.Namespace = ra.Name, ra.Namespace
+	} else if nra := spec.NonResourceAttributes; nra != nil {
+		r.Request.Verb, r.Request.Path = nra.Verb, nra.Path
+	}
+	switch {
+	case status.EvaluationError != "":
+		r.Decision, r.Reason, r.EvaluationError = audit.Error, status.Reason, status.EvaluationError
+	case status.Allowed:
+		r.Decision = audit.Allowed
+	default:
+		r.Decision = audit.Denied
+	}
+	return r
+}
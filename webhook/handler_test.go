@@ -0,0 +1,416 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/api"
+	"github.com/kismatic/kubernetes-rbac/audit"
+	"github.com/kismatic/kubernetes-rbac/authorization"
+	"github.com/kismatic/kubernetes-rbac/metrics"
+	"github.com/kismatic/kubernetes-rbac/repository"
+)
+
+var update = flag.Bool("update", false, "Update the golden responses in testdata")
+
+func testHandler(t *testing.T) *AuthorizationHandler {
+	return &AuthorizationHandler{RuleGetter: &authorization.RepoRuleGetter{Repo: testPolicy(t)}}
+}
+
+// testPolicy lets the developers group read the workloads of the payments namespace, and the
+// service accounts of the monitoring namespace read the metrics.
+func testPolicy(t *testing.T) *repository.Document {
+	repo := &repository.Document{}
+	_, err := repo.Apply(api.Policy{
+		Roles: []api.Role{{
+			Name:      "developer",
+			Namespace: "payments",
+			Rules: []api.PolicyRule{{
+				Verbs:     []string{"get", "list", "watch"},
+				APIGroups: []string{"", "apps"},
+				Resources: []string{"pods", "deployments"},
+			}},
+		}},
+		RoleBindings: []api.RoleBinding{{
+			Name:      "developers",
+			Namespace: "payments",
+			Subjects:  []api.Subject{{Kind: api.GroupKind, Name: "developers"}},
+			RoleRef:   api.ObjectReference{Kind: api.RoleKind, Name: "developer", Namespace: "payments"},
+		}},
+		ClusterRoles: []api.ClusterRole{{
+			Name:  "metrics-reader",
+			Rules: []api.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}}},
+		}},
+		ClusterRoleBindings: []api.ClusterRoleBinding{{
+			Name:     "monitoring",
+			Subjects: []api.Subject{{Kind: api.GroupKind, Name: "system:serviceaccounts:monitoring"}},
+			RoleRef:  api.ObjectReference{Kind: api.ClusterRoleKind, Name: "metrics-reader"},
+		}},
+	}, repository.ApplyOptions{})
+	if err != nil {
+		t.Fatalf("Error creating policy: %v", err)
+	}
+	return repo
+}
+
+// TestGoldenPayloads replays SubjectAccessReviews in the format sent by Kubernetes API servers,
+// and compares the responses to the golden responses in testdata. Run with -update to rewrite them.
+func TestGoldenPayloads(t *testing.T) {
+	h := testHandler(t)
+	requests, err := filepath.Glob(filepath.Join("testdata", "*.request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) == 0 {
+		t.Fatalf("No golden payloads found in testdata")
+	}
+
+	for _, path := range requests {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d: %s", path, w.Code, w.Body.String())
+			continue
+		}
+
+		golden := strings.TrimSuffix(path, ".request.json") + ".response.json"
+		got := append(w.Body.Bytes(), '\n')
+		if *update {
+			if err = ioutil.WriteFile(golden, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: expected response:\n%s\ngot:\n%s", path, expected, got)
+		}
+	}
+}
+
+func TestUnsupportedReviewsAreRejected(t *testing.T) {
+	h := testHandler(t)
+	cases := []string{
+		`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v2","spec":{"user":"alice"}}`,
+		`{"kind":"SelfSubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{}}`,
+		`{"kind":"SubjectAccessReview","spec":{"user":"alice"}}`,
+		`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"groups":"developers"}}`,
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader(c)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", c, w.Code)
+		}
+	}
+}
+
+func TestGroupsFieldDependsOnVersion(t *testing.T) {
+	cases := []struct {
+		payload string
+		groups  []string
+	}{
+		{`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"groups":["a"],"group":["b"]}}`, []string{"a"}},
+		{`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1beta1","spec":{"groups":["a"],"group":["b"]}}`, []string{"b"}},
+	}
+	for _, c := range cases {
+		sar := &SubjectAccessReview{}
+		if err := sar.UnmarshalJSON([]byte(c.payload)); err != nil {
+			t.Fatalf("Error decoding %s: %v", c.payload, err)
+		}
+		if len(sar.Spec.Groups) != 1 || sar.Spec.Groups[0] != c.groups[0] {
+			t.Errorf("Expected groups %v decoding %s, got %v", c.groups, c.payload, sar.Spec.Groups)
+		}
+	}
+}
+
+func TestInvalidReviewsAreRejected(t *testing.T) {
+	h := testHandler(t)
+	cases := []struct {
+		payload string
+		fields  []string
+	}{
+		{`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"user":"alice"}}`, []string{"spec.resourceAttributes"}},
+		{`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"verb":"get"},"nonResourceAttributes":{"path":"/api","verb":"get"},"user":"alice"}}`, []string{"spec.nonResourceAttributes"}},
+		{`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"nonResourceAttributes":{"verb":"get"}}}`, []string{"spec.nonResourceAttributes.path", "spec.user"}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader(c.payload)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", c.payload, w.Code)
+			continue
+		}
+		s := Status{}
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatalf("Error decoding status: %v", err)
+		}
+		if s.Reason != StatusReasonInvalid || s.Details == nil || len(s.Details.Causes) != len(c.fields) {
+			t.Errorf("Expected invalid status with causes %v for %s, got %+v", c.fields, c.payload, s)
+			continue
+		}
+		for i, f := range c.fields {
+			if s.Details.Causes[i].Field != f {
+				t.Errorf("Expected cause for field %s, got %+v", f, s.Details.Causes[i])
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader("{")))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"reason":"BadRequest"`) {
+		t.Errorf("Expected bad request status for a malformed body, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/authorize", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestOversizedBodiesAreRejected(t *testing.T) {
+	handlers := []struct {
+		path  string
+		h     http.Handler
+		limit int
+	}{
+		{"/authorize", testHandler(t), MaxReviewBodySize},
+		{BatchPath, &BatchHandler{Repo: testPolicy(t)}, MaxBatchBodySize},
+	}
+	for _, c := range handlers {
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, httptest.NewRequest("POST", c.path, strings.NewReader(strings.Repeat(" ", c.limit)+"{}")))
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"reason":"RequestEntityTooLarge"`) {
+			t.Errorf("Expected status 413 for an oversized body on %s, got %d: %s", c.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+type failingRuleGetter struct{}
+
+func (failingRuleGetter) GetApplicableRules(user string, groups []string, namespace string) ([]api.PolicyRule, error) {
+	return nil, errors.New("the policy is not trusted")
+}
+
+func TestFailurePolicy(t *testing.T) {
+	payload := `{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"verb":"get","resource":"pods"},"user":"alice"}}`
+	cases := []struct {
+		policy  FailurePolicy
+		allowed bool
+		denied  bool
+	}{
+		{"", false, true},
+		{FailClosed, false, true},
+		{FailOpen, true, false},
+	}
+	for _, c := range cases {
+		h := &AuthorizationHandler{RuleGetter: failingRuleGetter{}, FailurePolicy: c.policy}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader(payload)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		sar := &SubjectAccessReview{}
+		if err := json.Unmarshal(w.Body.Bytes(), sar); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		if sar.Status.Allowed != c.allowed || sar.Status.Denied != c.denied {
+			t.Errorf("Expected allowed %v and denied %v with failure policy '%s', got %+v", c.allowed, c.denied, c.policy, sar.Status)
+		}
+		if sar.Status.EvaluationError != "the policy is not trusted" {
+			t.Errorf("Expected the error to be reported as the evaluation error, got %+v", sar.Status)
+		}
+	}
+
+	if _, err := ParseFailurePolicy("ignore"); err == nil {
+		t.Errorf("Expected error parsing an unknown failure policy")
+	}
+}
+
+func TestDecisionCache(t *testing.T) {
+	h := testHandler(t)
+	h.Cache = authorization.NewDecisionCache(10, time.Minute, time.Minute)
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "v1-resource.request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", bytes.NewReader(body)))
+		if !strings.Contains(w.Body.String(), `"allowed":true`) {
+			t.Fatalf("Expected request to be allowed, got %s", w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	(&CacheStatsHandler{Cache: h.Cache}).ServeHTTP(w, httptest.NewRequest("GET", CacheStatsPath, nil))
+	s := cacheStats{}
+	if err = json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatalf("Error decoding cache statistics: %v", err)
+	}
+	if s.Hits != 2 || s.Misses != 1 || s.Entries != 1 || s.HitRatio < 0.66 || s.HitRatio > 0.67 {
+		t.Errorf("Expected 2 hits and 1 miss, got %+v", s)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	h := testHandler(t)
+	h.Metrics = NewMetrics(registry)
+	for _, name := range []string{"v1-resource", "v1-denied", "v1beta1-nonresource"} {
+		body, err := ioutil.ReadFile(filepath.Join("testdata", name+".request.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/authorize", bytes.NewReader(body)))
+	}
+	bh := &BatchHandler{Repo: testPolicy(t), Metrics: h.Metrics}
+	bh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", BatchPath, strings.NewReader(`{"user":"alice","groups":["developers"],"actions":[{"resourceAttributes":{"namespace":"payments","verb":"get","resource":"pods"}}]}`)))
+	failing := &AuthorizationHandler{RuleGetter: failingRuleGetter{}, Metrics: h.Metrics}
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/authorize", strings.NewReader(`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"verb":"get","resource":"pods"},"user":"alice"}}`)))
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", metrics.Path, nil))
+	for _, expected := range []string{
+		`kubernetes_rbac_decisions_total{result="allowed",verb="list",resource="pods",namespace="payments"} 1`,
+		`kubernetes_rbac_decisions_total{result="allowed",verb="get",resource="pods",namespace="payments"} 1`,
+		`kubernetes_rbac_decisions_total{result="allowed",verb="get",resource="",namespace=""} 1`,
+		`kubernetes_rbac_decisions_total{result="error",verb="get",resource="pods",namespace=""} 1`,
+		`kubernetes_rbac_evaluation_duration_seconds_count{endpoint="review"} 4`,
+		`kubernetes_rbac_evaluation_duration_seconds_count{endpoint="batch"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected+"\n") {
+			t.Errorf("Expected %s, got:\n%s", expected, w.Body.String())
+		}
+	}
+	if !strings.Contains(w.Body.String(), `kubernetes_rbac_decisions_total{result="denied",`) {
+		t.Errorf("Expected the denied review to be counted, got:\n%s", w.Body.String())
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	out := &bytes.Buffer{}
+	auditLog, err := audit.NewLogger(audit.Options{AllowedSampleRate: 1, DeniedSampleRate: 1}, &audit.WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler(t)
+	h.Audit = auditLog
+	h.Revision = func() string { return "42" }
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "v1-resource.request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/authorize", bytes.NewReader(body)))
+	bh := &BatchHandler{Repo: testPolicy(t), Audit: auditLog}
+	bh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", BatchPath, strings.NewReader(`{"user":"alice","groups":["developers"],"actions":[{"resourceAttributes":{"namespace":"payments","verb":"delete","resource":"pods"}}]}`)))
+	if err = auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []audit.Record{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		r := audit.Record{}
+		if err = json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Error decoding audit record %s: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %s", out.String())
+	}
+	if r := records[0]; r.Endpoint != "review" || r.Decision != audit.Allowed || r.Revision != "42" || r.Binding == nil || r.Binding.String() != "RoleBinding payments/developers" {
+		t.Errorf("Expected the review to be allowed by the developers binding at revision 42, got %s", out.String())
+	}
+	if r := records[1]; r.Endpoint != "batch" || r.Decision != audit.Denied || r.Revision == "" || r.Binding != nil || r.Request.Verb != "delete" {
+		t.Errorf("Expected the batch review to be denied at the revision of the snapshot, got %s", out.String())
+	}
+}
+
+func TestEnforcementModes(t *testing.T) {
+	if _, err := ParseEnforcement("audit", nil); err == nil {
+		t.Errorf("Expected error for an unknown enforcement mode")
+	}
+	if _, err := ParseEnforcement("enforce", []string{"payments"}); err == nil {
+		t.Errorf("Expected error for a namespace without a mode")
+	}
+	enforcement, err := ParseEnforcement("enforce", []string{"payments=audit-only", "monitoring=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enforcement.HasAuditOnly() {
+		t.Errorf("Expected the audit-only namespace to be reported")
+	}
+	if enforced, _ := ParseEnforcement("enforce", []string{"monitoring=off"}); enforced.HasAuditOnly() {
+		t.Errorf("Expected no audit-only mode")
+	}
+
+	out := &bytes.Buffer{}
+	auditLog, err := audit.NewLogger(audit.Options{AllowedSampleRate: 1, DeniedSampleRate: 1}, &audit.WriterSink{W: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := metrics.NewRegistry()
+	h := testHandler(t)
+	h.Enforcement, h.Audit, h.Metrics = enforcement, auditLog, NewMetrics(registry)
+	review := func(namespace string) SubjectAccessReviewStatus {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/authorize", strings.NewReader(`{"kind":"SubjectAccessReview","apiVersion":"authorization.k8s.io/v1","spec":{"resourceAttributes":{"namespace":"`+namespace+`","verb":"delete","resource":"pods"},"user":"alice","groups":["developers"]}}`)))
+		sar := SubjectAccessReview{}
+		if err := json.Unmarshal(w.Body.Bytes(), &sar); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+		return sar.Status
+	}
+
+	if s := review("default"); s.Allowed {
+		t.Errorf("Expected the denial to be enforced in the default namespace, got %+v", s)
+	}
+	if s := review("payments"); !s.Allowed || !strings.Contains(s.Reason, "only audited") {
+		t.Errorf("Expected the denial to be allowed in audit-only mode, got %+v", s)
+	}
+	if s := review("monitoring"); s.Allowed || s.Denied {
+		t.Errorf("Expected no opinion when enforcement is off, got %+v", s)
+	}
+	enforcement.NoOpinion = true
+	if s := review("payments"); s.Allowed || s.Denied || s.Reason == "" {
+		t.Errorf("Expected no opinion on the denial in audit-only mode, got %+v", s)
+	}
+	auditLog.Close()
+
+	records := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(records) != 3 {
+		t.Fatalf("Expected the reviews evaluated with the policy to be audited, got %s", out.String())
+	}
+	for _, line := range records[1:] {
+		r := audit.Record{}
+		if err = json.Unmarshal([]byte(line), &r); err != nil || r.Decision != audit.Denied || r.Enforcement != audit.AuditOnly || r.Request.Namespace != "payments" {
+			t.Errorf("Expected the audit-only denial to be recorded, got %s", line)
+		}
+	}
+	if strings.Contains(records[0], "enforcement") {
+		t.Errorf("Expected the enforced denial to have no enforcement mode, got %s", records[0])
+	}
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", metrics.Path, nil))
+	if expected := `kubernetes_rbac_audit_only_denials_total{verb="delete",resource="pods",namespace="payments"} 2`; !strings.Contains(w.Body.String(), expected+"\n") {
+		t.Errorf("Expected %s, got:\n%s", expected, w.Body.String())
+	}
+}
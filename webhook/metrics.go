@@ -0,0 +1,102 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/kismatic/kubernetes-rbac/authorization"
+	"github.com/kismatic/kubernetes-rbac/metrics"
+)
+
+// Results of the decisions counted by Metrics.
+const (
+	resultAllowed = "allowed"
+	resultDenied  = "denied"
+	resultError   = "error"
+)
+
+// Metrics of the authorization handlers.
+type Metrics struct {
+	// Decisions counts the reviews by result, verb, resource and namespace. Non-resource reviews
+	// have an empty resource and namespace.
+	Decisions *metrics.CounterVec
+	// Evaluations records how long it takes to evaluate a review, or a batch of reviews.
+	Evaluations *metrics.HistogramVec
+	// Lookups records how long the lookups of the policy take while evaluating reviews, when
+	// the repository of the rule getter is a metrics.LookupRepository.
+	Lookups *metrics.HistogramVec
+	// AuditOnlyDenials counts the reviews that the policy denies but that are not denied in
+	// audit-only mode, by verb, resource and namespace.
+	AuditOnlyDenials *metrics.CounterVec
+}
+
+// NewMetrics returns the metrics of the authorization handlers, registered with the registry.
+func NewMetrics(r *metrics.Registry) *Metrics {
+	m := &Metrics{
+		Decisions:   metrics.NewCounterVec("kubernetes_rbac_decisions_total", "Number of authorization decisions.", "result", "verb", "resource", "namespace"),
+		Evaluations: metrics.NewHistogramVec("kubernetes_rbac_evaluation_duration_seconds", "Time taken to evaluate a review or a batch of reviews.", metrics.DefaultBuckets, "endpoint"),
+		Lookups:     metrics.NewHistogramVec("kubernetes_rbac_repository_lookup_duration_seconds", "Time taken to look up the policy while evaluating a review.", metrics.DefaultBuckets, "operation"),
+
+		AuditOnlyDenials: metrics.NewCounterVec("kubernetes_rbac_audit_only_denials_total", "Number of reviews denied by the policy that were not denied because of the audit-only enforcement mode.", "verb", "resource", "namespace"),
+	}
+	r.Register(m.Decisions, m.Evaluations, m.Lookups, m.AuditOnlyDenials)
+	return m
+}
+
+// observe records the decision of a review.
+func (m *Metrics) observe(spec SubjectAccessReviewSpec, status SubjectAccessReviewStatus) {
+	if m == nil {
+		return
+	}
+	result := resultDenied
+	switch {
+	case status.EvaluationError != "":
+		result = resultError
+	case status.Allowed:
+		result = resultAllowed
+	}
+	if ra := spec.ResourceAttributes; ra != nil {
+		m.Decisions.Inc(result, ra.Verb, ra.Resource, ra.Namespace)
+	} else if nra := spec.NonResourceAttributes; nra != nil {
+		m.Decisions.Inc(result, nra.Verb, "", "")
+	}
+}
+
+// observeAuditOnly records a review that the policy denies, but that is not denied in
+// audit-only mode.
+func (m *Metrics) observeAuditOnly(spec SubjectAccessReviewSpec) {
+	if m == nil {
+		return
+	}
+	if ra := spec.ResourceAttributes; ra != nil {
+		m.AuditOnlyDenials.Inc(ra.Verb, ra.Resource, ra.Namespace)
+	} else if nra := spec.NonResourceAttributes; nra != nil {
+		m.AuditOnlyDenials.Inc(nra.Verb, "", "")
+	}
+}
+
+// since records the time elapsed since the start of an evaluation.
+func (m *Metrics) since(endpoint string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.Evaluations.Observe(time.Since(start).Seconds(), endpoint)
+}
+
+// RegisterCacheMetrics registers the statistics of the decision cache with the registry.
+func RegisterCacheMetrics(r *metrics.Registry, c *authorization.DecisionCache) {
+	r.Register(
+		metrics.NewCounterFunc("kubernetes_rbac_decision_cache_requests_total", "Number of decisions served from the cache, or evaluated.", []string{"result"}, func() []metrics.Sample {
+			s := c.Stats()
+			return []metrics.Sample{{LabelValues: []string{"hit"}, Value: float64(s.Hits)}, {LabelValues: []string{"miss"}, Value: float64(s.Misses)}}
+		}),
+		metrics.NewCounterFunc("kubernetes_rbac_decision_cache_evictions_total", "Number of decisions removed from the cache to make room for new ones.", nil, func() []metrics.Sample {
+			return metrics.Value(float64(c.Stats().Evictions))
+		}),
+		metrics.NewCounterFunc("kubernetes_rbac_decision_cache_invalidations_total", "Number of times the cache was emptied because the policy changed.", nil, func() []metrics.Sample {
+			return metrics.Value(float64(c.Stats().Invalidations))
+		}),
+		metrics.NewGaugeFunc("kubernetes_rbac_decision_cache_entries", "Number of decisions in the cache.", nil, func() []metrics.Sample {
+			return metrics.Value(float64(c.Stats().Entries))
+		}),
+	)
+}
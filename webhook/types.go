@@ -1,5 +1,21 @@
 package webhook
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// GroupName is the API group of the SubjectAccessReview.
+	GroupName = "authorization.k8s.io"
+	// V1 is the API version of the authorization.k8s.io/v1 SubjectAccessReview.
+	V1 = GroupName + "/v1"
+	// V1beta1 is the API version of the authorization.k8s.io/v1beta1 SubjectAccessReview.
+	V1beta1 = GroupName + "/v1beta1"
+	// Kind is the kind of the SubjectAccessReview.
+	Kind = "SubjectAccessReview"
+)
+
 // SubjectAccessReview checks whether or not a user or group can perform an action.
 type SubjectAccessReview struct {
 	Kind string `json:"kind,omitempty"`
@@ -25,10 +41,23 @@ type SubjectAccessReviewSpec struct {
 	// If you specify "User" but not "Group", then is it interpreted as "What if User were not a member of any groups
 	User string `json:"user,omitempty"`
 	// Groups is the groups you're testing for.
-	Groups []string `json:"group,omitempty"`
+	Groups []string `json:"groups,omitempty"`
 	// Extra corresponds to the user.Info.GetExtra() method from the authenticator.  Since that is input to the authorizer
 	// it needs a reflection here.
 	Extra map[string][]string `json:"extra,omitempty"`
+	// UID information about the requesting user.
+	UID string `json:"uid,omitempty"`
+}
+
+// subjectAccessReviewSpecV1beta1 is the SubjectAccessReviewSpec of authorization.k8s.io/v1beta1,
+// which names the groups "group".
+type subjectAccessReviewSpecV1beta1 struct {
+	ResourceAttributes    *ResourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *NonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                 `json:"user,omitempty"`
+	Groups                []string               `json:"group,omitempty"`
+	Extra                 map[string][]string    `json:"extra,omitempty"`
+	UID                   string                 `json:"uid,omitempty"`
 }
 
 // ResourceAttributes includes the authorization attributes available for resource requests to the Authorizer interface
@@ -64,6 +93,121 @@ type NonResourceAttributes struct {
 type SubjectAccessReviewStatus struct {
 	// Allowed is required.  True if the action would be allowed, false otherwise.
 	Allowed bool `json:"allowed"`
+	// Denied is optional.  True if the action would be denied, otherwise false.  If both allowed is false and denied is
+	// false, then the authorizer has no opinion on whether to authorize the action.  Denied may not be true if Allowed
+	// is true.
+	Denied bool `json:"denied,omitempty"`
 	// Reason is optional.  It indicates why a request was allowed or denied.
 	Reason string `json:"reason,omitempty"`
+	// EvaluationError is an indication that some error occurred during the authorization check.
+	// It is entirely possible to get an error and be able to continue determine authorization status in spite of it.
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// subjectAccessReview is the wire format of a SubjectAccessReview, whose spec depends on the API version.
+type subjectAccessReview struct {
+	Kind       string                    `json:"kind,omitempty"`
+	APIVersion string                    `json:"apiVersion,omitempty"`
+	Spec       json.RawMessage           `json:"spec"`
+	Status     SubjectAccessReviewStatus `json:"status"`
+}
+
+// UnmarshalJSON decodes an authorization.k8s.io/v1 or v1beta1 SubjectAccessReview. Other kinds and
+// API versions are rejected.
+func (sar *SubjectAccessReview) UnmarshalJSON(data []byte) error {
+	wire := subjectAccessReview{}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	if wire.Kind != Kind {
+		return fmt.Errorf("unsupported kind '%s', expected '%s'", wire.Kind, Kind)
+	}
+	spec := SubjectAccessReviewSpec{}
+	switch wire.APIVersion {
+	case V1:
+		if len(wire.Spec) > 0 {
+			if err := json.Unmarshal(wire.Spec, &spec); err != nil {
+				return err
+			}
+		}
+	case V1beta1:
+		if len(wire.Spec) > 0 {
+			old := subjectAccessReviewSpecV1beta1{}
+			if err := json.Unmarshal(wire.Spec, &old); err != nil {
+				return err
+			}
+			spec = SubjectAccessReviewSpec(old)
+		}
+	default:
+		return fmt.Errorf("unsupported API version '%s', expected '%s' or '%s'", wire.APIVersion, V1, V1beta1)
+	}
+	*sar = SubjectAccessReview{Kind: wire.Kind, APIVersion: wire.APIVersion, Spec: spec, Status: wire.Status}
+	return nil
+}
+
+// MarshalJSON encodes the SubjectAccessReview in the format of its API version.
+func (sar SubjectAccessReview) MarshalJSON() ([]byte, error) {
+	var spec interface{} = sar.Spec
+	switch sar.APIVersion {
+	case V1:
+	case V1beta1:
+		spec = subjectAccessReviewSpecV1beta1(sar.Spec)
+	default:
+		return nil, fmt.Errorf("unsupported API version '%s', expected '%s' or '%s'", sar.APIVersion, V1, V1beta1)
+	}
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(subjectAccessReview{Kind: sar.Kind, APIVersion: sar.APIVersion, Spec: raw, Status: sar.Status})
+}
+
+// Reasons of the Status of error responses.
+const (
+	StatusReasonBadRequest            = "BadRequest"
+	StatusReasonInvalid               = "Invalid"
+	StatusReasonMethodNotAllowed      = "MethodNotAllowed"
+	StatusReasonRequestEntityTooLarge = "RequestEntityTooLarge"
+)
+
+// Types of the causes of an Invalid status.
+const (
+	CauseTypeFieldValueRequired = "FieldValueRequired"
+	CauseTypeFieldValueInvalid  = "FieldValueInvalid"
+)
+
+// Status is the body of error responses, in the format of the Kubernetes v1 Status, so that
+// the API server can report why a review was rejected.
+type Status struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	// Status is always "Failure"
+	Status string `json:"status"`
+	// Message is a human-readable description of the error.
+	Message string `json:"message"`
+	// Reason is a machine-readable description of the error, such as "BadRequest" or "Invalid".
+	Reason string `json:"reason"`
+	// Details lists the invalid fields of an Invalid review.
+	Details *StatusDetails `json:"details,omitempty"`
+	// Code is the HTTP status code of the response.
+	Code int `json:"code"`
+}
+
+// StatusDetails lists the causes of an error.
+type StatusDetails struct {
+	Causes []StatusCause `json:"causes,omitempty"`
+}
+
+// StatusCause describes an invalid field.
+type StatusCause struct {
+	// Type is a machine-readable description of the cause, such as "FieldValueRequired".
+	Type string `json:"reason"`
+	// Message is a human-readable description of the cause.
+	Message string `json:"message"`
+	// Field is the path of the invalid field, such as "spec.user".
+	Field string `json:"field"`
+}
+
+func newStatus(code int, reason, message string) Status {
+	return Status{Kind: "Status", APIVersion: "v1", Status: "Failure", Message: message, Reason: reason, Code: code}
 }